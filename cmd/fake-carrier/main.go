@@ -0,0 +1,47 @@
+// cmd/fake-carrier/main.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"nexus/internal/shipping"
+	"os"
+	"time"
+)
+
+// getEnv 从环境变量中读取配置。
+// 如果环境变量不存在，则返回提供的默认值。
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// 假承运商: 不依赖 Nacos、Kafka 等基础设施, 可以单独启动, 供 shipping-service 离线联调和端到端测试。
+// 在 nexus-shipping-carriers.yaml 中把一个 type: fake 的承运商指向这里即可。
+func main() {
+	addr := getEnv("FAKE_CARRIER_ADDR", ":8090")
+	step, err := time.ParseDuration(getEnv("FAKE_CARRIER_STEP", "1m"))
+	if err != nil {
+		log.Fatalf("invalid FAKE_CARRIER_STEP: %v", err)
+	}
+	latency, err := time.ParseDuration(getEnv("FAKE_CARRIER_LATENCY", "0s"))
+	if err != nil {
+		log.Fatalf("invalid FAKE_CARRIER_LATENCY: %v", err)
+	}
+
+	server := shipping.NewFakeCarrierServer(step, latency)
+	// 手动追加的轨迹推送到 shipping-service, 密钥与 nexus-shipping-carriers.yaml 中的 webhookSecret 一致;
+	// 密钥没有默认值, 推送时必须显式设置, 避免公开的默认密钥被用来伪造轨迹
+	server.WebhookURL = getEnv("FAKE_CARRIER_WEBHOOK_URL", "")
+	server.WebhookSecret = getEnv("FAKE_CARRIER_WEBHOOK_SECRET", "")
+	if server.WebhookURL != "" && server.WebhookSecret == "" {
+		log.Fatal("FAKE_CARRIER_WEBHOOK_SECRET is required when FAKE_CARRIER_WEBHOOK_URL is set")
+	}
+
+	log.Printf("Fake carrier listening on %s (step %s, latency %s)", addr, step, latency)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		log.Fatal(err)
+	}
+}
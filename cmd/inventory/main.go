@@ -2,15 +2,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/wangyingjie930/nexus-pkg/bootstrap"
 	"github.com/wangyingjie930/nexus-pkg/logger"
 	"github.com/wangyingjie930/nexus-pkg/zookeeper"
 	"net/http"
+	"nexus/internal/chaos"
+	"nexus/internal/config"
+	"nexus/internal/inventory"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"go.opentelemetry.io/otel/trace"
 
 	"go.opentelemetry.io/otel"
@@ -24,10 +36,21 @@ const (
 )
 
 var (
-	tracer trace.Tracer
-	zkConn *zookeeper.Conn // <<<< 3. 定义一个全局的ZooKeeper连接变量
+	tracer       trace.Tracer
+	zkConn       *zookeeper.Conn // <<<< 3. 定义一个全局的ZooKeeper连接变量
+	inventorySvc *inventory.Service
+	reconciler   *inventory.Reconciler
 )
 
+// getEnv 从环境变量中读取配置。
+// 如果环境变量不存在，则返回提供的默认值。
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
 func main() {
 	bootstrap.Init()
 
@@ -41,15 +64,73 @@ func main() {
 	// 服务关闭时，也需要关闭ZK连接
 	defer zkConn.Close()
 
+	// 分仓库存存放在 MySQL 中
+	db, err := sql.Open("mysql", bootstrap.GetCurrentConfig().Infra.Mysql.Addrs)
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("failed to open mysql")
+	}
+	defer db.Close()
+	// 低库存告警写入 notifications 主题，由 notification-service 统一投递
+	kafkaBrokers := strings.Split(bootstrap.GetCurrentConfig().Infra.Kafka.Brokers, ",")
+	notifier := inventory.NewKafkaNotifier(kafkaBrokers)
+	defer notifier.Close()
+	// 库存水位变化写入 stock-levels 主题，供 pricing-service 动态定价
+	stockPublisher := inventory.NewKafkaStockPublisher(kafkaBrokers)
+	defer stockPublisher.Close()
+
+	alertCooldown, err := time.ParseDuration(getEnv("INVENTORY_ALERT_COOLDOWN", "30m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid INVENTORY_ALERT_COOLDOWN")
+	}
+	inventorySvc = inventory.NewService(inventory.NewStore(db), inventory.Options{
+		DefaultStrategy: getEnv("INVENTORY_ALLOCATION_STRATEGY", inventory.StrategyFewestSplits),
+		Notifier:        notifier,
+		AlertCooldown:   alertCooldown,
+		AlertRecipient:  getEnv("INVENTORY_ALERT_RECIPIENT", "merchandising"),
+		StockPublisher:  stockPublisher,
+	})
+
+	// 对账任务: 核对预占记录与订单状态，释放孤儿预占
+	reconcileGrace, err := time.ParseDuration(getEnv("INVENTORY_RECONCILE_GRACE", "15m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid INVENTORY_RECONCILE_GRACE")
+	}
+	// 订单状态来自 order-service 的 order_state 表; 其中没有的订单 (例如 v1 Saga 创建的订单) 默认只报告不释放
+	reconciler = inventory.NewReconciler(inventorySvc,
+		inventory.NewHTTPOrderStateSource(getEnv("ORDER_SERVICE_BASE_URL", "http://localhost:8081")), reconcileGrace,
+		getEnv("INVENTORY_RECONCILE_RELEASE_NOT_FOUND", "false") == "true")
+	if interval, err := time.ParseDuration(getEnv("INVENTORY_RECONCILE_INTERVAL", "0")); err == nil && interval > 0 {
+		reconcileCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runScheduledReconcile(reconcileCtx, interval, getEnv("INVENTORY_RECONCILE_DRY_RUN", "true") == "true")
+	}
+
+	// 故障注入规则来自 Nacos，修改后热加载
+	injector := chaos.NewInjector(serviceName)
+	if err := config.Watch(chaos.DataID, injector.Update); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch chaos rules, fault injection disabled")
+	}
+
 	bootstrap.StartService(bootstrap.AppInfo{
 		ServiceName: serviceName,
 		Port:        8082,
 		RegisterHandlers: func(ctx bootstrap.AppCtx) {
 			tracer = otel.Tracer(serviceName)
-
-			ctx.Mux.HandleFunc("/check_stock", checkStockHandler)
-			ctx.Mux.HandleFunc("/reserve_stock", reserveStockHandler) // 新增：预占库存
-			ctx.Mux.HandleFunc("/release_stock", releaseStockHandler) // 新增：释放库存
+			chaos.RegisterSpanProcessor()
+
+			// 业务路由注册在 api 上，整体由故障注入中间件包住，规则对所有业务路由生效
+			api := http.NewServeMux()
+			api.HandleFunc("/check_stock", checkStockHandler)
+			api.HandleFunc("/reserve_stock", reserveStockHandler) // 新增：预占库存
+			api.HandleFunc("/release_stock", releaseStockHandler) // 新增：释放库存
+			api.HandleFunc("/thresholds", thresholdHandler)       // 新增：低库存补货点
+			api.HandleFunc("/restock_stock", restockHandler)      // 新增：补货
+			api.HandleFunc("/waitlist", waitlistHandler)          // 新增：到货提醒订阅
+			api.HandleFunc("/reconcile", reconcileHandler)        // 新增：孤儿预占对账
+			api.HandleFunc("/stock/import", importStockHandler)   // 新增：批量导入调整
+			api.HandleFunc("/stock/export", exportStockHandler)   // 新增：导出库存
+			ctx.Mux.Handle("/", injector.Wrap(api))
+			ctx.Mux.Handle("/metrics", promhttp.Handler())
 		},
 	})
 }
@@ -65,11 +146,15 @@ func reserveStockHandler(w http.ResponseWriter, r *http.Request) {
 	quantityStr := r.URL.Query().Get("quantity")
 	quantity, _ := strconv.Atoi(quantityStr)
 	orderID := r.URL.Query().Get("orderId")
+	// 分配策略和收货地址均为可选参数
+	strategy := r.URL.Query().Get("strategy")
+	dest := parseDestination(r)
 
 	span.SetAttributes(
 		attribute.String("item.id", itemId),
 		attribute.Int("item.quantity", quantity),
 		attribute.String("order.id", orderID),
+		attribute.String("allocation.strategy", strategy),
 	)
 
 	// <<<< 5. 在核心业务逻辑外层，加上分布式锁
@@ -98,29 +183,37 @@ func reserveStockHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// ------------------ START: 原有的核心业务逻辑 ------------------
-	// 故障注入点
-	if itemId == "item-faulty-123" && quantity > 10 {
-		logger.Ctx(ctx).Printf("Injecting fault for item %s", itemId)
-		time.Sleep(500 * time.Millisecond)
+	// 故障注入已经移到 chaos 中间件，由 Nacos 中的 nexus-chaos.yaml 配置
 
-		err := fmt.Errorf("inventory reserve failed for faulty item %s", itemId)
+	// 按分配策略从各仓库检查并扣减库存，这里现在是线程安全的了
+	reservation, err := inventorySvc.Reserve(ctx, orderID, itemId, int64(quantity), strategy, dest)
+	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-
-		http.Error(w, "Inventory service unavailable for this item", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, inventory.ErrInsufficientStock):
+			// 库存不足时提示用户可以订阅到货提醒
+			http.Error(w, fmt.Sprintf("%v, subscribe via POST /waitlist?itemId=%s&userId=<userId> to be notified when it is back", err, itemId), http.StatusConflict)
+		case errors.Is(err, inventory.ErrUnknownStrategy), errors.Is(err, inventory.ErrInvalidQuantity):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			logger.Ctx(ctx).Error().Err(err).Str("item", itemId).Msg("Failed to reserve stock")
+			http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// 模拟数据库库存检查和扣减，这里现在是线程安全的了
-	logger.Ctx(ctx).Println("Simulating stock check and deduction in database...")
-	time.Sleep(100 * time.Millisecond)
-
-	logger.Ctx(ctx).Printf("Stock reservation successful for item %s, order %s", itemId, orderID)
+	for _, a := range reservation.Allocations {
+		span.AddEvent("Stock allocated", trace.WithAttributes(
+			attribute.String("warehouse.id", a.WarehouseID),
+			attribute.Int64("allocation.quantity", a.Quantity),
+		))
+	}
+	logger.Ctx(ctx).Printf("Stock reservation successful for item %s, order %s, %d warehouse(s)", itemId, orderID, len(reservation.Allocations))
 	span.AddEvent("Stock reserved")
 	// ------------------ END: 核心业务逻辑 ------------------
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Stock reserved"))
+	writeJSON(w, http.StatusOK, reservation)
 }
 
 // releaseStockHandler 模拟释放库存
@@ -139,8 +232,17 @@ func releaseStockHandler(w http.ResponseWriter, r *http.Request) {
 		attribute.Bool("compensation.logic", true),
 	)
 
-	logger.Ctx(ctx).Printf("Stock release successful for item %s, order %s", itemId, orderID)
-	span.AddEvent("Stock released")
+	released, err := inventorySvc.Release(ctx, orderID, itemId)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemId).Str("order", orderID).Msg("Failed to release stock")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, "Failed to release stock", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Ctx(ctx).Printf("Stock release successful for item %s, order %s, %d hold(s) released", itemId, orderID, len(released))
+	span.AddEvent("Stock released", trace.WithAttributes(attribute.Int("released.holds", len(released))))
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Stock released"))
 }
@@ -149,14 +251,386 @@ func releaseStockHandler(w http.ResponseWriter, r *http.Request) {
 func checkStockHandler(w http.ResponseWriter, r *http.Request) {
 	propagator := otel.GetTextMapPropagator()
 	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-	_, span := tracer.Start(ctx, "inventory-service.CheckStock")
+	ctx, span := tracer.Start(ctx, "inventory-service.CheckStock")
 	defer span.End()
 
 	itemId := r.URL.Query().Get("itemId")
+	levels, err := inventorySvc.StockLevels(ctx, itemId)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, "Failed to check stock", http.StatusInternalServerError)
+		return
+	}
+
+	var total int64
+	for _, l := range levels {
+		total += l.Available
+	}
+	span.SetAttributes(attribute.String("item.id", itemId), attribute.Int64("stock.available", total))
 	logger.Ctx(ctx).Printf("Stock check successful for item %s", itemId)
 	span.AddEvent("Stock check successful")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"itemId":     itemId,
+		"available":  total,
+		"warehouses": levels,
+	})
+}
+
+// thresholdHandler 查询 (GET) 或设置 (PUT/POST) 商品的补货点
+func thresholdHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.Threshold")
+	defer span.End()
+
+	itemId := r.URL.Query().Get("itemId")
+	if itemId == "" {
+		http.Error(w, "itemId is required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("item.id", itemId), attribute.String("http.method", r.Method))
+
+	switch r.Method {
+	case http.MethodGet:
+		threshold, err := inventorySvc.GetThreshold(ctx, itemId)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(w, "Failed to load threshold", http.StatusInternalServerError)
+			return
+		}
+		if threshold == nil {
+			http.Error(w, "threshold not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, threshold)
+	case http.MethodPut, http.MethodPost:
+		reorderPoint, err := strconv.ParseInt(r.URL.Query().Get("reorderPoint"), 10, 64)
+		if err != nil || reorderPoint < 0 {
+			http.Error(w, "reorderPoint must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		if err := inventorySvc.SetThreshold(ctx, itemId, reorderPoint); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(w, "Failed to save threshold", http.StatusInternalServerError)
+			return
+		}
+		span.AddEvent("Threshold saved", trace.WithAttributes(attribute.Int64("stock.reorder_point", reorderPoint)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Threshold saved"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// restockHandler 给某个仓库补货，补货后会通知到货提醒的订阅者
+func restockHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.Restock")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId := r.URL.Query().Get("itemId")
+	warehouseID := r.URL.Query().Get("warehouseId")
+	reference := r.URL.Query().Get("reference") // 可选：采购入库单号等，写入库存流水
+	quantity, err := strconv.ParseInt(r.URL.Query().Get("quantity"), 10, 64)
+	if itemId == "" || warehouseID == "" || err != nil || quantity <= 0 {
+		http.Error(w, "itemId, warehouseId and a positive quantity are required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("item.id", itemId),
+		attribute.String("warehouse.id", warehouseID),
+		attribute.Int64("item.quantity", quantity),
+		attribute.String("restock.reference", reference),
+	)
+
+	if err := inventorySvc.Restock(ctx, warehouseID, itemId, quantity, reference); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, inventory.ErrUnknownWarehouse) || errors.Is(err, inventory.ErrInvalidQuantity) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemId).Msg("Failed to restock")
+		http.Error(w, "Failed to restock", http.StatusInternalServerError)
+		return
+	}
+	logger.Ctx(ctx).Printf("Restocked %d unit(s) of item %s in warehouse %s", quantity, itemId, warehouseID)
+	span.AddEvent("Stock restocked")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Stock available"))
+	w.Write([]byte("Stock restocked"))
+}
+
+// waitlistHandler 查询 (GET)、订阅 (POST) 或取消 (DELETE) 到货提醒
+func waitlistHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.Waitlist")
+	defer span.End()
+
+	itemId := r.URL.Query().Get("itemId")
+	userID := r.URL.Query().Get("userId")
+	if itemId == "" {
+		http.Error(w, "itemId is required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("item.id", itemId),
+		attribute.String("user.id", userID),
+		attribute.String("http.method", r.Method),
+	)
+
+	fail := func(err error, msg string) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := inventorySvc.Waitlist(ctx, itemId)
+		if err != nil {
+			fail(err, "Failed to load waitlist")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"itemId": itemId, "subscriptions": subs})
+	case http.MethodPost:
+		if userID == "" {
+			http.Error(w, "userId is required", http.StatusBadRequest)
+			return
+		}
+		quantity, _ := strconv.ParseInt(r.URL.Query().Get("quantity"), 10, 64)
+		sub, err := inventorySvc.Subscribe(ctx, itemId, userID, quantity)
+		if err != nil {
+			fail(err, "Failed to subscribe")
+			return
+		}
+		span.AddEvent("Waitlist subscribed")
+		writeJSON(w, http.StatusOK, sub)
+	case http.MethodDelete:
+		if userID == "" {
+			http.Error(w, "userId is required", http.StatusBadRequest)
+			return
+		}
+		ok, err := inventorySvc.Unsubscribe(ctx, itemId, userID)
+		if err != nil {
+			fail(err, "Failed to unsubscribe")
+			return
+		}
+		if !ok {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return
+		}
+		span.AddEvent("Waitlist unsubscribed")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Unsubscribed"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// importStockHandler 批量导入库存调整，支持 CSV (Content-Type: text/csv) 和 JSON 数组
+// 查询参数: mode=absolute|delta, atomic=true, reason=<默认原因编码>, reference=<批次号>
+func importStockHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.ImportStock")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	opts := inventory.ImportOptions{
+		Mode:      q.Get("mode"),
+		Atomic:    q.Get("atomic") == "true",
+		Reason:    q.Get("reason"),
+		Reference: q.Get("reference"),
+	}
+
+	var (
+		rows      []inventory.Adjustment
+		parseErrs []inventory.RowError
+		err       error
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") || q.Get("format") == "csv" {
+		rows, parseErrs, err = inventory.ParseAdjustmentsCSV(r.Body)
+	} else {
+		rows, err = inventory.ParseAdjustmentsJSON(r.Body)
+	}
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := inventorySvc.Import(ctx, rows, parseErrs, opts)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to import stock adjustments")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, "Failed to import stock adjustments", http.StatusInternalServerError)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("import.mode", report.Mode),
+		attribute.Bool("import.atomic", report.Atomic),
+		attribute.String("import.reference", report.Reference),
+		attribute.Int("import.total", report.Total),
+		attribute.Int("import.applied", report.Applied),
+		attribute.Int("import.errors", len(report.Errors)),
+	)
+	logger.Ctx(ctx).Printf("Stock import %s applied %d/%d row(s)", report.Reference, report.Applied, report.Total)
+
+	status := http.StatusOK
+	if report.Atomic && len(report.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, report)
+}
+
+// exportStockHandler 流式导出当前库存，format=csv (默认) 或 json，可选 warehouseId 过滤
+func exportStockHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.ExportStock")
+	defer span.End()
+
+	format := r.URL.Query().Get("format")
+	warehouseID := r.URL.Query().Get("warehouseId")
+	span.SetAttributes(attribute.String("export.format", format), attribute.String("warehouse.id", warehouseID))
+
+	count := 0
+	var err error
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		w.Write([]byte("["))
+		err = inventorySvc.EachStockRow(ctx, warehouseID, func(row inventory.StockRow) error {
+			if count > 0 {
+				w.Write([]byte(","))
+			}
+			count++
+			return enc.Encode(row)
+		})
+		// 中途出错时不写结尾的 "]", 让客户端无法把被截断的导出当成完整的 JSON
+		if err == nil {
+			w.Write([]byte("]"))
+		}
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="stock.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"warehouseId", "itemId", "available", "reserved"})
+		err = inventorySvc.EachStockRow(ctx, warehouseID, func(row inventory.StockRow) error {
+			count++
+			return cw.Write([]string{row.WarehouseID, row.ItemID,
+				strconv.FormatInt(row.Available, 10), strconv.FormatInt(row.Reserved, 10)})
+		})
+		cw.Flush()
+	}
+	// 响应头已经发出，出错时只能记录下来，客户端会拿到一个被截断的文件
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("Stock export interrupted")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int("export.rows", count))
+}
+
+// reconcileHandler 手动触发一次对账 (POST)，或查看最近一次对账报告 (GET)
+// POST /reconcile?dryRun=true 只报告差异，不释放预占
+func reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	propagator := otel.GetTextMapPropagator()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "inventory-service.Reconcile")
+	defer span.End()
+
+	switch r.Method {
+	case http.MethodGet:
+		report := reconciler.LastReport()
+		if report == nil {
+			http.Error(w, "no reconciliation has run yet", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	case http.MethodPost:
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+		span.SetAttributes(attribute.Bool("reconcile.dry_run", dryRun))
+		report, err := runReconcile(ctx, dryRun)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(w, "Reconciliation failed", http.StatusInternalServerError)
+			return
+		}
+		span.SetAttributes(
+			attribute.Int("reconcile.scanned", report.Scanned),
+			attribute.Int("reconcile.discrepancies", len(report.Discrepancies)),
+			attribute.Int("reconcile.released", report.Released),
+		)
+		writeJSON(w, http.StatusOK, report)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runReconcile 在分布式锁保护下执行对账，避免多个实例同时释放同一批预占
+func runReconcile(ctx context.Context, dryRun bool) (*inventory.ReconcileReport, error) {
+	lock := zookeeper.NewDistributedLock(zkConn, "inventory-reconcile")
+	if err := lock.Lock(); err != nil {
+		return nil, fmt.Errorf("acquire reconcile lock: %w", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logger.Ctx(ctx).Printf("CRITICAL: Failed to release reconcile lock: %v", err)
+		}
+	}()
+	return reconciler.Run(ctx, dryRun)
+}
+
+// runScheduledReconcile 按固定周期执行对账
+func runScheduledReconcile(ctx context.Context, interval time.Duration, dryRun bool) {
+	logger.Logger.Printf("✅ Scheduled reconciliation started, running every %v (dryRun=%v)", interval, dryRun)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			spanCtx, span := tracer.Start(ctx, "inventory-service.ScheduledReconcile")
+			if _, err := runReconcile(spanCtx, dryRun); err != nil {
+				logger.Ctx(spanCtx).Error().Err(err).Msg("Scheduled reconciliation failed")
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseDestination 从查询参数中解析收货地址信息, 供就近分配使用
+func parseDestination(r *http.Request) inventory.Destination {
+	q := r.URL.Query()
+	lat, _ := strconv.ParseFloat(q.Get("lat"), 64)
+	lng, _ := strconv.ParseFloat(q.Get("lng"), 64)
+	return inventory.Destination{Region: q.Get("region"), Latitude: lat, Longitude: lng}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 /**
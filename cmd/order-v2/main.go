@@ -2,35 +2,126 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/wangyingjie930/nexus-pkg/bootstrap"
+	"github.com/wangyingjie930/nexus-pkg/logger"
 	"net/http"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
 )
 
 const (
 	serviceName = "order-service-v2"
 )
 
+// orderStatuses 是可以记录的订单状态, 与 inventory 对账识别的终态和完成态保持一致
+var orderStatuses = map[string]bool{
+	"pending": true, "paid": true, "shipped": true, "delivered": true, "completed": true,
+	"cancelled": true, "failed": true, "compensated": true,
+}
+
+// db 保存订单状态 (order_state 表)
+var db *sql.DB
+
 // main 函数是应用的"组装根" (Composition Root)
 // 它的核心职责是：创建并组装所有依赖项，然后启动应用。
 func main() {
 	bootstrap.Init()
 
+	var err error
+	db, err = sql.Open("mysql", bootstrap.GetCurrentConfig().Infra.Mysql.Addrs)
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("failed to open mysql")
+	}
+	defer db.Close()
+
 	bootstrap.StartService(bootstrap.AppInfo{
 		ServiceName: serviceName,
 		Port:        8081,
 		RegisterHandlers: func(appCtx bootstrap.AppCtx) {
 			appCtx.Mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 			appCtx.Mux.Handle("/metrics", promhttp.Handler())
-			appCtx.Mux.HandleFunc("/create_complex_order", func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusAccepted) // 202 Accepted 是一个非常适合此场景的状态码
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(map[string]string{
-					"status":  "pending",
-					"message": "this is v2",
-				})
-			})
+			appCtx.Mux.HandleFunc("/create_complex_order", handleCreateOrder)
+			appCtx.Mux.HandleFunc("GET /order_status", handleGetOrderStatus)  // 新增：查询订单状态
+			appCtx.Mux.HandleFunc("POST /order_status", handleSetOrderStatus) // 新增：记录订单状态变化
 		},
 	})
 }
+
+// handleCreateOrder 受理订单并记录为 pending, 后续流程推进时通过 POST /order_status 更新状态
+func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := uuid.NewString()
+	userID := r.URL.Query().Get("userID")
+	if userID == "" {
+		userID = r.URL.Query().Get("userId")
+	}
+	if _, err := db.ExecContext(r.Context(),
+		`INSERT INTO order_state (order_id, user_id, status) VALUES (?, ?, ?)`, orderID, userID, "pending"); err != nil {
+		logger.Ctx(r.Context()).Error().Err(err).Msg("Failed to save order state")
+		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{ // 202 Accepted 是一个非常适合此场景的状态码
+		"orderId": orderID,
+		"status":  "pending",
+		"message": "this is v2",
+	})
+}
+
+// handleGetOrderStatus 返回订单的当前状态。订单不存在时返回 JSON 格式的 404,
+// 调用方 (库存对账) 据此区分 "订单不存在" 和 "路由未注册"。
+func handleGetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderID := r.URL.Query().Get("orderID")
+	if orderID == "" {
+		http.Error(w, "orderID is required", http.StatusBadRequest)
+		return
+	}
+	var status string
+	err := db.QueryRowContext(r.Context(), `SELECT status FROM order_state WHERE order_id = ?`, orderID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"orderId": orderID, "error": "order not found"})
+		return
+	}
+	if err != nil {
+		logger.Ctx(r.Context()).Error().Err(err).Str("order", orderID).Msg("Failed to query order state")
+		http.Error(w, "Failed to query order status", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"orderId": orderID, "status": status})
+}
+
+// handleSetOrderStatus 记录订单的状态变化, 由订单流程的各个步骤 (支付、取消、补偿等) 调用
+func handleSetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderID := r.URL.Query().Get("orderID")
+	status := strings.ToLower(r.URL.Query().Get("status"))
+	if orderID == "" || !orderStatuses[status] {
+		http.Error(w, "orderID and a valid status are required", http.StatusBadRequest)
+		return
+	}
+	res, err := db.ExecContext(r.Context(), `UPDATE order_state SET status = ? WHERE order_id = ?`, status, orderID)
+	if err != nil {
+		logger.Ctx(r.Context()).Error().Err(err).Str("order", orderID).Msg("Failed to update order state")
+		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// 状态没有变化时 RowsAffected 也是 0, 需要确认订单是否存在
+		var exists int
+		if err := db.QueryRowContext(r.Context(), `SELECT 1 FROM order_state WHERE order_id = ?`, orderID).Scan(&exists); errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"orderId": orderID, "error": "order not found"})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"orderId": orderID, "status": status})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -2,13 +2,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/wangyingjie930/nexus-pkg/bootstrap"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"github.com/wangyingjie930/nexus-pkg/mq"
+	"github.com/wangyingjie930/nexus-pkg/redis"
 	"go.opentelemetry.io/otel/trace"
+	"io"
 	"net/http"
+	"nexus/internal/chaos"
+	"nexus/internal/config"
+	"nexus/internal/money"
+	"nexus/internal/pricing"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -27,18 +46,133 @@ func getEnv(key, fallback string) string {
 const serviceName = "pricing-service"
 
 var (
-	tracer trace.Tracer
+	tracer      trace.Tracer
+	catalog     *pricing.Catalog
+	rules       *pricing.RuleSet
+	engine      *pricing.Engine
+	quotes      *pricing.QuoteSigner
+	snapshots   *pricing.SnapshotStore
+	memberships *pricing.MembershipStore
+	dynamic     *pricing.DynamicPricer
+	lines       *pricing.LineCache
 )
 
 func main() {
 	bootstrap.Init()
 
+	// 报价令牌用 HMAC 签名，所有实例必须使用同一个密钥；密钥只从环境变量 (k8s 中由 Secret 注入) 读取，
+	// 未设置时直接退出，不在其它组件启动之后才失败
+	quoteSecret := getEnv("PRICING_QUOTE_SECRET", "")
+	if quoteSecret == "" {
+		logger.Logger.Fatal().Msg("PRICING_QUOTE_SECRET is required to sign price quotes")
+	}
+	quoteTTL, err := time.ParseDuration(getEnv("PRICING_QUOTE_TTL", "15m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid PRICING_QUOTE_TTL")
+	}
+	quotes = pricing.NewQuoteSigner([]byte(quoteSecret), quoteTTL)
+
+	// 故障注入规则来自 Nacos，修改后热加载
+	injector := chaos.NewInjector(serviceName)
+	if err := config.Watch(chaos.DataID, injector.Update); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch chaos rules, fault injection disabled")
+	}
+
+	// 价格目录存放在 MySQL 中，并在内存中缓存
+	db, err := sql.Open("mysql", bootstrap.GetCurrentConfig().Infra.Mysql.Addrs)
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("failed to open mysql")
+	}
+	defer db.Close()
+	catalogTTL, err := time.ParseDuration(getEnv("PRICING_CATALOG_TTL", "5m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid PRICING_CATALOG_TTL")
+	}
+	baseCurrency, err := money.LookupCurrency(getEnv("PRICING_BASE_CURRENCY", "CNY"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid PRICING_BASE_CURRENCY")
+	}
+	catalog = pricing.NewCatalog(db, catalogTTL, baseCurrency)
+	// 签发报价时保存完整的计价结果, 订单和退款按下单时绑定的快照复原价格
+	snapshots = pricing.NewSnapshotStore(db)
+	memberships = pricing.NewMembershipStore(db)
+
+	// 汇率表同样存放在 MySQL 中，按版本发布
+	rateTTL, err := time.ParseDuration(getEnv("EXCHANGE_RATE_TTL", "1m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid EXCHANGE_RATE_TTL")
+	}
+	rates := money.NewRateStore(db, rateTTL)
+
+	// 批量计价的行缓存存放在 Redis 中，所有实例共享; Redis 不可用时批量计价退化为逐行计算
+	lineTTL, err := time.ParseDuration(getEnv("PRICING_LINE_CACHE_TTL", "10m"))
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("invalid PRICING_LINE_CACHE_TTL")
+	}
+	if rdb, err := redis.NewClient(bootstrap.GetCurrentConfig().Infra.Redis.Addrs); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to connect to redis, price line cache disabled")
+	} else {
+		lines = pricing.NewLineCache(rdb.GetClient(), lineTTL)
+	}
+
+	// 折扣规则来自 Nacos，修改后热加载
+	rules = pricing.NewRuleSet(baseCurrency)
+	if err := config.Watch(pricing.RulesDataID, bumpLinesOnChange("discount rules changed", rules.Update)); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch discount rules, no discount will be applied")
+	}
+	// 各地区税率同样来自 Nacos
+	taxes := pricing.NewTaxRules()
+	if err := config.Watch(pricing.TaxDataID, taxes.Update); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch tax rules, prices will not include tax")
+	}
+	// 动态定价: 消费 inventory-service 发布的库存水位，按 Nacos 中的价格曲线调价
+	dynamic = pricing.NewDynamicPricer(db, baseCurrency)
+	if err := dynamic.Load(context.Background()); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to restore dynamic price multipliers")
+	}
+	if err := config.Watch(pricing.DynamicDataID, bumpLinesOnChange("dynamic pricing config changed", dynamic.Update)); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch dynamic pricing config, dynamic pricing disabled")
+	}
+	stockReaders, err := newStockLevelReaders(strings.Split(bootstrap.GetCurrentConfig().Infra.Kafka.Brokers, ","))
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to subscribe stock levels, dynamic multipliers stay at their restored values")
+	}
+	for _, reader := range stockReaders {
+		defer reader.Close()
+		go consumeStockLevels(reader)
+	}
+
+	// 价格实验: 按 user_id 稳定分桶
+	experiments := pricing.NewExperiments(baseCurrency)
+	if err := config.Watch(pricing.ExperimentsDataID, experiments.Update); err != nil {
+		logger.Logger.Error().Err(err).Msg("failed to watch pricing experiments, experiments disabled")
+	}
+
+	engine = pricing.NewEngine(catalog, pricing.EngineOptions{
+		Rules: rules, Rates: rates, Taxes: taxes, Dynamic: dynamic, Experiments: experiments, Lines: lines,
+	})
+
 	bootstrap.StartService(bootstrap.AppInfo{
 		ServiceName: serviceName,
 		Port:        8084,
 		RegisterHandlers: func(ctx bootstrap.AppCtx) {
 			tracer = otel.Tracer(serviceName)
-			ctx.Mux.HandleFunc("/calculate_price", handleCalculatePrice)
+			chaos.RegisterSpanProcessor()
+			// 业务路由注册在 api 上，整体由故障注入中间件包住，规则对所有业务路由生效
+			api := http.NewServeMux()
+			api.HandleFunc("/calculate_price", handleCalculatePrice)
+			api.HandleFunc("/calculate_cart", handleCalculateCart)
+			api.HandleFunc("/explain_price", handleExplainPrice)
+			api.HandleFunc("/verify_quote", handleVerifyQuote)
+			api.HandleFunc("/catalog/invalidate", handleInvalidateCatalog)
+			api.HandleFunc("/dynamic_pricing", handleDynamicState)
+			api.HandleFunc("/dynamic_pricing/audit", handleDynamicAudit)
+			api.HandleFunc("/prices/schedule", handleSchedulePrice)
+			api.HandleFunc("/prices/history", handlePriceHistory)
+			api.HandleFunc("/prices/as_of", handlePricesAsOf)
+			api.HandleFunc("/prices/snapshot", handlePriceSnapshot)
+			ctx.Mux.Handle("/", injector.Wrap(api))
+			ctx.Mux.Handle("/metrics", promhttp.Handler())
 		},
 	})
 }
@@ -48,25 +182,630 @@ func handleCalculatePrice(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tracer.Start(ctx, "pricing-service.CalculatePrice")
 	defer span.End()
 
-	isVIP := r.URL.Query().Get("is_vip")
-	userID := r.URL.Query().Get("user_id")
-	span.SetAttributes(attribute.Bool("user.is_vip", isVIP == "true"))
+	req, err := parsePriceRequest(r)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.Bool("user.is_vip", req.IsVIP),
+		attribute.String("user.id", req.UserID),
+		attribute.Int("cart.lines", len(req.Items)),
+		attribute.String("price.requested_currency", req.Currency),
+		attribute.String("price.region", req.Region),
+	)
+	if req.AsOf != nil {
+		span.SetAttributes(attribute.String("price.as_of", req.AsOf.Format(time.RFC3339)))
+	}
+
+	// 故障注入已经移到 chaos 中间件，由 Nacos 中的 nexus-chaos.yaml 配置
+	result, err := engine.Calculate(ctx, req)
+	if err != nil {
+		writeCalculateError(ctx, w, span, err)
+		return
+	}
+
+	// 按历史时刻复算的结果只是估算, 不签发可以下单的报价令牌; 订单和退款以 /prices/snapshot 中的快照为准
+	if req.AsOf == nil {
+		if err := issueQuote(ctx, req.UserID, result); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.Ctx(ctx).Error().Err(err).Msg("Failed to issue price quote")
+			http.Error(w, "Failed to issue price quote", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	appliedIDs := make([]string, 0, len(result.AppliedRules))
+	for _, applied := range result.AppliedRules {
+		appliedIDs = append(appliedIDs, applied.RuleID)
+	}
+	span.SetAttributes(
+		attribute.String("price.currency", result.Currency),
+		attribute.String("price.subtotal", result.Subtotal.Decimal()),
+		attribute.String("price.discount", result.Discount.Decimal()),
+		attribute.String("price.tax", result.Tax.Decimal()),
+		attribute.String("price.tax_mode", result.TaxMode),
+		attribute.String("price.total", result.Total.Decimal()),
+		attribute.StringSlice("price.applied_rules", appliedIDs),
+		attribute.String("quote.id", result.QuoteID),
+	)
+	setExperimentAttributes(span, result.Experiments)
+	span.AddEvent("Price calculated")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleCalculateCart 一次调用给整个购物车计价, 只接受 JSON 请求体 (与 /calculate_price 的 JSON 格式相同)。
+// 各行折扣前的单价优先从 Redis 行缓存读取, span 上记录哪些行命中了缓存。
+func handleCalculateCart(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.CalculateCart")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pricing.PriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("user.id", req.UserID),
+		attribute.Int("cart.lines", len(req.Items)),
+		attribute.String("price.requested_currency", req.Currency),
+		attribute.String("price.region", req.Region),
+	)
+
+	result, err := engine.CalculateCart(ctx, req)
+	if err != nil {
+		writeCalculateError(ctx, w, span, err)
+		return
+	}
+	if req.AsOf == nil {
+		if err := issueQuote(ctx, req.UserID, result); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.Ctx(ctx).Error().Err(err).Msg("Failed to issue price quote")
+			http.Error(w, "Failed to issue price quote", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var cachedLines, computedLines []string
+	for _, line := range result.LineItems {
+		if line.Cached {
+			cachedLines = append(cachedLines, line.ItemID)
+		} else {
+			computedLines = append(computedLines, line.ItemID)
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("price.cache_hits", len(cachedLines)),
+		attribute.Int("price.cache_misses", len(computedLines)),
+		attribute.StringSlice("price.cached_lines", cachedLines),
+		attribute.StringSlice("price.computed_lines", computedLines),
+		attribute.String("price.currency", result.Currency),
+		attribute.String("price.total", result.Total.Decimal()),
+		attribute.String("quote.id", result.QuoteID),
+	)
+	setExperimentAttributes(span, result.Experiments)
+	span.AddEvent("Cart priced")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// bumpLinesOnChange 包装 Nacos 配置的 Update: 每次都应用配置, 只在内容与上一次不同时让行缓存失效。
+// 启动时的初始加载只记录内容, 否则每个实例重启都会让整个集群的行缓存失效。
+// 内容按应用前的 JSON 比较, Update 在编译规则时写入的内部字段不影响比较。
+func bumpLinesOnChange[T any](reason string, update func(T)) func(T) {
+	var (
+		mu   sync.Mutex
+		prev []byte
+	)
+	return func(cfg T) {
+		content, err := json.Marshal(cfg)
+		update(cfg)
+		mu.Lock()
+		changed := prev != nil && (err != nil || !bytes.Equal(prev, content))
+		prev = content
+		mu.Unlock()
+		if changed {
+			lines.Bump(context.Background(), reason)
+		}
+	}
+}
+
+// issueQuote 为计价结果签发报价令牌, 并保存计价快照; 快照保存失败时不返回报价, 保证每份报价都能被复原。
+// 令牌中的用户分群从 user_segment 表查出, 不采用请求中的 isVip / segments。
+func issueQuote(ctx context.Context, userID string, result *pricing.PriceResult) error {
+	segments, err := memberships.Segments(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := quotes.Issue(userID, segments, result); err != nil {
+		return err
+	}
+	return snapshots.Save(ctx, userID, result)
+}
+
+// setExperimentAttributes 把用户分到的实验变体记到 span 上, 便于与订单数据关联分析:
+// price.experiments 汇总为 "实验:变体" 列表, 每个实验另有一个 experiment.<id> 属性
+func setExperimentAttributes(span trace.Span, assignments []pricing.ExperimentAssignment) {
+	if len(assignments) == 0 {
+		return
+	}
+	pairs := make([]string, 0, len(assignments))
+	for _, a := range assignments {
+		pairs = append(pairs, a.ExperimentID+":"+a.Variant)
+		span.SetAttributes(attribute.String("experiment."+a.ExperimentID, a.Variant))
+	}
+	span.SetAttributes(attribute.StringSlice("price.experiments", pairs))
+}
+
+// explainResponse 是 /explain_price 的响应: 计价结果和完整的计算过程树
+type explainResponse struct {
+	Result      *pricing.PriceResult `json:"result,omitempty"`
+	Explanation *pricing.ExplainNode `json:"explanation"`
+}
+
+// handleExplainPrice 与 /calculate_price 接受相同的参数, 返回计价结果和计算过程,
+// 供排查 "为什么收了这么多钱" 使用。计算过程同时逐个节点记为 span 事件, 在 Jaeger 中也能看到。
+// 这里不签发报价令牌, 解释结果不能用于下单。
+func handleExplainPrice(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.ExplainPrice")
+	defer span.End()
+
+	req, err := parsePriceRequest(r)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("user.id", req.UserID), attribute.Int("cart.lines", len(req.Items)))
+
+	result, explanation, err := engine.Explain(ctx, req)
+	explanation.Walk(func(depth int, node *pricing.ExplainNode) {
+		attrs := []attribute.KeyValue{
+			attribute.Int("explain.depth", depth),
+			attribute.String("explain.subject", node.Subject),
+			attribute.String("explain.outcome", node.Outcome),
+			attribute.String("explain.detail", node.Detail),
+		}
+		if node.Amount != nil {
+			attrs = append(attrs, attribute.String("explain.amount", node.Amount.String()))
+		}
+		span.AddEvent("explain."+node.Step, trace.WithAttributes(attrs...))
+	})
+	if err != nil {
+		writeCalculateError(ctx, w, span, err)
+		return
+	}
+	setExperimentAttributes(span, result.Experiments)
+	writeJSON(w, http.StatusOK, explainResponse{Result: result, Explanation: explanation})
+}
+
+// writeCalculateError 把计价错误映射为 HTTP 状态码: 请求本身的问题返回 400, 其他返回 500
+func writeCalculateError(ctx context.Context, w http.ResponseWriter, span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	switch {
+	case errors.Is(err, pricing.ErrUnknownSKU), errors.Is(err, pricing.ErrEmptyCart), errors.Is(err, pricing.ErrInvalidQuantity),
+		errors.Is(err, money.ErrUnknownCurrency), errors.Is(err, money.ErrNoRate),
+		errors.Is(err, pricing.ErrUnknownTaxRegion):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to calculate price")
+		http.Error(w, "Failed to calculate price", http.StatusInternalServerError)
+	}
+}
+
+// verifyQuoteRequest 是 /verify_quote 的请求体, userId 和 items 可选, 填写时会与报价核对;
+// orderId 填写时把报价的计价快照绑定到该订单, 之后可以用 /prices/snapshot?order_id= 查询
+type verifyQuoteRequest struct {
+	Token   string             `json:"token"`
+	UserID  string             `json:"userId"`
+	OrderID string             `json:"orderId"`
+	Items   []pricing.CartLine `json:"items"`
+}
+
+type verifyQuoteResponse struct {
+	Valid  bool                 `json:"valid"`
+	Reason string               `json:"reason,omitempty"`
+	Quote  *pricing.QuoteClaims `json:"quote,omitempty"`
+}
+
+// handleVerifyQuote 供订单服务在下单时校验报价令牌:
+// 有效时返回报价内容 (200), 过期返回 410, 与订单不符或已绑定其它订单返回 409,
+// 令牌被篡改、格式错误或没有计价快照返回 422
+func handleVerifyQuote(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.VerifyQuote")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req verifyQuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "request body must contain a token", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := quotes.Verify(req.Token, time.Now())
+	if err == nil {
+		err = claims.Matches(req.UserID, req.Items)
+	}
+	if claims != nil {
+		span.SetAttributes(attribute.String("quote.id", claims.QuoteID))
+	}
+	if err == nil && req.OrderID != "" {
+		span.SetAttributes(attribute.String("order.id", req.OrderID))
+		err = snapshots.Bind(ctx, claims.QuoteID, req.OrderID)
+		if err != nil && !errors.Is(err, pricing.ErrSnapshotBound) && !errors.Is(err, pricing.ErrSnapshotNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.Ctx(ctx).Error().Err(err).Msg("Failed to bind price snapshot")
+			http.Error(w, "Failed to bind price snapshot", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err != nil {
+		span.SetAttributes(attribute.Bool("quote.valid", false))
+		span.RecordError(err)
+		logger.Ctx(ctx).Printf("Quote rejected: %v", err)
+		status := http.StatusUnprocessableEntity
+		switch {
+		case errors.Is(err, pricing.ErrQuoteExpired):
+			status = http.StatusGone
+		case errors.Is(err, pricing.ErrQuoteMismatch), errors.Is(err, pricing.ErrSnapshotBound):
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, verifyQuoteResponse{Valid: false, Reason: err.Error(), Quote: claims})
+		return
+	}
+
+	span.SetAttributes(attribute.Bool("quote.valid", true))
+	span.AddEvent("Quote verified")
+	writeJSON(w, http.StatusOK, verifyQuoteResponse{Valid: true, Quote: claims})
+}
+
+// newStockLevelReaders 为库存水位主题的每个分区创建一个不加入消费组的 reader, 从最新位置开始读取:
+// 动态定价的状态保存在各实例内存中, 每个实例都需要收到全部商品的库存水位;
+// 重启前生效的倍数已由 dynamic.Load 从审计表恢复, 不需要重放历史事件
+func newStockLevelReaders(brokers []string) ([]*kafka.Reader, error) {
+	var (
+		partitions []kafka.Partition
+		err        error
+	)
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.Dial("tcp", broker); err != nil {
+			continue
+		}
+		partitions, err = conn.ReadPartitions(pricing.StockLevelTopic)
+		conn.Close()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read partitions of %s: %w", pricing.StockLevelTopic, err)
+	}
+
+	readers := make([]*kafka.Reader, 0, len(partitions))
+	for _, partition := range partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     pricing.StockLevelTopic,
+			Partition: partition.ID,
+			MinBytes:  10e3, // 10KB
+			MaxBytes:  10e6, // 10MB
+		})
+		if err := reader.SetOffset(kafka.LastOffset); err != nil {
+			reader.Close()
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, fmt.Errorf("seek %s partition %d: %w", pricing.StockLevelTopic, partition.ID, err)
+		}
+		readers = append(readers, reader)
+	}
+	return readers, nil
+}
+
+// consumeStockLevels 持续消费库存水位, 交给动态定价器重新计算倍数
+func consumeStockLevels(reader *kafka.Reader) {
+	consumerTracer := otel.Tracer(serviceName)
+	for {
+		msg, err := reader.ReadMessage(context.Background())
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			logger.Logger.Error().Err(err).Msg("could not read stock level message")
+			continue
+		}
+		ctx := mq.ExtractTraceContext(context.Background(), msg.Headers)
+		ctx, span := consumerTracer.Start(ctx, "pricing-service.ObserveStockLevel",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "kafka"),
+				attribute.String("messaging.destination", msg.Topic),
+				attribute.String("messaging.kafka.message.key", string(msg.Key)),
+			))
+
+		var event pricing.StockLevelEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			logger.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal stock level")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			continue
+		}
+		span.SetAttributes(
+			attribute.String("item.id", event.ItemID),
+			attribute.Int64("stock.available", event.Available),
+			attribute.Int64("stock.reserved", event.Reserved),
+		)
+		dynamic.Observe(ctx, event)
+		span.End()
+	}
+}
+
+// handleDynamicState 返回各商品当前的动态定价倍数和最近一次库存快照
+func handleDynamicState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, dynamic.States())
+}
+
+// handleDynamicAudit 按时间倒序返回调价记录, 可以用 itemId 过滤, limit 默认 50
+func handleDynamicAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.DynamicAudit")
+	defer span.End()
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	audits, err := dynamic.Audit(ctx, r.URL.Query().Get("itemId"), limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to query dynamic price audit")
+		http.Error(w, "Failed to query dynamic price audit", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, audits)
+}
+
+// schedulePriceRequest 是 /prices/schedule 的请求体, unitPrice 以价格目录的基准货币计
+type schedulePriceRequest struct {
+	SKU           string     `json:"sku"`
+	Kind          string     `json:"kind"`
+	ListCode      string     `json:"listCode"`
+	MinQuantity   int64      `json:"minQuantity"`
+	UnitPrice     string     `json:"unitPrice"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo"`
+	Note          string     `json:"note"`
+}
 
-	// <<<<<<< 复杂故障注入点 >>>>>>>>>
-	if userID == "user-normal-456" {
-		// 模拟复杂计算导致的超时
-		time.Sleep(600 * time.Millisecond)
-		err := fmt.Errorf("random error")
+// handleSchedulePrice 新增一条排期价格。价格只增不改, 调整排期时再写一条覆盖它的记录即可。
+func handleSchedulePrice(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.SchedulePrice")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req schedulePriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	unitPrice, err := money.Parse(req.UnitPrice, catalog.Currency())
+	if err != nil {
+		http.Error(w, "invalid unitPrice: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("item.id", req.SKU),
+		attribute.String("price.entry_kind", req.Kind),
+		attribute.String("price.unit_price", unitPrice.Decimal()),
+	)
+
+	entry, err := catalog.Schedule(ctx, pricing.PriceEntry{
+		SKU: req.SKU, Kind: req.Kind, ListCode: req.ListCode, MinQuantity: req.MinQuantity,
+		UnitPrice: unitPrice, EffectiveFrom: req.EffectiveFrom, EffectiveTo: req.EffectiveTo, Note: req.Note,
+	})
+	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, pricing.ErrInvalidPriceEntry) || errors.Is(err, pricing.ErrUnknownSKU) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to schedule price")
+		http.Error(w, "Failed to schedule price", http.StatusInternalServerError)
 		return
 	}
-	// <<<<<<< 故障注入结束 >>>>>>>>>
+	span.SetAttributes(attribute.Int64("price.entry_id", entry.ID))
+	lines.Bump(ctx, "price scheduled for "+entry.SKU)
+	logger.Ctx(ctx).Printf("Price scheduled: sku=%s kind=%s price=%s from=%s", entry.SKU, entry.Kind, entry.UnitPrice, entry.EffectiveFrom.Format(time.RFC3339))
+	writeJSON(w, http.StatusCreated, entry)
+}
 
-	// 正常逻辑
-	time.Sleep(150 * time.Millisecond) // 模拟正常计算耗时
-	span.AddEvent("Standard price calculated")
+// handlePriceHistory 返回某个 SKU 的全部排期价格, 包括已经失效的
+func handlePriceHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.PriceHistory")
+	defer span.End()
+
+	sku := r.URL.Query().Get("sku")
+	if sku == "" {
+		http.Error(w, "sku is required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("item.id", sku))
+	entries, err := catalog.History(ctx, sku)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to query price history")
+		http.Error(w, "Failed to query price history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handlePricesAsOf 返回 SKU 在 at 时刻 (RFC3339) 生效的目录价格, 不含折扣和动态调价
+func handlePricesAsOf(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.PricesAsOf")
+	defer span.End()
+
+	q := r.URL.Query()
+	var skus []string
+	for _, sku := range strings.Split(q.Get("sku"), ",") {
+		if sku = strings.TrimSpace(sku); sku != "" {
+			skus = append(skus, sku)
+		}
+	}
+	at, err := time.Parse(time.RFC3339, q.Get("at"))
+	if len(skus) == 0 || err != nil {
+		http.Error(w, "sku and at (RFC3339) are required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.StringSlice("item.ids", skus), attribute.String("price.as_of", at.Format(time.RFC3339)))
+
+	entries, err := catalog.GetAsOf(ctx, skus, at)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, pricing.ErrUnknownSKU) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to query prices as of")
+		http.Error(w, "Failed to query prices", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handlePriceSnapshot 按 order_id 或 quote_id 返回签发报价时保存的计价快照, 订单和退款以它为准
+func handlePriceSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "pricing-service.PriceSnapshot")
+	defer span.End()
+
+	var (
+		snapshot *pricing.PriceSnapshot
+		err      error
+	)
+	q := r.URL.Query()
+	switch {
+	case q.Get("order_id") != "":
+		span.SetAttributes(attribute.String("order.id", q.Get("order_id")))
+		snapshot, err = snapshots.ByOrder(ctx, q.Get("order_id"))
+	case q.Get("quote_id") != "":
+		span.SetAttributes(attribute.String("quote.id", q.Get("quote_id")))
+		snapshot, err = snapshots.ByQuote(ctx, q.Get("quote_id"))
+	default:
+		http.Error(w, "order_id or quote_id is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, pricing.ErrSnapshotNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		span.SetStatus(codes.Error, err.Error())
+		logger.Ctx(ctx).Error().Err(err).Msg("Failed to query price snapshot")
+		http.Error(w, "Failed to query price snapshot", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleInvalidateCatalog 在价格修改后立即使缓存失效，sku 为空时清空全部缓存
+func handleInvalidateCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var skus []string
+	if raw := r.URL.Query().Get("sku"); raw != "" {
+		skus = strings.Split(raw, ",")
+	}
+	catalog.Invalidate(skus...)
+	lines.Bump(r.Context(), "catalog invalidated")
+	logger.Logger.Printf("Price catalog cache invalidated: %v", skus)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"price": 99.99}`))
+	w.Write([]byte("Catalog cache invalidated"))
+}
+
+// parsePriceRequest 支持两种请求格式:
+//   - POST JSON: {"userId": "...", "isVip": true, "segments": ["new_user"], "currency": "USD", "region": "US-CA", "items": [{"itemId": "...", "quantity": 2}]}
+//   - 查询参数: user_id=...&is_vip=true&segments=new_user&currency=USD&region=US-CA&items=item-a:2,item-b (数量省略时为 1)
+func parsePriceRequest(r *http.Request) (pricing.PriceRequest, error) {
+	var req pricing.PriceRequest
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid request body: %w", err)
+		}
+		return req, nil
+	}
+
+	q := r.URL.Query()
+	req.UserID = q.Get("user_id")
+	req.IsVIP = q.Get("is_vip") == "true"
+	req.Currency = q.Get("currency")
+	req.Region = q.Get("region")
+	if raw := q.Get("as_of"); raw != "" {
+		asOf, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return req, fmt.Errorf("as_of must be an RFC3339 timestamp: %w", err)
+		}
+		req.AsOf = &asOf
+	}
+	if segments := q.Get("segments"); segments != "" {
+		req.Segments = strings.Split(segments, ",")
+	}
+	for _, part := range strings.Split(q.Get("items"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		itemID, qtyStr, hasQty := strings.Cut(part, ":")
+		quantity := int64(1)
+		if hasQty {
+			n, err := strconv.ParseInt(qtyStr, 10, 64)
+			if err != nil {
+				return req, fmt.Errorf("invalid quantity in %q", part)
+			}
+			quantity = n
+		}
+		req.Items = append(req.Items, pricing.CartLine{ItemID: itemID, Quantity: quantity})
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
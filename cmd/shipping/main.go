@@ -1,13 +1,32 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/wangyingjie930/nexus-pkg/bootstrap"
+	"github.com/wangyingjie930/nexus-pkg/redis"
 	"github.com/wangyingjie930/nexus-pkg/tracing"
 	"go.opentelemetry.io/otel/propagation"
+	"io"
 	"net/http"
+	"nexus/internal/config"
+	"nexus/internal/money"
+	"nexus/internal/shipping"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	_ "github.com/go-sql-driver/mysql"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	// 使用 zerolog 替代标准 log
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
@@ -18,9 +37,29 @@ const (
 )
 
 var (
-	tracer = otel.Tracer(serviceName)
+	tracer        = otel.Tracer(serviceName)
+	baseCurrency  money.Currency
+	rates         *money.RateStore
+	rateTable     *shipping.RateTable
+	carriers      *shipping.Registry
+	calendar      *shipping.DeliveryCalendar
+	discounts     *shipping.DiscountRules
+	priceQuotes   *shipping.PriceQuoteVerifier
+	items         *shipping.ItemStore
+	shipments     *shipping.Shipments
+	webhooks      *shipping.WebhookVerifier
+	defaultOrigin = getEnv("SHIPPING_DEFAULT_ORIGIN", "CN-SH")
 )
 
+// getEnv 从环境变量中读取配置。
+// 如果环境变量不存在，则返回提供的默认值。
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
 func main() {
 	bootstrap.Init()
 
@@ -28,42 +67,935 @@ func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zlog.Logger = zlog.With().Str("service", serviceName).Logger()
 
+	// 运费以基准货币计，需要其他货币时按 MySQL 中的版本化汇率表换算
+	var err error
+	baseCurrency, err = money.LookupCurrency(getEnv("SHIPPING_BASE_CURRENCY", "CNY"))
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("invalid SHIPPING_BASE_CURRENCY")
+	}
+	db, err := sql.Open("mysql", bootstrap.GetCurrentConfig().Infra.Mysql.Addrs)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("failed to open mysql")
+	}
+	defer db.Close()
+	rateTTL, err := time.ParseDuration(getEnv("EXCHANGE_RATE_TTL", "1m"))
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("invalid EXCHANGE_RATE_TTL")
+	}
+	rates = money.NewRateStore(db, rateTTL)
+
+	// 商品重量和尺寸存放在 MySQL 中; 各承运商的费率表来自 Nacos，修改后热加载
+	items = shipping.NewItemStore(db)
+	rateTable = shipping.NewRateTable(baseCurrency)
+	if err := config.Watch(shipping.RatesDataID, rateTable.Update); err != nil {
+		zlog.Error().Err(err).Msg("failed to watch shipping rates, no shipping option will be available")
+	}
+	// 承运商注册表同样来自 Nacos; 未配置时费率表中的每个承运商都按费率表报价
+	carriers = shipping.NewRegistry(rateTable)
+	if err := config.Watch(shipping.CarriersDataID, carriers.Update); err != nil {
+		zlog.Error().Err(err).Msg("failed to watch carrier registry, quoting from rate tables only")
+	}
+
+	// 截单时间和各地区的工作日历同样来自 Nacos; 未配置时送达日期按自然日计算
+	calendar = shipping.NewDeliveryCalendar()
+	if err := config.Watch(shipping.CalendarDataID, calendar.Update); err != nil {
+		zlog.Error().Err(err).Msg("failed to watch delivery calendar, delivery dates will use calendar days")
+	}
+
+	// 包邮门槛和运费优惠规则来自 Nacos，修改后热加载
+	discounts = shipping.NewDiscountRules(baseCurrency)
+	if err := config.Watch(shipping.DiscountsDataID, discounts.Update); err != nil {
+		zlog.Error().Err(err).Msg("failed to watch shipping discount rules, no shipping discount will be applied")
+	}
+	// 匹配运费优惠用的商品金额和用户分群取自 pricing-service 签发的报价令牌, 由 pricing-service 校验
+	priceQuotes = shipping.NewPriceQuoteVerifier(getEnv("PRICING_SERVICE_BASE_URL", "http://localhost:8084"))
+
+	// 运单存放在 MySQL 中，每一次状态变化与运单在同一个事务中写入发件箱，再由转发协程发布到 shipping-events 主题
+	eventPublisher := shipping.NewKafkaEventPublisher(strings.Split(bootstrap.GetCurrentConfig().Infra.Kafka.Brokers, ","))
+	defer eventPublisher.Close()
+	shipments = shipping.NewShipments(db, items, carriers, baseCurrency, eventPublisher)
+	outboxInterval, err := time.ParseDuration(getEnv("SHIPPING_OUTBOX_RETRY_INTERVAL", "5s"))
+	if err != nil || outboxInterval <= 0 {
+		zlog.Fatal().Err(err).Msg("invalid SHIPPING_OUTBOX_RETRY_INTERVAL")
+	}
+	relayCtx, cancelRelay := context.WithCancel(context.Background())
+	defer cancelRelay()
+	go runOutboxRelay(relayCtx, outboxInterval)
+	// 承运商推送: 按承运商的密钥校验签名, 用过的 nonce 登记在 Redis 中，所有实例共享;
+	// Redis 不可用时无法防重放，推送一律返回 503，由承运商稍后重试
+	webhookTolerance, err := time.ParseDuration(getEnv("SHIPPING_WEBHOOK_TOLERANCE", "5m"))
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("invalid SHIPPING_WEBHOOK_TOLERANCE")
+	}
+	var nonces shipping.NonceStore
+	if rdb, err := redis.NewClient(bootstrap.GetCurrentConfig().Infra.Redis.Addrs); err != nil {
+		zlog.Error().Err(err).Msg("failed to connect to redis, carrier webhooks will be rejected")
+	} else {
+		nonces = shipping.NewRedisNonceStore(rdb.GetClient())
+	}
+	webhooks = shipping.NewWebhookVerifier(carriers, nonces, webhookTolerance)
+	// 轨迹轮询: 定期向承运商拉取未签收运单的轨迹
+	if interval, err := time.ParseDuration(getEnv("SHIPPING_TRACKING_POLL_INTERVAL", "0")); err == nil && interval > 0 {
+		pollCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runTrackingPoller(pollCtx, interval)
+	}
+
 	bootstrap.StartService(bootstrap.AppInfo{
 		ServiceName: serviceName,
 		Port:        8086,
 		RegisterHandlers: func(ctx bootstrap.AppCtx) {
-			// 使用一个中间件来注入 logger
-			ctx.Mux.Handle("/get_quote", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				// 先提取trace上下文
-				ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx.Mux.Handle("/get_quote", withLogger(handleGetQuote))
+			ctx.Mux.Handle("POST /shipping_plan", withLogger(handleShippingPlan))                 // 新增：多仓订单拆分包裹报价
+			ctx.Mux.Handle("POST /shipments", withLogger(handleCreateShipment))                   // 新增：创建运单
+			ctx.Mux.Handle("GET /shipments", withLogger(handleListShipments))                     // 新增：按订单查询运单
+			ctx.Mux.Handle("GET /shipments/{id}", withLogger(handleGetShipment))                  // 新增：运单详情和状态历史
+			ctx.Mux.Handle("POST /shipments/{id}/sync", withLogger(handleSyncShipment))           // 新增：立即同步承运商轨迹
+			ctx.Mux.Handle("POST /shipments/{id}/status", withLogger(handleTransitionShipment))   // 新增：人工变更状态
+			ctx.Mux.Handle("GET /shipments/{id}/label", withLogger(handleGetLabel))               // 新增：面单 (PDF / ZPL)
+			ctx.Mux.Handle("POST /webhooks/carriers/{carrier}", withLogger(handleCarrierWebhook)) // 新增：承运商轨迹推送
+			ctx.Mux.Handle("POST /addresses/validate", withLogger(handleValidateAddress))         // 新增：地址校验和规范化
+			ctx.Mux.Handle("/metrics", promhttp.Handler())
+		},
+	})
+}
 
-				// 然后从提取的上下文中获取trace_id
-				traceID := tracing.GetTraceIDFromContext(ctx)
+// withLogger 是一个注入 logger 的中间件: 提取 trace 上下文, 把带 trace_id 的 logger 存入 context
+func withLogger(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 先提取trace上下文
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
 
-				logger := zlog.With().Str("trace_id", traceID).Logger()
-				// 将新的 logger 存入 context，以便 handler 使用
-				ctx = logger.WithContext(ctx)
+		// 然后从提取的上下文中获取trace_id
+		traceID := tracing.GetTraceIDFromContext(ctx)
 
-				// 调用真正的 handler
-				handleGetQuote(w, r.WithContext(ctx))
-			}))
-		},
+		logger := zlog.With().Str("trace_id", traceID).Logger()
+		// 将新的 logger 存入 context，以便 handler 使用
+		ctx = logger.WithContext(ctx)
+
+		// 调用真正的 handler
+		next(w, r.WithContext(ctx))
 	})
 }
 
+// quoteRequest 是运费报价请求, GET 时 items 格式与 pricing-service 相同 (sku:数量)。
+// quoteToken 是 pricing-service 为同一批商品签发的报价令牌, 运费优惠按其中的应付总额和用户分群匹配;
+// 未填写时只有不限金额和分群的规则可能生效。
+type quoteRequest struct {
+	Items        []shipping.Item `json:"items"`
+	Origin       string          `json:"origin"`
+	Destination  string          `json:"destination"`
+	ServiceLevel string          `json:"serviceLevel"`
+	Currency     string          `json:"currency"`
+	QuoteToken   string          `json:"quoteToken"`
+
+	// priceQuote 是校验通过的商品报价, 由 resolvePriceQuote 填写
+	priceQuote *shipping.PriceQuote
+	// planned 表示这是发货方案中的一个包裹, 运费优惠由 plan 按整单应用
+	planned bool
+}
+
+// quoteResponse 是运费报价: 包裹信息和按优惠后运费从低到高排列的可选寄送方式。
+// cost 是最便宜方式运费的 JSON 数字形式 (例如 10.0), 保留给按数字解析旧字段的调用方 (订单服务);
+// costMoney 是同一个运费的金额对象, discount 是这个方式上生效的优惠规则。
+type quoteResponse struct {
+	Parcel          shipping.Parcel           `json:"parcel"`
+	OriginZone      string                    `json:"originZone"`
+	DestinationZone string                    `json:"destinationZone"`
+	Options         []shipping.Option         `json:"options"`
+	Cost            json.Number               `json:"cost"`
+	CostMoney       money.Money               `json:"costMoney"`
+	Discount        *shipping.AppliedDiscount `json:"discount,omitempty"`
+	Currency        string                    `json:"currency"`
+	Conversion      *money.Conversion         `json:"conversion,omitempty"`
+	// CarrierErrors 是询价失败的承运商, 不影响其他承运商的报价
+	CarrierErrors []shipping.CarrierError `json:"carrierErrors,omitempty"`
+}
+
 func handleGetQuote(w http.ResponseWriter, r *http.Request) {
 	// 从 context 中获取我们注入的 logger
 	logger := zlog.Ctx(r.Context())
 
 	// 这里不需要再次提取trace上下文，因为已经在中间件中处理了
 	ctx := r.Context()
-	_, span := tracer.Start(ctx, "shipping-service.GetQuote")
+	ctx, span := tracer.Start(ctx, "shipping-service.GetQuote")
 	defer span.End()
 
+	req, err := parseQuoteRequest(r)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("shipping.origin", req.Origin),
+		attribute.String("shipping.destination", req.Destination),
+		attribute.String("shipping.service_level", req.ServiceLevel),
+		attribute.Int("shipping.lines", len(req.Items)),
+		attribute.Bool("shipping.price_quote", req.QuoteToken != ""),
+	)
 	logger.Info().Msg("Calculating shipping quote...") // 使用 zerolog
 
-	time.Sleep(200 * time.Millisecond)
+	var resp *quoteResponse
+	if req.priceQuote, err = resolvePriceQuote(ctx, req.QuoteToken, req.Items); err == nil {
+		resp, err = quote(ctx, req)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error().Err(err).Msg("Failed to calculate shipping quote")
+		http.Error(w, err.Error(), quoteErrorStatus(err))
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int64("shipping.weight_grams", resp.Parcel.WeightGrams),
+		attribute.String("shipping.zone_pair", resp.OriginZone+"->"+resp.DestinationZone),
+		attribute.Int("shipping.options", len(resp.Options)),
+		attribute.String("shipping.currency", resp.Currency),
+		attribute.String("shipping.cost", resp.CostMoney.Decimal()),
+	)
+	if resp.Discount != nil {
+		span.SetAttributes(attribute.String("shipping.discount_rule", resp.Discount.RuleID))
+	}
 	span.AddEvent("Shipping quote calculated")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"cost": 10.0}`))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// quoteErrorStatus 把报价的错误映射为 HTTP 状态码
+func quoteErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, shipping.ErrNoOption), errors.Is(err, shipping.ErrInvalidPriceQuote):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, shipping.ErrUnknownItem), errors.Is(err, shipping.ErrInvalidItem),
+		errors.Is(err, shipping.ErrUnknownZone),
+		errors.Is(err, money.ErrUnknownCurrency), errors.Is(err, money.ErrNoRate):
+		return http.StatusBadRequest
+	case errors.Is(err, shipping.ErrPricingUnavailable):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// quote 汇总包裹重量和体积, 按费率表给出全部可选方式和预计送达日期, 需要时换算成请求的货币
+func quote(ctx context.Context, req quoteRequest) (*quoteResponse, error) {
+	parcel, err := items.BuildParcel(ctx, req.Items)
+	if err != nil {
+		return nil, err
+	}
+	resp := &quoteResponse{Parcel: parcel, Currency: baseCurrency.Code}
+	if resp.OriginZone, err = rateTable.Zone(req.Origin); err != nil {
+		return nil, err
+	}
+	if resp.DestinationZone, err = rateTable.Zone(req.Destination); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	resp.Options, resp.CarrierErrors, err = carriers.Quote(ctx, shipping.QuoteRequest{
+		Parcel: parcel, Origin: req.Origin, Destination: req.Destination, ServiceLevel: req.ServiceLevel,
+		Currency: baseCurrency, Now: now,
+	})
+	for _, failure := range resp.CarrierErrors {
+		trace.SpanFromContext(ctx).AddEvent("carrier quote failed", trace.WithAttributes(
+			attribute.String("carrier.code", failure.Carrier), attribute.String("error", failure.Error)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 承运商给出的是在途天数, 按截单时间和工作日历换算成具体的送达日期
+	if err := calendar.Apply(req.Origin, req.Destination, now, resp.Options); err != nil {
+		return nil, err
+	}
+	target := baseCurrency
+	if req.Currency != "" {
+		if target, err = money.LookupCurrency(req.Currency); err != nil {
+			return nil, err
+		}
+	}
+	// 运费优惠在基准货币下计算, 之后再换算成请求的货币
+	if !req.planned {
+		dc, err := discountContext(ctx, req.priceQuote, resp.DestinationZone, now)
+		if err != nil {
+			return nil, err
+		}
+		discounts.Apply(dc, resp.Options)
+	}
+	if target.Code != baseCurrency.Code {
+		if err := convertQuote(ctx, resp, target); err != nil {
+			return nil, err
+		}
+	}
+	resp.CostMoney, resp.Discount = resp.Options[0].Cost, resp.Options[0].Discount
+	resp.Cost = resp.CostMoney.Number()
+	return resp, nil
+}
+
+// resolvePriceQuote 向 pricing-service 校验报价令牌, 报价必须恰好覆盖 items; 未填写令牌时返回 nil
+func resolvePriceQuote(ctx context.Context, token string, items []shipping.Item) (*shipping.PriceQuote, error) {
+	if token == "" {
+		return nil, nil
+	}
+	pq, err := priceQuotes.Verify(ctx, token, items)
+	if err != nil {
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("quote.id", pq.QuoteID),
+		attribute.String("shipping.subtotal", pq.Total.Decimal()),
+		attribute.StringSlice("shipping.segments", pq.Segments),
+	)
+	return pq, nil
+}
+
+// discountContext 返回匹配运费优惠的上下文, 金额和分群取自校验过的商品报价 pq; 没有报价时金额为 0、没有分群
+func discountContext(ctx context.Context, pq *shipping.PriceQuote, destinationZone string, now time.Time) (shipping.DiscountContext, error) {
+	dc := shipping.DiscountContext{Subtotal: money.Zero(baseCurrency), DestinationZone: destinationZone, Now: now}
+	if pq == nil {
+		return dc, nil
+	}
+	subtotal, err := toBaseCurrency(ctx, pq.Total)
+	if err != nil {
+		return dc, err
+	}
+	dc.Subtotal, dc.Segments = subtotal, pq.Segments
+	return dc, nil
+}
+
+// toBaseCurrency 按最新的汇率表把金额换算成基准货币
+func toBaseCurrency(ctx context.Context, m money.Money) (money.Money, error) {
+	if m.Currency().Code == baseCurrency.Code {
+		return m, nil
+	}
+	table, err := rates.Table(ctx, 0)
+	if err != nil {
+		return m, err
+	}
+	return table.Convert(m, baseCurrency)
+}
+
+// parseQuoteRequest 支持 JSON 请求体和查询参数两种形式, 起运地默认为 SHIPPING_DEFAULT_ORIGIN
+func parseQuoteRequest(r *http.Request) (quoteRequest, error) {
+	var req quoteRequest
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid request body: %w", err)
+		}
+	} else {
+		q := r.URL.Query()
+		req.Origin = q.Get("origin")
+		req.Destination = q.Get("destination")
+		req.ServiceLevel = q.Get("service")
+		req.Currency = q.Get("currency")
+		req.QuoteToken = q.Get("quote_token")
+		for _, part := range strings.Split(q.Get("items"), ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			itemID, qtyStr, hasQty := strings.Cut(part, ":")
+			quantity := int64(1)
+			if hasQty {
+				n, err := strconv.ParseInt(qtyStr, 10, 64)
+				if err != nil {
+					return req, fmt.Errorf("invalid quantity in %q", part)
+				}
+				quantity = n
+			}
+			req.Items = append(req.Items, shipping.Item{ItemID: itemID, Quantity: quantity})
+		}
+	}
+	if req.Origin == "" {
+		req.Origin = defaultOrigin
+	}
+	if req.Destination == "" {
+		return req, fmt.Errorf("destination is required")
+	}
+	return req, nil
+}
+
+// 发货方案的策略
+const (
+	planSplit        = "split"        // 按分配的发货地区拆成多个包裹
+	planConsolidated = "consolidated" // 全部商品集中从一个发货地区发出
+)
+
+// planConcurrency 是一个发货方案中同时进行的报价数, 方案的规模上限见 shipping.MaxPlanOrigins
+const planConcurrency = 4
+
+// planRequest 是多仓订单的发货方案请求: items 中每行商品带有分配的发货地区 (origin),
+// 未填写时为 SHIPPING_DEFAULT_ORIGIN; 其余字段与 quoteRequest 相同。
+// consolidate 为 true 表示这些发货地区都可以发出全部商品, 允许在更便宜时集中发货。
+type planRequest struct {
+	Items        []shipping.AllocatedItem `json:"items"`
+	Destination  string                   `json:"destination"`
+	ServiceLevel string                   `json:"serviceLevel"`
+	Currency     string                   `json:"currency"`
+	QuoteToken   string                   `json:"quoteToken"`
+	Consolidate  bool                     `json:"consolidate"`
+}
+
+// quoteRequest 返回从 origin 寄出 items 的包裹报价请求: 以基准货币计且不应用运费优惠, 由 plan 按整单应用后再换算货币
+func (r planRequest) quoteRequest(origin string, items []shipping.Item) quoteRequest {
+	return quoteRequest{
+		Items: items, Origin: origin, Destination: r.Destination, ServiceLevel: r.ServiceLevel, planned: true,
+	}
+}
+
+// planParcel 是方案中的一个包裹: 发货地区和这个包裹的报价, 采用报价中最便宜的方式
+type planParcel struct {
+	Origin string `json:"origin"`
+	*quoteResponse
+}
+
+// planResponse 是发货方案。cost 是采用的方案优惠后的整单运费, 有优惠生效时 originalCost 是各包裹运费之和,
+// discount 是按整单应用一次的优惠规则; 包裹中的报价不含优惠。splitCost 和 consolidatedCost
+// 分别是拆分发货和最便宜的集中发货优惠后的运费, 没有比较集中发货时 consolidatedCost 为空;
+// latestDate 是最后一个包裹的最晚送达日。
+type planResponse struct {
+	Strategy         string                    `json:"strategy"`
+	Parcels          []planParcel              `json:"parcels"`
+	Cost             money.Money               `json:"cost"`
+	OriginalCost     *money.Money              `json:"originalCost,omitempty"`
+	Discount         *shipping.AppliedDiscount `json:"discount,omitempty"`
+	SplitCost        money.Money               `json:"splitCost"`
+	ConsolidatedCost *money.Money              `json:"consolidatedCost,omitempty"`
+	Currency         string                    `json:"currency"`
+	LatestDate       string                    `json:"latestDate,omitempty"`
+}
+
+// handleShippingPlan 按商品分配的发货地区拆分包裹并分别报价, 返回合并后的发货方案
+func handleShippingPlan(w http.ResponseWriter, r *http.Request) {
+	logger := zlog.Ctx(r.Context())
+	ctx, span := tracer.Start(r.Context(), "shipping-service.ShippingPlan")
+	defer span.End()
+
+	var req planRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Destination == "" {
+		http.Error(w, "destination is required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("shipping.destination", req.Destination),
+		attribute.String("shipping.service_level", req.ServiceLevel),
+		attribute.Int("shipping.lines", len(req.Items)),
+		attribute.Bool("shipping.consolidate", req.Consolidate),
+	)
+
+	resp, err := plan(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error().Err(err).Msg("Failed to plan shipment")
+		http.Error(w, err.Error(), quoteErrorStatus(err))
+		return
+	}
+	span.SetAttributes(
+		attribute.String("shipping.plan_strategy", resp.Strategy),
+		attribute.Int("shipping.parcels", len(resp.Parcels)),
+		attribute.String("shipping.currency", resp.Currency),
+		attribute.String("shipping.cost", resp.Cost.Decimal()),
+	)
+	if resp.Discount != nil {
+		span.SetAttributes(attribute.String("shipping.discount_rule", resp.Discount.RuleID))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// plan 按发货地区把商品分成包裹, 每个包裹走与 /get_quote 相同的承运商报价和送达日期计算, 采用原运费最便宜的方式。
+// 运费优惠是整单优惠, 不在包裹上重复应用: 各方案按全部包裹的运费之和只匹配一次规则 (满额门槛比较整单金额, 立减只减一次)。
+// 允许集中发货时, 还会把全部商品从每个发货地区整单报价, 优惠后的整单运费严格低于拆分运费时采用集中发货;
+// 集中发货的报价失败 (例如整单超出承运商的重量限制) 时只放弃这个候选, 不影响拆分方案。
+func plan(ctx context.Context, req planRequest) (*planResponse, error) {
+	groups, err := shipping.GroupByOrigin(req.Items, defaultOrigin)
+	if err != nil {
+		return nil, err
+	}
+	target := baseCurrency
+	if req.Currency != "" {
+		if target, err = money.LookupCurrency(req.Currency); err != nil {
+			return nil, err
+		}
+	}
+	var all []shipping.Item
+	for _, g := range groups {
+		all = append(all, g.Items...)
+	}
+	all = shipping.MergeItems(all)
+	// 报价令牌覆盖的是整单商品, 只校验一次
+	pq, err := resolvePriceQuote(ctx, req.QuoteToken, all)
+	if err != nil {
+		return nil, err
+	}
+	// 前 len(groups) 个是拆分的包裹, 之后是每个发货地区的整单报价
+	requests := make([]quoteRequest, 0, 2*len(groups))
+	for _, g := range groups {
+		requests = append(requests, req.quoteRequest(g.Origin, g.Items))
+	}
+	consolidate := req.Consolidate && len(groups) > 1
+	if consolidate {
+		for _, g := range groups {
+			requests = append(requests, req.quoteRequest(g.Origin, all))
+		}
+	}
+	// 同时进行的报价不超过 planConcurrency 个, 每个报价内部还会并发询价全部承运商
+	quotes := make([]*quoteResponse, len(requests))
+	errs := make([]error, len(requests))
+	sem := make(chan struct{}, planConcurrency)
+	var wg sync.WaitGroup
+	for i, r := range requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			quotes[i], errs[i] = quote(ctx, r)
+		}()
+	}
+	wg.Wait()
+
+	resp := &planResponse{Strategy: planSplit}
+	splitOptions := make([]shipping.Option, 0, len(groups))
+	for i, g := range groups {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("parcel from %s: %w", g.Origin, errs[i])
+		}
+		resp.Parcels = append(resp.Parcels, planParcel{Origin: g.Origin, quoteResponse: quotes[i]})
+		splitOptions = append(splitOptions, quotes[i].Options[0])
+	}
+	dc, err := discountContext(ctx, pq, quotes[0].DestinationZone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	split := discounts.ApplyOrder(dc, splitOptions)
+	resp.SplitCost = split.Cost
+	chosen := split
+
+	if consolidate {
+		var (
+			best     *planParcel
+			bestCost shipping.OrderCost
+		)
+		for i, g := range groups {
+			q, err := quotes[len(groups)+i], errs[len(groups)+i]
+			if err != nil {
+				trace.SpanFromContext(ctx).AddEvent("consolidated quote failed", trace.WithAttributes(
+					attribute.String("shipping.origin", g.Origin), attribute.String("error", err.Error())))
+				continue
+			}
+			oc := discounts.ApplyOrder(dc, q.Options[:1])
+			if best == nil || oc.Cost.Cmp(bestCost.Cost) < 0 {
+				best, bestCost = &planParcel{Origin: g.Origin, quoteResponse: q}, oc
+			}
+		}
+		if best != nil {
+			resp.ConsolidatedCost = &bestCost.Cost
+			if bestCost.Cost.Cmp(split.Cost) < 0 {
+				resp.Strategy, resp.Parcels, chosen = planConsolidated, []planParcel{*best}, bestCost
+			}
+		}
+	}
+	resp.Cost, resp.Discount = chosen.Cost, chosen.Discount
+	if chosen.Discount != nil {
+		resp.OriginalCost = &chosen.Original
+	}
+
+	resp.Currency = baseCurrency.Code
+	if target.Code != baseCurrency.Code {
+		if err := convertPlan(ctx, resp, target); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range resp.Parcels {
+		resp.LatestDate = max(resp.LatestDate, p.Options[0].Delivery.LatestDate)
+	}
+	return resp, nil
+}
+
+// convertPlan 把方案中的包裹报价和各项运费换算成 target 货币
+func convertPlan(ctx context.Context, resp *planResponse, target money.Currency) error {
+	for _, p := range resp.Parcels {
+		if err := convertQuote(ctx, p.quoteResponse, target); err != nil {
+			return err
+		}
+		p.CostMoney = p.Options[0].Cost
+		p.Cost = p.CostMoney.Number()
+	}
+	table, err := rates.Table(ctx, 0)
+	if err != nil {
+		return err
+	}
+	amounts := []*money.Money{&resp.Cost, &resp.SplitCost, resp.ConsolidatedCost, resp.OriginalCost}
+	if resp.Discount != nil {
+		discount := *resp.Discount
+		resp.Discount = &discount
+		amounts = append(amounts, &discount.Discount)
+	}
+	for _, m := range amounts {
+		if m == nil {
+			continue
+		}
+		if *m, err = table.Convert(*m, target); err != nil {
+			return err
+		}
+	}
+	resp.Currency = target.Code
+	return nil
+}
+
+// convertQuote 按最新的汇率表把每个可选方式的运费换算成 target 货币
+func convertQuote(ctx context.Context, resp *quoteResponse, target money.Currency) error {
+	table, err := rates.Table(ctx, 0)
+	if err != nil {
+		return err
+	}
+	conversion, err := table.Conversion(baseCurrency, target)
+	if err != nil {
+		return err
+	}
+	for i := range resp.Options {
+		o := &resp.Options[i]
+		if o.Cost, err = table.Convert(o.Cost, target); err != nil {
+			return err
+		}
+		if o.OriginalCost != nil {
+			original, err := table.Convert(*o.OriginalCost, target)
+			if err != nil {
+				return err
+			}
+			o.OriginalCost = &original
+		}
+		if o.Discount != nil {
+			discount := *o.Discount
+			if discount.Discount, err = table.Convert(discount.Discount, target); err != nil {
+				return err
+			}
+			o.Discount = &discount
+		}
+	}
+	resp.Currency = target.Code
+	resp.Conversion = &conversion
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleCreateShipment 向承运商下单并保存运单
+func handleCreateShipment(w http.ResponseWriter, r *http.Request) {
+	logger := zlog.Ctx(r.Context())
+	ctx, span := tracer.Start(r.Context(), "shipping-service.CreateShipment")
+	defer span.End()
+
+	var req shipping.CreateShipmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 幂等键也可以放在 Idempotency-Key 请求头中
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	span.SetAttributes(
+		attribute.String("order.id", req.OrderID),
+		attribute.String("shipping.carrier", req.Carrier),
+		attribute.String("shipping.service_level", req.ServiceLevel),
+		attribute.String("shipping.idempotency_key", req.IdempotencyKey),
+	)
+	shipment, replayed, err := shipments.Create(ctx, req)
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", req.OrderID).Msg("Failed to create shipment")
+		writeShipmentError(w, span, err)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("shipment.id", shipment.ID),
+		attribute.String("shipment.tracking_number", shipment.TrackingNumber),
+		attribute.Bool("shipping.idempotent_replay", replayed),
+	)
+	if replayed {
+		// 重试的请求返回第一次创建的运单, 不会向承运商重复下单
+		logger.Info().Str("shipment_id", shipment.ID).Str("idempotency_key", req.IdempotencyKey).Msg("Shipment already created for idempotency key")
+		writeJSON(w, http.StatusOK, shipment)
+		return
+	}
+	logger.Info().Str("shipment_id", shipment.ID).Str("tracking_number", shipment.TrackingNumber).Msg("Shipment created")
+	writeJSON(w, http.StatusCreated, shipment)
+}
+
+// handleListShipments 返回订单的全部运单: GET /shipments?orderId=...
+func handleListShipments(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "shipping-service.ListShipments")
+	defer span.End()
+
+	orderID := r.URL.Query().Get("orderId")
+	if orderID == "" {
+		http.Error(w, "orderId is required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("order.id", orderID))
+	list, err := shipments.ListByOrder(ctx, orderID)
+	if err != nil {
+		writeShipmentError(w, span, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetShipment 返回运单详情和全部状态变化
+func handleGetShipment(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "shipping-service.GetShipment")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("shipment.id", r.PathValue("id")))
+	shipment, err := shipments.Get(ctx, r.PathValue("id"))
+	if err != nil {
+		writeShipmentError(w, span, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, shipment)
+}
+
+// handleSyncShipment 立即向承运商拉取轨迹并更新运单状态
+func handleSyncShipment(w http.ResponseWriter, r *http.Request) {
+	logger := zlog.Ctx(r.Context())
+	ctx, span := tracer.Start(r.Context(), "shipping-service.SyncShipment")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("shipment.id", r.PathValue("id")))
+	shipment, err := shipments.Sync(ctx, r.PathValue("id"))
+	if err != nil {
+		logger.Error().Err(err).Str("shipment_id", r.PathValue("id")).Msg("Failed to sync shipment tracking")
+		writeShipmentError(w, span, err)
+		return
+	}
+	span.SetAttributes(attribute.String("shipment.status", shipment.Status))
+	writeJSON(w, http.StatusOK, shipment)
+}
+
+// handleGetLabel 返回运单的面单: GET /shipments/{id}/label?format=pdf|zpl, 默认 PDF
+func handleGetLabel(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "shipping-service.GetLabel")
+	defer span.End()
+
+	id, format := r.PathValue("id"), r.URL.Query().Get("format")
+	if format == "" {
+		format = shipping.LabelPDF
+	}
+	span.SetAttributes(attribute.String("shipment.id", id), attribute.String("label.format", format))
+	label, err := shipments.Label(ctx, id, format)
+	if err != nil {
+		zlog.Ctx(ctx).Error().Err(err).Str("shipment_id", id).Msg("Failed to get shipping label")
+		writeShipmentError(w, span, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("label.bytes", len(label.Content)))
+	w.Header().Set("Content-Type", label.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="label-%s.%s"`, id, format))
+	w.Write(label.Content)
+}
+
+// transitionRequest 是人工变更运单状态的请求, 例如客服确认异常件已处理
+type transitionRequest struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+	Location    string `json:"location"`
+}
+
+// handleTransitionShipment 人工变更运单状态, 同样受状态机约束
+func handleTransitionShipment(w http.ResponseWriter, r *http.Request) {
+	logger := zlog.Ctx(r.Context())
+	ctx, span := tracer.Start(r.Context(), "shipping-service.TransitionShipment")
+	defer span.End()
+
+	var req transitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Status == "" {
+		http.Error(w, "request body needs a status", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("shipment.id", id), attribute.String("shipment.status", req.Status))
+	shipment, changed, err := shipments.Transition(ctx, id, shipping.Transition{
+		Status: req.Status, Source: shipping.SourceManual, Description: req.Description, Location: req.Location,
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("shipment_id", id).Msg("Failed to change shipment status")
+		writeShipmentError(w, span, err)
+		return
+	}
+	span.SetAttributes(attribute.Bool("shipment.changed", changed))
+	writeJSON(w, http.StatusOK, shipment)
+}
+
+// handleCarrierWebhook 接收承运商推送的轨迹: 校验签名和重放, 再把每条轨迹应用到运单的状态机上。
+// 同一个事件 ID 只处理一次, 响应中列出每条轨迹的处理结果。
+func handleCarrierWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := zlog.Ctx(r.Context())
+	ctx, span := tracer.Start(r.Context(), "shipping-service.CarrierWebhook")
+	defer span.End()
+
+	code := r.PathValue("carrier")
+	span.SetAttributes(attribute.String("carrier.code", code))
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := carriers.Get(code); err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := webhooks.Verify(ctx, code, r.Header, body); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Warn().Err(err).Str("carrier", code).Msg("Rejected carrier webhook")
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, shipping.ErrInvalidSignature) || errors.Is(err, shipping.ErrReplayedWebhook) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	results, err := shipments.HandleWebhook(ctx, code, body)
+	if err != nil {
+		logger.Error().Err(err).Str("carrier", code).Msg("Failed to process carrier webhook")
+		writeShipmentError(w, span, err)
+		return
+	}
+	applied, unknown := 0, 0
+	for _, result := range results {
+		switch result.Outcome {
+		case shipping.WebhookApplied:
+			applied++
+		case shipping.WebhookUnknownShipment:
+			unknown++
+		}
+	}
+	span.SetAttributes(attribute.Int("webhook.events", len(results)), attribute.Int("webhook.applied", applied),
+		attribute.Int("webhook.unknown_shipments", unknown))
+	if unknown > 0 {
+		// 运单可能还没有入库 (承运商在下单事务提交前就推送了轨迹): 不登记 nonce 并返回 503, 让承运商原样重试;
+		// 这次已处理的事件按事件 ID 去重, 重试时只会再处理找不到运单的那些
+		logger.Warn().Str("carrier", code).Int("events", len(results)).Int("unknown", unknown).Msg("Carrier webhook references unknown shipments, asking for a retry")
+		w.Header().Set("Retry-After", "30")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"results": results})
+		return
+	}
+	// 处理成功后才登记 nonce; 登记失败时重放最多再处理一次, 已处理的事件 ID 会被去重
+	if err := webhooks.Consume(ctx, code, r.Header); err != nil {
+		span.RecordError(err)
+		logger.Warn().Err(err).Str("carrier", code).Msg("Failed to record carrier webhook nonce")
+	}
+	logger.Info().Str("carrier", code).Int("events", len(results)).Int("applied", applied).Msg("Carrier webhook processed")
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// handleValidateAddress 校验并规范化一个地址, 返回字段级的错误和对应的运费区域。
+// 地址不合法时同样返回 200, 由调用方 (例如下单流程) 根据 valid 和 errors 提示用户。
+func handleValidateAddress(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "shipping-service.ValidateAddress")
+	defer span.End()
+
+	var address shipping.Address
+	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := shipping.ValidateAddress(address, rateTable)
+	span.SetAttributes(
+		attribute.Bool("address.valid", result.Valid),
+		attribute.String("address.region", result.Region),
+		attribute.String("address.zone", result.Zone),
+		attribute.Int("address.errors", len(result.Errors)),
+		attribute.Int("address.corrections", len(result.Corrections)),
+	)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeShipmentError 把运单相关的错误映射为 HTTP 状态码, 地址错误以 JSON 返回每个字段的错误
+func writeShipmentError(w http.ResponseWriter, span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	var addrErr *shipping.AddressError
+	if errors.As(err, &addrErr) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "address": addrErr.Field, "errors": addrErr.Errors})
+		return
+	}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, shipping.ErrShipmentNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, shipping.ErrInvalidTransition):
+		status = http.StatusConflict
+	case errors.Is(err, shipping.ErrInvalidShipment), errors.Is(err, shipping.ErrUnknownCarrier), errors.Is(err, shipping.ErrInvalidWebhook),
+		errors.Is(err, shipping.ErrUnknownLabelFormat),
+		errors.Is(err, shipping.ErrUnknownItem), errors.Is(err, shipping.ErrInvalidItem),
+		errors.Is(err, shipping.ErrUnsupported), errors.Is(err, shipping.ErrCarrierRejected):
+		status = http.StatusBadRequest
+	case errors.Is(err, shipping.ErrCarrierUnavailable):
+		status = http.StatusBadGateway
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// runOutboxRelay 发布发件箱中的运单事件: 有新事件写入时立即发布, 另外每隔 interval 重试之前发布失败的事件
+func runOutboxRelay(ctx context.Context, interval time.Duration) {
+	zlog.Info().Msgf("✅ Shipment outbox relay started, retrying every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shipments.OutboxReady():
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		// 一次最多发布 100 条, 发满时说明还有积压, 继续发布
+		for {
+			spanCtx, span := tracer.Start(ctx, "shipping-service.RelayOutbox")
+			spanCtx = zlog.Logger.WithContext(spanCtx)
+			published, err := shipments.RelayOutbox(spanCtx, 100)
+			span.SetAttributes(attribute.Int("shipping.published", published))
+			if err != nil {
+				zlog.Error().Err(err).Msg("Shipment outbox relay failed, will retry")
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+			if err != nil || published < 100 {
+				break
+			}
+		}
+	}
+}
+
+// runTrackingPoller 按固定周期同步未签收的运单, 每次最多同步 100 个超过一个周期没有轮询过的运单
+func runTrackingPoller(ctx context.Context, interval time.Duration) {
+	zlog.Info().Msgf("✅ Tracking poller started, running every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			spanCtx, span := tracer.Start(ctx, "shipping-service.PollTracking")
+			spanCtx = zlog.Logger.WithContext(spanCtx)
+			synced, err := shipments.SyncActive(spanCtx, interval, 100)
+			if err != nil {
+				zlog.Error().Err(err).Msg("Tracking poll failed")
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.SetAttributes(attribute.Int("shipping.synced", synced))
+			span.End()
+		case <-ctx.Done():
+			return
+		}
+	}
 }
@@ -0,0 +1,14 @@
+CREATE TABLE `dynamic_price_audit` (
+                                       `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+                                       `item_id` VARCHAR(64) NOT NULL COMMENT '商品SKU',
+                                       `old_multiplier` DECIMAL(8, 4) NOT NULL COMMENT '调价前的价格倍数',
+                                       `new_multiplier` DECIMAL(8, 4) NOT NULL COMMENT '调价后的价格倍数',
+                                       `metric` VARCHAR(32) NOT NULL DEFAULT '' COMMENT '使用的库存指标: availability, reservation',
+                                       `metric_value` DECIMAL(12, 4) NOT NULL DEFAULT 0 COMMENT '调价时的指标值',
+                                       `available` BIGINT NOT NULL DEFAULT 0 COMMENT '调价时的可售库存',
+                                       `reserved` BIGINT NOT NULL DEFAULT 0 COMMENT '调价时的已预占库存',
+                                       `reason` VARCHAR(16) NOT NULL COMMENT '调价原因: stock-库存变化, config-配置变化',
+                                       `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                       PRIMARY KEY (`id`),
+                                       KEY `idx_item_id` (`item_id`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='动态定价调价审计表';
@@ -0,0 +1,16 @@
+CREATE TABLE `exchange_rate_version` (
+                                         `version` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '汇率表版本号',
+                                         `base_currency` CHAR(3) NOT NULL COMMENT '基准货币 (ISO-4217)',
+                                         `published_at` DATETIME NOT NULL COMMENT '生效时间, 早于该时间的请求不会使用此版本',
+                                         `note` VARCHAR(255) NOT NULL DEFAULT '' COMMENT '备注, 例如汇率来源',
+                                         `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                         PRIMARY KEY (`version`),
+                                         KEY `idx_published_at` (`published_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='汇率表版本, 已发布的版本不可修改';
+
+CREATE TABLE `exchange_rate` (
+                                 `version` BIGINT UNSIGNED NOT NULL COMMENT '汇率表版本号',
+                                 `currency` CHAR(3) NOT NULL COMMENT '货币 (ISO-4217)',
+                                 `rate` DECIMAL(20, 10) NOT NULL COMMENT '1 单位基准货币可兑换的该货币数量',
+                                 PRIMARY KEY (`version`, `currency`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='汇率明细表';
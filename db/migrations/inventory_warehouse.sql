@@ -0,0 +1,36 @@
+CREATE TABLE `warehouse` (
+                             `id` VARCHAR(64) NOT NULL COMMENT '仓库编码',
+                             `name` VARCHAR(128) NOT NULL COMMENT '仓库名称',
+                             `region` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '所在区域, 用于就近分配和物流始发地',
+                             `latitude` DECIMAL(9, 6) NOT NULL DEFAULT 0 COMMENT '纬度',
+                             `longitude` DECIMAL(9, 6) NOT NULL DEFAULT 0 COMMENT '经度',
+                             `priority` INT NOT NULL DEFAULT 100 COMMENT '分配优先级, 数值越小越优先',
+                             `status` TINYINT UNSIGNED NOT NULL DEFAULT 1 COMMENT '状态: 1-启用, 2-停用',
+                             `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                             `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                             PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='仓库表';
+
+CREATE TABLE `warehouse_stock` (
+                                   `warehouse_id` VARCHAR(64) NOT NULL COMMENT '仓库编码',
+                                   `item_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+                                   `available` BIGINT NOT NULL DEFAULT 0 COMMENT '可售库存',
+                                   `reserved` BIGINT NOT NULL DEFAULT 0 COMMENT '已预占库存',
+                                   `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (`warehouse_id`, `item_id`),
+                                   INDEX `idx_item` (`item_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='分仓库存表';
+
+CREATE TABLE `stock_reservation` (
+                                     `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+                                     `order_id` VARCHAR(64) NOT NULL COMMENT '订单ID',
+                                     `item_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+                                     `warehouse_id` VARCHAR(64) NOT NULL COMMENT '出库仓库',
+                                     `quantity` BIGINT NOT NULL COMMENT '预占数量',
+                                     `status` TINYINT UNSIGNED NOT NULL DEFAULT 1 COMMENT '状态: 1-预占中, 2-已释放',
+                                     `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                     `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                     PRIMARY KEY (`id`),
+                                     INDEX `idx_order_item` (`order_id`, `item_id`),
+                                     INDEX `idx_status_created` (`status`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='库存预占记录表';
@@ -0,0 +1,9 @@
+CREATE TABLE `order_state` (
+                               `order_id` VARCHAR(64) NOT NULL COMMENT '订单ID',
+                               `user_id` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '下单用户ID',
+                               `status` VARCHAR(16) NOT NULL COMMENT '订单状态: pending, paid, shipped, delivered, completed, cancelled, failed, compensated',
+                               `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                               PRIMARY KEY (`order_id`),
+                               INDEX `idx_status_updated` (`status`, `updated_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='订单状态表, 供 /order_status 查询 (库存对账据此释放孤儿预占)';
@@ -0,0 +1,24 @@
+CREATE TABLE `price_catalog` (
+                                 `sku` VARCHAR(64) NOT NULL COMMENT '商品SKU, 与库存服务的 itemId 一致',
+                                 `name` VARCHAR(128) NOT NULL DEFAULT '' COMMENT '商品名称',
+                                 `category` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '商品分类',
+                                 `base_price` DECIMAL(12, 2) NOT NULL COMMENT '基础单价',
+                                 `status` TINYINT UNSIGNED NOT NULL DEFAULT 1 COMMENT '状态: 1-在售, 2-下架',
+                                 `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                 `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                 PRIMARY KEY (`sku`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='商品价格目录表';
+
+CREATE TABLE `price_tier` (
+                              `sku` VARCHAR(64) NOT NULL COMMENT '商品SKU',
+                              `min_quantity` INT UNSIGNED NOT NULL COMMENT '阶梯起始数量 (含)',
+                              `unit_price` DECIMAL(12, 2) NOT NULL COMMENT '达到该数量时的单价',
+                              PRIMARY KEY (`sku`, `min_quantity`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='数量阶梯价表';
+
+CREATE TABLE `price_list_item` (
+                                   `list_code` VARCHAR(32) NOT NULL COMMENT '价目表编码, 例如 vip',
+                                   `sku` VARCHAR(64) NOT NULL COMMENT '商品SKU',
+                                   `unit_price` DECIMAL(12, 2) NOT NULL COMMENT '价目表单价',
+                                   PRIMARY KEY (`list_code`, `sku`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='专属价目表 (VIP 等)';
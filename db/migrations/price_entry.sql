@@ -0,0 +1,14 @@
+CREATE TABLE `price_entry` (
+                               `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+                               `sku` VARCHAR(64) NOT NULL COMMENT '商品SKU',
+                               `kind` VARCHAR(8) NOT NULL COMMENT '价格种类: base-基础价, tier-阶梯价, list-价目表价格',
+                               `list_code` VARCHAR(32) NOT NULL DEFAULT '' COMMENT '价目表编码, 仅 list 使用',
+                               `min_quantity` BIGINT NOT NULL DEFAULT 0 COMMENT '起订数量, 仅 tier 使用',
+                               `unit_price` DECIMAL(12, 2) NOT NULL COMMENT '单价',
+                               `effective_from` DATETIME NOT NULL COMMENT '生效时间 (含)',
+                               `effective_to` DATETIME NULL DEFAULT NULL COMMENT '失效时间 (不含), 为空表示长期有效',
+                               `note` VARCHAR(255) NOT NULL DEFAULT '' COMMENT '备注, 例如促销活动名',
+                               `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               PRIMARY KEY (`id`),
+                               KEY `idx_sku_effective` (`sku`, `effective_from`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='排期价格表, 只增不改, 保留全部历史价格';
@@ -0,0 +1,10 @@
+CREATE TABLE `price_snapshot` (
+                                  `quote_id` VARCHAR(64) NOT NULL COMMENT '报价ID, 与报价令牌中的 quoteId 一致',
+                                  `order_id` VARCHAR(64) NULL DEFAULT NULL COMMENT '下单时绑定的订单ID, 未下单为空',
+                                  `user_id` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+                                  `result` JSON NOT NULL COMMENT '签发报价时的完整计价结果',
+                                  `priced_at` DATETIME NOT NULL COMMENT '计价时刻',
+                                  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  PRIMARY KEY (`quote_id`),
+                                  UNIQUE KEY `uk_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='计价快照表, 订单和退款按下单时绑定的快照复原价格';
@@ -0,0 +1,36 @@
+CREATE TABLE `shipment` (
+                            `id` CHAR(36) NOT NULL COMMENT '运单ID (UUID)',
+                            `order_id` VARCHAR(64) NOT NULL COMMENT '订单ID, 一个订单可以有多个运单',
+                            `idempotency_key` VARCHAR(128) NULL DEFAULT NULL COMMENT '创建运单的幂等键, 在同一订单内唯一标识一个包裹',
+                            `carrier` VARCHAR(32) NOT NULL COMMENT '承运商编码',
+                            `service_level` VARCHAR(16) NOT NULL COMMENT '服务等级: standard, express, same_day',
+                            `tracking_number` VARCHAR(64) NOT NULL COMMENT '承运商运单号',
+                            `status` VARCHAR(32) NOT NULL COMMENT '运单状态: label_created, picked_up, in_transit, out_for_delivery, delivered, exception',
+                            `cost` DECIMAL(18,4) NOT NULL COMMENT '承运商收取的运费',
+                            `currency` CHAR(3) NOT NULL COMMENT '运费的货币代码 (ISO 4217)',
+                            `parcel` JSON NOT NULL COMMENT '包裹的商品、重量和体积',
+                            `from_address` JSON NOT NULL COMMENT '发件地址',
+                            `to_address` JSON NOT NULL COMMENT '收件地址',
+                            `last_event_at` TIMESTAMP(3) NULL DEFAULT NULL COMMENT '最近一次应用的承运商轨迹时间, 同步轨迹时只处理在它之后的轨迹',
+                            `last_polled_at` TIMESTAMP(3) NULL DEFAULT NULL COMMENT '最近一次轮询承运商轨迹的时间, 未轮询过为空',
+                            `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                            PRIMARY KEY (`id`),
+                            UNIQUE KEY `uk_carrier_tracking` (`carrier`, `tracking_number`),
+                            UNIQUE KEY `uk_order_idempotency` (`order_id`, `idempotency_key`),
+                            INDEX `idx_status_polled` (`status`, `last_polled_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='运单表';
+
+CREATE TABLE `shipment_event` (
+                                  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+                                  `shipment_id` CHAR(36) NOT NULL COMMENT '运单ID',
+                                  `from_status` VARCHAR(32) NOT NULL DEFAULT '' COMMENT '变化前的状态, 创建运单时为空',
+                                  `to_status` VARCHAR(32) NOT NULL COMMENT '变化后的状态',
+                                  `source` VARCHAR(16) NOT NULL COMMENT '变化来源: create, tracking, webhook, manual',
+                                  `description` VARCHAR(255) NOT NULL DEFAULT '' COMMENT '承运商轨迹描述或人工备注',
+                                  `location` VARCHAR(128) NOT NULL DEFAULT '' COMMENT '轨迹发生地点',
+                                  `occurred_at` TIMESTAMP(3) NOT NULL COMMENT '状态变化发生的时间',
+                                  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  PRIMARY KEY (`id`),
+                                  INDEX `idx_shipment` (`shipment_id`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='运单状态变化流水表';
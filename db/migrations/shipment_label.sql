@@ -0,0 +1,7 @@
+CREATE TABLE `shipment_label` (
+                                  `shipment_id` CHAR(36) NOT NULL COMMENT '运单ID',
+                                  `format` VARCHAR(8) NOT NULL COMMENT '面单格式: pdf, zpl',
+                                  `content` MEDIUMBLOB NOT NULL COMMENT '面单文件内容',
+                                  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  PRIMARY KEY (`shipment_id`, `format`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='运单面单表, 第一次生成后保存, 重新打印时返回同一份';
@@ -0,0 +1,10 @@
+CREATE TABLE `shipment_outbox` (
+                                   `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键, 按它的顺序发布',
+                                   `shipment_id` CHAR(36) NOT NULL COMMENT '运单ID',
+                                   `payload` JSON NOT NULL COMMENT '待发布到 shipping-events 主题的运单事件',
+                                   `trace_context` JSON NOT NULL COMMENT '写入时的追踪上下文 (W3C traceparent 等)',
+                                   `attempts` INT NOT NULL DEFAULT 0 COMMENT '发布失败的次数',
+                                   `last_error` VARCHAR(255) NOT NULL DEFAULT '' COMMENT '最近一次发布失败的原因',
+                                   `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='运单事件发件箱, 与运单状态在同一事务中写入, 发布成功后删除';
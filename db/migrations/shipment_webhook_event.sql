@@ -0,0 +1,12 @@
+CREATE TABLE `shipment_webhook_event` (
+                                          `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+                                          `carrier` VARCHAR(32) NOT NULL COMMENT '承运商编码',
+                                          `event_id` VARCHAR(128) NOT NULL COMMENT '承运商生成的事件ID, 重复推送时保持不变',
+                                          `shipment_id` CHAR(36) NOT NULL COMMENT '运单ID',
+                                          `code` VARCHAR(64) NOT NULL COMMENT '承运商自己的状态码',
+                                          `outcome` VARCHAR(16) NOT NULL COMMENT '处理结果: applied, ignored',
+                                          `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                          PRIMARY KEY (`id`),
+                                          UNIQUE KEY `uk_carrier_event` (`carrier`, `event_id`),
+                                          INDEX `idx_shipment` (`shipment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='承运商推送事件表, 按事件ID去重';
@@ -0,0 +1,10 @@
+CREATE TABLE `shipping_item` (
+                                 `sku` VARCHAR(64) NOT NULL COMMENT '商品SKU, 与库存服务的 itemId 一致',
+                                 `weight_grams` INT UNSIGNED NOT NULL COMMENT '单件含包装重量 (克)',
+                                 `length_mm` INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '包装长度 (毫米)',
+                                 `width_mm` INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '包装宽度 (毫米)',
+                                 `height_mm` INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '包装高度 (毫米)',
+                                 `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                 `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                 PRIMARY KEY (`sku`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='商品重量和包装尺寸表, 用于计算运费';
@@ -0,0 +1,13 @@
+CREATE TABLE `stock_ledger` (
+                                `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+                                `warehouse_id` VARCHAR(64) NOT NULL COMMENT '仓库编码',
+                                `item_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+                                `delta` BIGINT NOT NULL COMMENT '可售库存变化量, 正数为入库, 负数为出库',
+                                `balance_after` BIGINT NOT NULL COMMENT '调整后的可售库存',
+                                `reason` VARCHAR(32) NOT NULL COMMENT '调整原因编码: restock, cycle_count, damage, return, correction, transfer',
+                                `reference` VARCHAR(128) NOT NULL DEFAULT '' COMMENT '外部单据号, 例如导入批次或盘点单号',
+                                `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                PRIMARY KEY (`id`),
+                                INDEX `idx_item_created` (`item_id`, `created_at`),
+                                INDEX `idx_reference` (`reference`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='库存调整流水表';
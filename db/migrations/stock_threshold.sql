@@ -0,0 +1,9 @@
+CREATE TABLE `stock_threshold` (
+                                   `item_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+                                   `reorder_point` BIGINT NOT NULL COMMENT '补货点, 全仓可售库存低于该值时告警',
+                                   `alert_state` TINYINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '告警状态: 0-待触发, 1-已告警(库存回到补货点以上后重新待触发)',
+                                   `last_alerted_at` TIMESTAMP NULL DEFAULT NULL COMMENT '最近一次告警时间, 用于告警冷却',
+                                   `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (`item_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='低库存告警阈值表';
@@ -0,0 +1,14 @@
+CREATE TABLE `stock_waitlist` (
+                                  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '自增主键, 同时决定先来后到的顺序',
+                                  `item_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+                                  `user_id` VARCHAR(64) NOT NULL COMMENT '订阅用户ID',
+                                  `quantity` BIGINT NOT NULL DEFAULT 1 COMMENT '用户想要购买的数量',
+                                  `status` TINYINT UNSIGNED NOT NULL DEFAULT 1 COMMENT '状态: 1-等待中, 2-已通知, 3-已取消',
+                                  `notified_at` TIMESTAMP NULL DEFAULT NULL COMMENT '到货通知时间',
+                                  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                  PRIMARY KEY (`id`),
+                                  UNIQUE KEY `uk_item_user` (`item_id`, `user_id`),
+                                  INDEX `idx_item_status` (`item_id`, `status`, `id`),
+                                  INDEX `idx_user` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='到货提醒订阅表';
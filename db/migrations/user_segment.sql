@@ -0,0 +1,8 @@
+CREATE TABLE `user_segment` (
+                                `user_id` VARCHAR(64) NOT NULL COMMENT '用户ID',
+                                `segment` VARCHAR(32) NOT NULL COMMENT '用户分群, 例如 vip、new_user',
+                                `expires_at` DATETIME NULL DEFAULT NULL COMMENT '分群失效时间, 为空表示长期有效',
+                                `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                                PRIMARY KEY (`user_id`, `segment`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户分群表, pricing-service 签发报价时据此写入令牌中的用户分群';
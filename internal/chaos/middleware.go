@@ -0,0 +1,167 @@
+// internal/chaos/middleware.go
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Injector 根据当前生效的规则对 HTTP 请求注入故障, 规则可以在运行时热更新
+type Injector struct {
+	service string
+	tracer  trace.Tracer
+	enabled atomic.Bool
+	rules   atomic.Pointer[[]*compiledRule]
+}
+
+// NewInjector 创建一个故障注入器, 默认没有任何规则
+func NewInjector(service string) *Injector {
+	i := &Injector{service: service, tracer: otel.Tracer(service + "-chaos")}
+	i.rules.Store(&[]*compiledRule{})
+	return i
+}
+
+// Update 用新的配置替换当前规则。
+// 有任何一条规则不合法时整份配置都不生效, 继续使用旧规则。
+func (i *Injector) Update(cfg Config) {
+	compiled := make([]*compiledRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		c, err := compile(r)
+		if err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid chaos rule, keeping previous rules: %v", err)
+			return
+		}
+		compiled = append(compiled, c)
+	}
+	i.rules.Store(&compiled)
+	i.enabled.Store(cfg.Enabled)
+	logger.Logger.Printf("✅ Chaos rules applied for %s: enabled=%v, %d rule(s)", i.service, cfg.Enabled, len(compiled))
+}
+
+// Wrap 返回一个在调用 next 之前按规则注入故障的 handler, 通常包住整个 ServeMux, 这样规则对所有路由都生效。
+// 注入的故障不单独产生 span, 而是给请求的当前 span 打上 chaos.* 属性:
+// 请求 context 中已经有 span 时直接打在它上面; 否则打在业务处理函数开始的第一个 span 上 (见 RegisterSpanProcessor)。
+// 直接返回错误或中断连接时业务处理函数不会执行, 此时为这个请求记一个服务端 span。
+func (i *Injector) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := i.match(r)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		inj := &injection{attrs: []attribute.KeyValue{
+			attribute.Bool("chaos.injected", true),
+			attribute.String("chaos.rule", rule.Name),
+			attribute.String("chaos.fault", rule.Fault.Kind()),
+		}}
+		if rule.Fault.Latency > 0 {
+			inj.attrs = append(inj.attrs, attribute.Int64("chaos.latency_ms", rule.Fault.Latency.Milliseconds()))
+		}
+		ctx := context.WithValue(r.Context(), injectionKey{}, inj)
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			inj.tag(span)
+		}
+		logger.Ctx(ctx).Printf("Injecting chaos rule %q (%s) on %s", rule.Name, rule.Fault.Kind(), r.URL.Path)
+
+		if rule.Fault.Latency > 0 {
+			select {
+			case <-time.After(rule.Fault.Latency):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !rule.Fault.Abort && rule.Fault.Status == 0 {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		span := trace.SpanFromContext(ctx)
+		if !inj.tagged.Load() {
+			remote := otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+			ctx, span = i.tracer.Start(remote, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attribute.String("http.route", r.URL.Path)))
+			defer span.End()
+		}
+		switch {
+		case rule.Fault.Abort:
+			span.SetStatus(codes.Error, "chaos: connection aborted")
+			// http.ErrAbortHandler 会让 net/http 直接断开连接且不打印堆栈
+			panic(http.ErrAbortHandler)
+		default:
+			msg := rule.Fault.Message
+			if msg == "" {
+				msg = http.StatusText(rule.Fault.Status)
+			}
+			span.SetAttributes(attribute.Int("http.status_code", rule.Fault.Status))
+			if rule.Fault.Status >= 500 {
+				err := errors.New(msg)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, fmt.Sprintf("chaos: %s", msg))
+			}
+			http.Error(w, msg, rule.Fault.Status)
+		}
+	})
+}
+
+// injectionKey 是请求 context 中记录本次注入的 key
+type injectionKey struct{}
+
+// injection 记录一次注入的属性, 只打在一个 span 上
+type injection struct {
+	attrs  []attribute.KeyValue
+	tagged atomic.Bool
+}
+
+func (in *injection) tag(span trace.Span) {
+	if in.tagged.CompareAndSwap(false, true) {
+		span.SetAttributes(in.attrs...)
+	}
+}
+
+// spanProcessor 把注入的属性打在请求中第一个开始的 span 上
+type spanProcessor struct{}
+
+func (spanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if inj, ok := parent.Value(injectionKey{}).(*injection); ok {
+		inj.tag(s)
+	}
+}
+
+func (spanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (spanProcessor) Shutdown(context.Context) error   { return nil }
+func (spanProcessor) ForceFlush(context.Context) error { return nil }
+
+// RegisterSpanProcessor 在全局 TracerProvider 上注册打标签用的 span 处理器,
+// 需要在 bootstrap 初始化 tracing 之后调用 (例如在 RegisterHandlers 中)
+func RegisterSpanProcessor() {
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		tp.RegisterSpanProcessor(spanProcessor{})
+	}
+}
+
+// match 返回第一条命中且通过概率抽样的规则
+func (i *Injector) match(r *http.Request) *compiledRule {
+	if !i.enabled.Load() {
+		return nil
+	}
+	for _, rule := range *i.rules.Load() {
+		if rule.matches(i.service, r) && rand.Float64() < rule.Probability {
+			return rule
+		}
+	}
+	return nil
+}
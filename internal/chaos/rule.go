@@ -0,0 +1,193 @@
+// internal/chaos/rule.go
+package chaos
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataID 是故障注入规则在 Nacos 中的 Data ID, 所有服务共用一份, 用 service 字段区分
+const DataID = "nexus-chaos.yaml"
+
+// Config 是 nexus-chaos.yaml 的结构
+type Config struct {
+	// Enabled 是总开关, 关闭时所有规则都不生效
+	Enabled bool   `yaml:"enabled"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule 描述一条故障注入规则。
+// query 和 headers 中的值支持以下匹配语法:
+//
+//	"abc"      等于 abc
+//	"*"        参数存在即可
+//	"!=abc"    不等于 abc
+//	">10" ">=10" "<10" "<=10"  按数值比较
+//	"~^item-"  正则匹配
+type Rule struct {
+	Name    string            `yaml:"name"`
+	Enabled *bool             `yaml:"enabled"` // 不填视为启用
+	Service string            `yaml:"service"` // 不填则对所有服务生效
+	Routes  []string          `yaml:"routes"`  // 精确路径, 以 * 结尾表示前缀匹配; 不填匹配所有路径
+	Methods []string          `yaml:"methods"`
+	Query   map[string]string `yaml:"query"`
+	Headers map[string]string `yaml:"headers"`
+	// Probability 是命中后实际注入的概率, 取值 0~1
+	Probability float64 `yaml:"probability"`
+	Fault       Fault   `yaml:"fault"`
+}
+
+// Fault 描述注入什么样的故障, 可以组合: 先注入延迟, 再返回错误或中断连接
+type Fault struct {
+	Latency time.Duration `yaml:"latency"`
+	// Status 不为 0 时直接返回该状态码, 不再调用业务处理函数
+	Status  int    `yaml:"status"`
+	Message string `yaml:"message"`
+	// Abort 为 true 时直接中断连接, 客户端看到的是连接被重置
+	Abort bool `yaml:"abort"`
+}
+
+// Kind 返回故障类型, 用于 span 属性
+func (f Fault) Kind() string {
+	switch {
+	case f.Abort:
+		return "abort"
+	case f.Status >= 500:
+		return "error"
+	case f.Status != 0:
+		return "status"
+	case f.Latency > 0:
+		return "latency"
+	}
+	return "none"
+}
+
+// matcher 判断一个参数值是否满足规则
+type matcher func(value string, present bool) bool
+
+// compiledRule 是预编译好匹配器的规则
+type compiledRule struct {
+	Rule
+	query   map[string]matcher
+	headers map[string]matcher
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	if r.Probability < 0 || r.Probability > 1 {
+		return nil, fmt.Errorf("rule %q: probability must be between 0 and 1", r.Name)
+	}
+	c := &compiledRule{Rule: r, query: map[string]matcher{}, headers: map[string]matcher{}}
+	for k, v := range r.Query {
+		m, err := compileMatcher(v)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q query %q: %w", r.Name, k, err)
+		}
+		c.query[k] = m
+	}
+	for k, v := range r.Headers {
+		m, err := compileMatcher(v)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q header %q: %w", r.Name, k, err)
+		}
+		c.headers[http.CanonicalHeaderKey(k)] = m
+	}
+	return c, nil
+}
+
+func compileMatcher(expr string) (matcher, error) {
+	switch {
+	case expr == "*":
+		return func(_ string, present bool) bool { return present }, nil
+	case strings.HasPrefix(expr, "~"):
+		re, err := regexp.Compile(expr[1:])
+		if err != nil {
+			return nil, err
+		}
+		return func(v string, present bool) bool { return present && re.MatchString(v) }, nil
+	case strings.HasPrefix(expr, "!="):
+		want := expr[2:]
+		return func(v string, _ bool) bool { return v != want }, nil
+	}
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(expr, op) {
+			continue
+		}
+		bound, err := strconv.ParseFloat(strings.TrimSpace(expr[len(op):]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric bound in %q", expr)
+		}
+		return func(v string, present bool) bool {
+			n, err := strconv.ParseFloat(v, 64)
+			if !present || err != nil {
+				return false
+			}
+			switch op {
+			case ">=":
+				return n >= bound
+			case "<=":
+				return n <= bound
+			case ">":
+				return n > bound
+			default:
+				return n < bound
+			}
+		}, nil
+	}
+	return func(v string, present bool) bool { return present && v == expr }, nil
+}
+
+// matches 判断请求是否命中规则
+func (c *compiledRule) matches(service string, r *http.Request) bool {
+	if c.Enabled != nil && !*c.Enabled {
+		return false
+	}
+	if c.Service != "" && c.Service != service {
+		return false
+	}
+	if len(c.Routes) > 0 && !matchRoute(c.Routes, r.URL.Path) {
+		return false
+	}
+	if len(c.Methods) > 0 && !containsFold(c.Methods, r.Method) {
+		return false
+	}
+	query := r.URL.Query()
+	for k, m := range c.query {
+		_, present := query[k]
+		if !m(query.Get(k), present) {
+			return false
+		}
+	}
+	for k, m := range c.headers {
+		_, present := r.Header[k]
+		if !m(r.Header.Get(k), present) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchRoute(routes []string, path string) bool {
+	for _, route := range routes {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if route == path {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+// internal/config/nacos.go
+package config
+
+import (
+	"fmt"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bootstrap 只加载 nexus-infra.yaml 和 nexus-app.yaml 两份配置,
+// 各服务自己的业务配置 (规则、费率表等) 通过这里单独拉取和监听。
+// nexus-pkg v0.1.2 的 bootstrap 没有导出它的配置客户端, 所以这里按相同的环境变量和参数另建一个,
+// 进程内所有 Watch 共用; nexus-pkg 导出配置客户端后应当改为直接复用。
+var (
+	clientOnce sync.Once
+	client     config_client.IConfigClient
+	clientErr  error
+)
+
+// Watch 拉取一份 Nacos YAML 配置并持续监听。
+// 首次加载和之后每次变更都会解析成一个新的 T 传给 onChange, 解析失败的变更会被忽略。
+// 首次拉取失败 (例如配置尚未发布) 只记录日志, 监听依然生效, 调用方应当准备好默认值。
+func Watch[T any](dataID string, onChange func(T)) error {
+	c, err := configClient()
+	if err != nil {
+		return err
+	}
+	group := getEnv("NACOS_GROUP", "DEFAULT_GROUP")
+
+	apply := func(content string) {
+		var cfg T
+		if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+			logger.Logger.Printf("❌ ERROR: Failed to unmarshal Nacos config '%s': %v", dataID, err)
+			return
+		}
+		onChange(cfg)
+	}
+
+	content, err := c.GetConfig(vo.ConfigParam{DataId: dataID, Group: group})
+	if err != nil || content == "" {
+		logger.Logger.Printf("⚠️ WARNING: Nacos config '%s' is not available yet, using defaults: %v", dataID, err)
+	} else {
+		apply(content)
+	}
+
+	err = c.ListenConfig(vo.ConfigParam{
+		DataId: dataID,
+		Group:  group,
+		OnChange: func(_, _, _, data string) {
+			logger.Logger.Printf("🔔 Nacos config changed for DataId: %s. Applying new config...", dataID)
+			apply(data)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("listen nacos config '%s': %w", dataID, err)
+	}
+	return nil
+}
+
+// configClient 懒加载一个与 bootstrap 使用相同环境变量的 Nacos 配置客户端
+func configClient() (config_client.IConfigClient, error) {
+	clientOnce.Do(func() {
+		var serverConfigs []constant.ServerConfig
+		for _, addr := range strings.Split(getEnv("NACOS_SERVER_ADDRS", "localhost:8848"), ",") {
+			host, portStr, found := strings.Cut(addr, ":")
+			port, err := strconv.ParseUint(portStr, 10, 64)
+			if !found || err != nil {
+				clientErr = fmt.Errorf("invalid nacos address format: %s", addr)
+				return
+			}
+			serverConfigs = append(serverConfigs, *constant.NewServerConfig(host, port))
+		}
+		clientConfig := *constant.NewClientConfig(
+			constant.WithNamespaceId(getEnv("NACOS_NAMESPACE", "")),
+			constant.WithTimeoutMs(5000),
+			constant.WithNotLoadCacheAtStart(true),
+			constant.WithLogDir("/tmp/nacos/log"),
+			constant.WithCacheDir("/tmp/nacos/cache"),
+			constant.WithLogLevel("warn"),
+		)
+		client, clientErr = clients.NewConfigClient(vo.NacosClientParam{
+			ClientConfig:  &clientConfig,
+			ServerConfigs: serverConfigs,
+		})
+	})
+	return client, clientErr
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,133 @@
+// internal/inventory/adjust.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 库存调整模式
+const (
+	// ModeAbsolute 把可售库存直接设置为给定数量, 通常来自盘点
+	ModeAbsolute = "absolute"
+	// ModeDelta 在当前可售库存上增减给定数量
+	ModeDelta = "delta"
+)
+
+// ReasonRestock 是补货接口写入流水时使用的原因编码
+const ReasonRestock = "restock"
+
+// ReasonCodes 是写入流水时允许使用的原因编码
+var ReasonCodes = map[string]bool{
+	ReasonRestock: true,
+	"cycle_count": true,
+	"damage":      true,
+	"return":      true,
+	"correction":  true,
+	"transfer":    true,
+}
+
+// ErrInvalidAdjustment 调整参数不合法, 例如负数的绝对库存或调整后库存为负
+var ErrInvalidAdjustment = errors.New("invalid adjustment")
+
+// Adjustment 是一行库存调整
+type Adjustment struct {
+	WarehouseID string `json:"warehouseId"`
+	ItemID      string `json:"itemId"`
+	Quantity    int64  `json:"quantity"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// AdjustmentResult 是一行调整生效后的结果
+type AdjustmentResult struct {
+	WarehouseID string `json:"warehouseId"`
+	ItemID      string `json:"itemId"`
+	Before      int64  `json:"before"`
+	After       int64  `json:"after"`
+	Delta       int64  `json:"delta"`
+	Reason      string `json:"reason"`
+}
+
+// validate 做不依赖数据库的参数校验
+func (a Adjustment) validate(mode string) error {
+	switch {
+	case a.WarehouseID == "":
+		return fmt.Errorf("%w: warehouseId is required", ErrInvalidAdjustment)
+	case a.ItemID == "":
+		return fmt.Errorf("%w: itemId is required", ErrInvalidAdjustment)
+	case !ReasonCodes[a.Reason]:
+		return fmt.Errorf("%w: unknown reason code %q", ErrInvalidAdjustment, a.Reason)
+	case mode == ModeAbsolute && a.Quantity < 0:
+		return fmt.Errorf("%w: absolute quantity must not be negative", ErrInvalidAdjustment)
+	case mode == ModeDelta && a.Quantity == 0:
+		return fmt.Errorf("%w: delta must not be zero", ErrInvalidAdjustment)
+	case mode != ModeAbsolute && mode != ModeDelta:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidAdjustment, mode)
+	}
+	return nil
+}
+
+// applyAdjustment 在事务中调整一个仓库中一个商品的可售库存, 并写入流水
+func (s *Store) applyAdjustment(ctx context.Context, tx *sql.Tx, mode, reference string, a Adjustment) (*AdjustmentResult, error) {
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM warehouse WHERE id = ?`, a.WarehouseID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("query warehouse %s: %w", a.WarehouseID, err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWarehouse, a.WarehouseID)
+	}
+
+	var before int64
+	err := tx.QueryRowContext(ctx,
+		`SELECT available FROM warehouse_stock WHERE warehouse_id = ? AND item_id = ? FOR UPDATE`,
+		a.WarehouseID, a.ItemID).Scan(&before)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("lock stock %s/%s: %w", a.WarehouseID, a.ItemID, err)
+	}
+
+	after := before + a.Quantity
+	if mode == ModeAbsolute {
+		after = a.Quantity
+	}
+	if after < 0 {
+		return nil, fmt.Errorf("%w: available would become %d", ErrInvalidAdjustment, after)
+	}
+	result := &AdjustmentResult{
+		WarehouseID: a.WarehouseID, ItemID: a.ItemID,
+		Before: before, After: after, Delta: after - before, Reason: a.Reason,
+	}
+	if result.Delta == 0 {
+		return result, nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO warehouse_stock (warehouse_id, item_id, available) VALUES (?, ?, ?)
+		 ON DUPLICATE KEY UPDATE available = VALUES(available)`,
+		a.WarehouseID, a.ItemID, after); err != nil {
+		return nil, fmt.Errorf("update stock %s/%s: %w", a.WarehouseID, a.ItemID, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO stock_ledger (warehouse_id, item_id, delta, balance_after, reason, reference) VALUES (?, ?, ?, ?, ?, ?)`,
+		a.WarehouseID, a.ItemID, result.Delta, after, a.Reason, reference); err != nil {
+		return nil, fmt.Errorf("write ledger for %s/%s: %w", a.WarehouseID, a.ItemID, err)
+	}
+	return result, nil
+}
+
+// afterAdjustments 在调整提交后检查补货点, 并用净入库数量通知到货提醒的订阅者
+func (s *Service) afterAdjustments(ctx context.Context, results []AdjustmentResult) {
+	restocked := make(map[string]int64)
+	var items []string
+	for _, r := range results {
+		if _, ok := restocked[r.ItemID]; !ok {
+			items = append(items, r.ItemID)
+		}
+		restocked[r.ItemID] += r.Delta
+	}
+	for _, itemID := range items {
+		s.stockChanged(ctx, itemID)
+		s.notifyWaitlist(ctx, itemID, restocked[itemID])
+	}
+}
@@ -0,0 +1,147 @@
+// internal/inventory/allocator.go
+package inventory
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// 内置的分配策略名称
+const (
+	StrategyNearest      = "nearest"
+	StrategyFewestSplits = "fewest_splits"
+	StrategyPriority     = "priority"
+)
+
+// Allocator 决定一次预占从哪些仓库出库。
+// 传入的 levels 只包含有可售库存的仓库, 实现方不需要再做过滤。
+type Allocator interface {
+	Allocate(quantity int64, dest Destination, levels []StockLevel) ([]Allocation, error)
+}
+
+// AllocatorFunc 让普通函数也能作为 Allocator 使用
+type AllocatorFunc func(quantity int64, dest Destination, levels []StockLevel) ([]Allocation, error)
+
+func (f AllocatorFunc) Allocate(quantity int64, dest Destination, levels []StockLevel) ([]Allocation, error) {
+	return f(quantity, dest, levels)
+}
+
+var (
+	allocatorsMu sync.RWMutex
+	allocators   = map[string]Allocator{
+		StrategyNearest:      AllocatorFunc(allocateNearest),
+		StrategyFewestSplits: AllocatorFunc(allocateFewestSplits),
+		StrategyPriority:     AllocatorFunc(allocatePriority),
+	}
+)
+
+// RegisterAllocator 注册一个自定义分配策略, 同名策略会被覆盖
+func RegisterAllocator(name string, a Allocator) {
+	allocatorsMu.Lock()
+	defer allocatorsMu.Unlock()
+	allocators[name] = a
+}
+
+// GetAllocator 按名称查找分配策略
+func GetAllocator(name string) (Allocator, error) {
+	allocatorsMu.RLock()
+	defer allocatorsMu.RUnlock()
+	a, ok := allocators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+	}
+	return a, nil
+}
+
+// allocatePriority 按仓库优先级依次扣减
+func allocatePriority(quantity int64, _ Destination, levels []StockLevel) ([]Allocation, error) {
+	sorted := sortedCopy(levels, byPriority)
+	return fillGreedy(quantity, sorted)
+}
+
+// allocateNearest 按离收货地址的距离依次扣减。
+// 没有坐标时退化为: 同区域的仓库优先, 其余按优先级。
+func allocateNearest(quantity int64, dest Destination, levels []StockLevel) ([]Allocation, error) {
+	sorted := sortedCopy(levels, func(a, b StockLevel) bool {
+		if dest.HasCoordinates() {
+			da, db := distanceKm(dest, a.Warehouse), distanceKm(dest, b.Warehouse)
+			if da != db {
+				return da < db
+			}
+		} else if dest.Region != "" {
+			ra, rb := a.Warehouse.Region == dest.Region, b.Warehouse.Region == dest.Region
+			if ra != rb {
+				return ra
+			}
+		}
+		return byPriority(a, b)
+	})
+	return fillGreedy(quantity, sorted)
+}
+
+// allocateFewestSplits 尽量少拆单。
+// 能单仓发货时选优先级最高的那个仓库; 否则按库存从多到少扣减, 这样用到的仓库数最少。
+func allocateFewestSplits(quantity int64, _ Destination, levels []StockLevel) ([]Allocation, error) {
+	sorted := sortedCopy(levels, byPriority)
+	for _, l := range sorted {
+		if l.Available >= quantity {
+			return []Allocation{{WarehouseID: l.Warehouse.ID, Region: l.Warehouse.Region, Quantity: quantity}}, nil
+		}
+	}
+	sorted = sortedCopy(levels, func(a, b StockLevel) bool {
+		if a.Available != b.Available {
+			return a.Available > b.Available
+		}
+		return byPriority(a, b)
+	})
+	return fillGreedy(quantity, sorted)
+}
+
+// fillGreedy 按给定顺序依次从每个仓库尽可能多地扣减
+func fillGreedy(quantity int64, ordered []StockLevel) ([]Allocation, error) {
+	var allocations []Allocation
+	remaining := quantity
+	for _, l := range ordered {
+		if remaining == 0 {
+			break
+		}
+		take := min(l.Available, remaining)
+		if take <= 0 {
+			continue
+		}
+		allocations = append(allocations, Allocation{WarehouseID: l.Warehouse.ID, Region: l.Warehouse.Region, Quantity: take})
+		remaining -= take
+	}
+	if remaining > 0 {
+		return nil, ErrInsufficientStock
+	}
+	return allocations, nil
+}
+
+func byPriority(a, b StockLevel) bool {
+	if a.Warehouse.Priority != b.Warehouse.Priority {
+		return a.Warehouse.Priority < b.Warehouse.Priority
+	}
+	return a.Warehouse.ID < b.Warehouse.ID
+}
+
+func sortedCopy(levels []StockLevel, less func(a, b StockLevel) bool) []StockLevel {
+	sorted := make([]StockLevel, len(levels))
+	copy(sorted, levels)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// distanceKm 使用 haversine 公式计算收货地址与仓库之间的球面距离
+func distanceKm(dest Destination, w Warehouse) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(w.Latitude - dest.Latitude)
+	dLng := toRad(w.Longitude - dest.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(dest.Latitude))*math.Cos(toRad(w.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,225 @@
+// internal/inventory/bulk.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportOptions 控制一次批量导入的行为
+type ImportOptions struct {
+	Mode string
+	// Atomic 为 true 时任意一行失败则整批回滚
+	Atomic bool
+	// Reason 是行内未指定原因时使用的默认原因编码
+	Reason string
+	// Reference 会写入每一条流水, 便于追溯导入批次
+	Reference string
+}
+
+// RowError 是某一行的校验或执行错误, Row 从 1 开始计数 (CSV 不含表头)
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportReport 是一次批量导入的结果
+type ImportReport struct {
+	Mode      string             `json:"mode"`
+	Atomic    bool               `json:"atomic"`
+	Reference string             `json:"reference"`
+	Total     int                `json:"total"`
+	Applied   int                `json:"applied"`
+	Results   []AdjustmentResult `json:"results"`
+	Errors    []RowError         `json:"errors"`
+}
+
+// ParseAdjustmentsCSV 解析带表头的 CSV, 必须包含 warehouseId, itemId, quantity 列, reason 列可选
+// 单行解析失败不会中断整个文件, 对应的行会出现在返回的 RowError 中
+func ParseAdjustmentsCSV(r io.Reader) ([]Adjustment, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read csv header: %w", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"warehouseId", "itemId", "quantity"} {
+		if _, ok := cols[required]; !ok {
+			return nil, nil, fmt.Errorf("csv header is missing column %q", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var (
+		rows   []Adjustment
+		errs   []RowError
+		rowNum int
+	)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		rowNum++
+		if err != nil {
+			errs = append(errs, RowError{Row: rowNum, Error: err.Error()})
+			rows = append(rows, Adjustment{})
+			continue
+		}
+		quantity, err := strconv.ParseInt(field(record, "quantity"), 10, 64)
+		if err != nil {
+			errs = append(errs, RowError{Row: rowNum, Error: fmt.Sprintf("invalid quantity %q", field(record, "quantity"))})
+			rows = append(rows, Adjustment{})
+			continue
+		}
+		rows = append(rows, Adjustment{
+			WarehouseID: field(record, "warehouseId"),
+			ItemID:      field(record, "itemId"),
+			Quantity:    quantity,
+			Reason:      field(record, "reason"),
+		})
+	}
+	return rows, errs, nil
+}
+
+// ParseAdjustmentsJSON 解析 JSON 数组形式的调整
+func ParseAdjustmentsJSON(r io.Reader) ([]Adjustment, error) {
+	var rows []Adjustment
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode json rows: %w", err)
+	}
+	return rows, nil
+}
+
+// Import 批量调整库存。parseErrs 是解析阶段已经发现的行错误, 这些行不会被执行。
+// 原子模式下只要有一行出错就整批回滚; 否则每行独立提交, 出错的行被跳过。
+func (s *Service) Import(ctx context.Context, rows []Adjustment, parseErrs []RowError, opts ImportOptions) (*ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ModeDelta
+	}
+	if opts.Reference == "" {
+		opts.Reference = "import-" + time.Now().Format("20060102150405")
+	}
+	report := &ImportReport{
+		Mode: opts.Mode, Atomic: opts.Atomic, Reference: opts.Reference, Total: len(rows),
+		Results: []AdjustmentResult{}, Errors: append([]RowError{}, parseErrs...),
+	}
+
+	// 先做不依赖数据库的校验, 原子模式下有任何错误就不必开启事务
+	skip := make(map[int]bool)
+	for _, e := range parseErrs {
+		skip[e.Row] = true
+	}
+	for i := range rows {
+		row := i + 1
+		if skip[row] {
+			continue
+		}
+		if rows[i].Reason == "" {
+			rows[i].Reason = opts.Reason
+		}
+		if err := rows[i].validate(opts.Mode); err != nil {
+			report.Errors = append(report.Errors, RowError{Row: row, Error: err.Error()})
+			skip[row] = true
+		}
+	}
+	if opts.Atomic && len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	if opts.Atomic {
+		var results []AdjustmentResult
+		err := s.store.WithTx(ctx, func(tx *sql.Tx) error {
+			for i, a := range rows {
+				res, err := s.store.applyAdjustment(ctx, tx, opts.Mode, opts.Reference, a)
+				if err != nil {
+					report.Errors = append(report.Errors, RowError{Row: i + 1, Error: err.Error()})
+					return err
+				}
+				results = append(results, *res)
+			}
+			return nil
+		})
+		if err != nil {
+			if len(report.Errors) > 0 {
+				return report, nil
+			}
+			return nil, err
+		}
+		report.Results = results
+	} else {
+		for i, a := range rows {
+			if skip[i+1] {
+				continue
+			}
+			var res *AdjustmentResult
+			err := s.store.WithTx(ctx, func(tx *sql.Tx) error {
+				var err error
+				res, err = s.store.applyAdjustment(ctx, tx, opts.Mode, opts.Reference, a)
+				return err
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, RowError{Row: i + 1, Error: err.Error()})
+				continue
+			}
+			report.Results = append(report.Results, *res)
+		}
+	}
+
+	report.Applied = len(report.Results)
+	s.afterAdjustments(ctx, report.Results)
+	return report, nil
+}
+
+// StockRow 是导出时的一行库存
+type StockRow struct {
+	WarehouseID string `json:"warehouseId"`
+	ItemID      string `json:"itemId"`
+	Available   int64  `json:"available"`
+	Reserved    int64  `json:"reserved"`
+}
+
+// EachStockRow 按仓库、商品顺序逐行遍历库存, 不会把整表加载到内存
+// warehouseID 为空时导出所有仓库
+func (s *Service) EachStockRow(ctx context.Context, warehouseID string, fn func(StockRow) error) error {
+	query := `SELECT warehouse_id, item_id, available, reserved FROM warehouse_stock`
+	var args []any
+	if warehouseID != "" {
+		query += ` WHERE warehouse_id = ?`
+		args = append(args, warehouseID)
+	}
+	query += ` ORDER BY warehouse_id, item_id`
+
+	rows, err := s.store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query stock for export: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row StockRow
+		if err := rows.Scan(&row.WarehouseID, &row.ItemID, &row.Available, &row.Reserved); err != nil {
+			return fmt.Errorf("scan stock row: %w", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,82 @@
+// internal/inventory/model.go
+package inventory
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInsufficientStock 所有仓库的可售库存加起来都不足以满足预占数量
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrUnknownStrategy 请求了一个未注册的分配策略
+	ErrUnknownStrategy = errors.New("unknown allocation strategy")
+	// ErrUnknownWarehouse 请求的仓库不存在
+	ErrUnknownWarehouse = errors.New("unknown warehouse")
+	// ErrInvalidQuantity 预占或补货的数量不是正整数
+	ErrInvalidQuantity = errors.New("invalid quantity")
+)
+
+// 预占记录状态, 与 stock_reservation.status 字段保持一致
+const (
+	HoldStatusHeld     = 1
+	HoldStatusReleased = 2
+)
+
+// Warehouse 是一个可以出库的仓库
+type Warehouse struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Region    string  `json:"region"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Priority  int     `json:"priority"` // 数值越小越优先
+}
+
+// StockLevel 是某个商品在某个仓库中的库存
+type StockLevel struct {
+	Warehouse Warehouse `json:"warehouse"`
+	ItemID    string    `json:"itemId"`
+	Available int64     `json:"available"`
+	Reserved  int64     `json:"reserved"`
+}
+
+// Allocation 描述从某个仓库出多少件
+type Allocation struct {
+	WarehouseID string `json:"warehouseId"`
+	Region      string `json:"region"`
+	Quantity    int64  `json:"quantity"`
+}
+
+// Destination 是收货地址中与分配相关的信息
+// 经纬度为 0 表示调用方没有提供坐标
+type Destination struct {
+	Region    string
+	Latitude  float64
+	Longitude float64
+}
+
+// HasCoordinates 判断是否提供了坐标
+func (d Destination) HasCoordinates() bool {
+	return d.Latitude != 0 || d.Longitude != 0
+}
+
+// Hold 是一条预占记录, 一次预占可能拆分到多个仓库, 每个仓库对应一条
+type Hold struct {
+	ID          int64     `json:"id"`
+	OrderID     string    `json:"orderId"`
+	ItemID      string    `json:"itemId"`
+	WarehouseID string    `json:"warehouseId"`
+	Quantity    int64     `json:"quantity"`
+	Status      int       `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Reservation 是一次预占的结果, 会原样返回给调用方
+type Reservation struct {
+	OrderID     string       `json:"orderId"`
+	ItemID      string       `json:"itemId"`
+	Quantity    int64        `json:"quantity"`
+	Strategy    string       `json:"strategy"`
+	Allocations []Allocation `json:"allocations"`
+}
@@ -0,0 +1,50 @@
+// internal/inventory/notifier.go
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/mq"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// NotificationTopic 是 notification-service 消费的主题
+const NotificationTopic = "notifications"
+
+// NotificationEvent 与 notification-service 中的事件结构保持一致
+type NotificationEvent struct {
+	UserID      string `json:"userId"`
+	Message     string `json:"message"`
+	PromotionID string `json:"promotion_id,omitempty"`
+}
+
+// Notifier 把通知投递到通知管道
+type Notifier interface {
+	Notify(ctx context.Context, key string, event NotificationEvent) error
+}
+
+// KafkaNotifier 把通知写入 Kafka 的 notifications 主题
+type KafkaNotifier struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaNotifier 创建一个写入 notifications 主题的 Notifier
+func NewKafkaNotifier(brokers []string) *KafkaNotifier {
+	return &KafkaNotifier{writer: mq.NewKafkaWriter(brokers, NotificationTopic)}
+}
+
+// Notify 序列化事件并注入追踪上下文后发送
+func (n *KafkaNotifier) Notify(ctx context.Context, key string, event NotificationEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	return mq.ProduceMessage(ctx, n.writer, []byte(key), value)
+}
+
+// Close 关闭底层的 Kafka writer
+func (n *KafkaNotifier) Close() error {
+	return n.writer.Close()
+}
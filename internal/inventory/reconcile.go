@@ -0,0 +1,287 @@
+// internal/inventory/reconcile.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrOrderNotFound 订单服务中不存在该订单
+var ErrOrderNotFound = errors.New("order not found")
+
+// 对账发现的差异原因
+const (
+	ReasonOrderNotFound   = "order_not_found"
+	ReasonOrderTerminated = "order_terminated"
+	ReasonOrderCompleted  = "order_completed"
+	ReasonOrderUnknown    = "order_state_unknown"
+)
+
+// 对账对每条差异采取的动作
+const (
+	ActionReleased     = "released"
+	ActionWouldRelease = "would_release"
+	ActionNone         = "none"
+)
+
+var (
+	reconcileRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_reconcile_runs_total",
+		Help: "Number of inventory reconciliation runs.",
+	}, []string{"mode"})
+	reconcileDiscrepancies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_reconcile_discrepancies_total",
+		Help: "Number of hold discrepancies found by reconciliation.",
+	}, []string{"reason", "action"})
+	reconcileReleasedUnits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "inventory_reconcile_released_units_total",
+		Help: "Number of stock units returned to availability by reconciliation.",
+	})
+)
+
+// 终态订单的状态值, 这些订单上的预占应当已经被释放
+var terminatedOrderStatuses = map[string]bool{
+	"cancelled": true, "canceled": true, "failed": true, "compensated": true, "rejected": true, "expired": true,
+}
+
+// 已完成订单的状态值, 这些订单上的预占应当已经出库, 对账只报告不释放
+var completedOrderStatuses = map[string]bool{
+	"completed": true, "paid": true, "shipped": true, "delivered": true,
+}
+
+// OrderStateSource 查询订单的当前状态
+type OrderStateSource interface {
+	OrderStatus(ctx context.Context, orderID string) (string, error)
+}
+
+// HTTPOrderStateSource 通过 order-service 的 GET /order_status?orderID= 接口查询订单状态,
+// 响应为 {"status": "..."}; 订单不存在时返回 JSON 格式的 404 (见 cmd/order-v2 和 order_state 表)
+type HTTPOrderStateSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPOrderStateSource 创建一个查询 order-service 的 OrderStateSource
+func NewHTTPOrderStateSource(baseURL string) *HTTPOrderStateSource {
+	return &HTTPOrderStateSource{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+// OrderStatus 返回小写的订单状态, 订单不存在时返回 ErrOrderNotFound
+func (s *HTTPOrderStateSource) OrderStatus(ctx context.Context, orderID string) (string, error) {
+	ctx, span := otel.Tracer("inventory-reconciler").Start(ctx, "call-order-service", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	reqURL := s.baseURL + "/order_status?" + url.Values{"orderID": {orderID}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	span.SetAttributes(attribute.String("http.url", reqURL), attribute.String("http.method", http.MethodGet))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// 只有订单服务明确返回 JSON 才认为订单不存在;
+		// 路由未注册时 ServeMux 返回的是纯文本 404, 不能据此释放预占
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			return "", ErrOrderNotFound
+		}
+		err := fmt.Errorf("order-service returned status %s without an order payload", resp.Status)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	default:
+		err := fmt.Errorf("order-service returned status %s", resp.Status)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode order status: %w", err)
+	}
+	return strings.ToLower(body.Status), nil
+}
+
+// Discrepancy 是对账发现的一条异常预占
+type Discrepancy struct {
+	Hold        Hold   `json:"hold"`
+	OrderStatus string `json:"orderStatus,omitempty"`
+	Reason      string `json:"reason"`
+	Action      string `json:"action"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ReconcileReport 是一次对账的结果
+type ReconcileReport struct {
+	StartedAt     time.Time     `json:"startedAt"`
+	FinishedAt    time.Time     `json:"finishedAt"`
+	DryRun        bool          `json:"dryRun"`
+	Scanned       int           `json:"scanned"`
+	Released      int           `json:"released"`
+	ReleasedUnits int64         `json:"releasedUnits"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// Reconciler 交叉核对预占记录与订单状态, 释放孤儿预占
+type Reconciler struct {
+	svc    *Service
+	orders OrderStateSource
+	// grace 是预占的最小存活时间, 比它更新的预占可能属于仍在进行中的 Saga, 不参与对账
+	grace time.Duration
+	// releaseNotFound 为 true 时订单不存在的预占也会被释放。只有订单来源记录了全部预占所属的订单时才能打开,
+	// 否则由其他订单流程 (例如 v1 的 Saga) 创建的正常预占会被误放
+	releaseNotFound bool
+
+	mu   sync.Mutex
+	last *ReconcileReport
+}
+
+// NewReconciler 创建一个对账器, releaseNotFound 见 Reconciler
+func NewReconciler(svc *Service, orders OrderStateSource, grace time.Duration, releaseNotFound bool) *Reconciler {
+	return &Reconciler{svc: svc, orders: orders, grace: grace, releaseNotFound: releaseNotFound}
+}
+
+// LastReport 返回最近一次对账的结果, 还没有运行过时返回 nil
+func (r *Reconciler) LastReport() *ReconcileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Run 执行一次对账。dryRun 为 true 时只报告不释放。
+func (r *Reconciler) Run(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	mode := "apply"
+	if dryRun {
+		mode = "dry_run"
+	}
+	reconcileRuns.WithLabelValues(mode).Inc()
+
+	report := &ReconcileReport{StartedAt: time.Now(), DryRun: dryRun, Discrepancies: []Discrepancy{}}
+	holds, err := r.svc.store.HeldHoldsBefore(ctx, report.StartedAt.Add(-r.grace))
+	if err != nil {
+		return nil, err
+	}
+	report.Scanned = len(holds)
+
+	// 同一订单可能有多条预占, 订单状态只查一次
+	statuses := make(map[string]string)
+	lookupErrs := make(map[string]error)
+	for _, h := range holds {
+		status, seen := statuses[h.OrderID]
+		lookupErr := lookupErrs[h.OrderID]
+		if !seen {
+			status, lookupErr = r.orders.OrderStatus(ctx, h.OrderID)
+			statuses[h.OrderID], lookupErrs[h.OrderID] = status, lookupErr
+		}
+
+		d := Discrepancy{Hold: h, OrderStatus: status, Action: ActionNone}
+		orphaned := false
+		switch {
+		case errors.Is(lookupErr, ErrOrderNotFound):
+			// 订单来源不是全部预占的记录系统时, 查不到订单不代表预占是孤儿, 只报告不释放
+			d.Reason, orphaned = ReasonOrderNotFound, r.releaseNotFound
+		case lookupErr != nil:
+			d.Reason, d.Error = ReasonOrderUnknown, lookupErr.Error()
+		case terminatedOrderStatuses[status]:
+			d.Reason, orphaned = ReasonOrderTerminated, true
+		case completedOrderStatuses[status]:
+			d.Reason = ReasonOrderCompleted
+		default:
+			// 订单仍在进行中, 预占是正常的
+			continue
+		}
+
+		if orphaned {
+			if dryRun {
+				d.Action = ActionWouldRelease
+			} else if released, err := r.svc.releaseHoldByID(ctx, h); err != nil {
+				d.Error = err.Error()
+				logger.Ctx(ctx).Error().Err(err).Int64("hold", h.ID).Msg("Failed to release orphaned hold")
+			} else if released {
+				d.Action = ActionReleased
+				report.Released++
+				report.ReleasedUnits += h.Quantity
+				reconcileReleasedUnits.Add(float64(h.Quantity))
+			}
+		}
+		reconcileDiscrepancies.WithLabelValues(d.Reason, d.Action).Inc()
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	report.FinishedAt = time.Now()
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+
+	logger.Ctx(ctx).Printf("Reconciliation finished (dryRun=%v): scanned %d hold(s), %d discrepancy(ies), released %d",
+		dryRun, report.Scanned, len(report.Discrepancies), report.Released)
+	return report, nil
+}
+
+// HeldHoldsBefore 返回在给定时间之前创建、仍处于预占中的记录
+func (s *Store) HeldHoldsBefore(ctx context.Context, before time.Time) ([]Hold, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, order_id, item_id, warehouse_id, quantity, status, created_at
+		 FROM stock_reservation WHERE status = ? AND created_at < ? ORDER BY id`,
+		HoldStatusHeld, before)
+	if err != nil {
+		return nil, fmt.Errorf("query held holds: %w", err)
+	}
+	defer rows.Close()
+	return scanHolds(rows)
+}
+
+// releaseHoldByID 释放单条预占记录; 记录已被其他流程释放时返回 false
+func (s *Service) releaseHoldByID(ctx context.Context, h Hold) (bool, error) {
+	released := false
+	err := s.store.WithTx(ctx, func(tx *sql.Tx) error {
+		var status int
+		if err := tx.QueryRowContext(ctx,
+			`SELECT status FROM stock_reservation WHERE id = ? FOR UPDATE`, h.ID).Scan(&status); err != nil {
+			return fmt.Errorf("lock hold %d: %w", h.ID, err)
+		}
+		if status != HoldStatusHeld {
+			return nil
+		}
+		released = true
+		return s.store.releaseHold(ctx, tx, h)
+	})
+	if err != nil || !released {
+		return false, err
+	}
+	s.stockChanged(ctx, h.ItemID)
+	return true, nil
+}
@@ -0,0 +1,185 @@
+// internal/inventory/service.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Options 是库存业务服务的可选配置
+type Options struct {
+	// DefaultStrategy 是请求未指定分配策略时使用的策略, 为空时使用 fewest_splits
+	DefaultStrategy string
+	// Notifier 用于发送低库存告警, 为 nil 时不告警
+	Notifier Notifier
+	// AlertCooldown 是同一商品两次低库存告警之间的最小间隔
+	AlertCooldown time.Duration
+	// AlertRecipient 是低库存告警的接收人 (对应 NotificationEvent.UserID)
+	AlertRecipient string
+	// StockPublisher 用于发布库存水位变化, 为 nil 时不发布
+	StockPublisher StockPublisher
+}
+
+// Service 是库存服务的核心业务逻辑, HTTP 层只负责参数解析和追踪
+type Service struct {
+	store           *Store
+	defaultStrategy string
+	notifier        Notifier
+	alertCooldown   time.Duration
+	alertRecipient  string
+	stockPublisher  StockPublisher
+}
+
+// NewService 创建库存业务服务
+func NewService(store *Store, opts Options) *Service {
+	if opts.DefaultStrategy == "" {
+		opts.DefaultStrategy = StrategyFewestSplits
+	}
+	return &Service{
+		store:           store,
+		defaultStrategy: opts.DefaultStrategy,
+		notifier:        opts.Notifier,
+		alertCooldown:   opts.AlertCooldown,
+		alertRecipient:  opts.AlertRecipient,
+		stockPublisher:  opts.StockPublisher,
+	}
+}
+
+// Reserve 按分配策略从各仓库预占库存。
+// 调用方需要保证同一商品的预占是串行的 (inventory-service 使用 ZooKeeper 分布式锁)。
+func (s *Service) Reserve(ctx context.Context, orderID, itemID string, quantity int64, strategy string, dest Destination) (*Reservation, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+	if strategy == "" {
+		strategy = s.defaultStrategy
+	}
+	allocator, err := GetAllocator(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{OrderID: orderID, ItemID: itemID, Quantity: quantity, Strategy: strategy}
+	err = s.store.WithTx(ctx, func(tx *sql.Tx) error {
+		levels, err := s.store.lockStockLevels(ctx, tx, itemID)
+		if err != nil {
+			return err
+		}
+		allocations, err := allocator.Allocate(quantity, dest, withAvailable(levels))
+		if err != nil {
+			return err
+		}
+		for _, a := range allocations {
+			if err := s.store.moveToReserved(ctx, tx, orderID, itemID, a); err != nil {
+				return err
+			}
+		}
+		reservation.Allocations = allocations
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.stockChanged(ctx, itemID)
+	return reservation, nil
+}
+
+// Release 释放某个订单对某个商品的全部预占, 重复调用是安全的
+func (s *Service) Release(ctx context.Context, orderID, itemID string) ([]Hold, error) {
+	var released []Hold
+	err := s.store.WithTx(ctx, func(tx *sql.Tx) error {
+		holds, err := s.store.lockHeldHolds(ctx, tx, orderID, itemID)
+		if err != nil {
+			return err
+		}
+		for _, h := range holds {
+			if err := s.store.releaseHold(ctx, tx, h); err != nil {
+				return err
+			}
+		}
+		released = holds
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(released) > 0 {
+		s.stockChanged(ctx, itemID)
+	}
+	return released, nil
+}
+
+// Restock 给某个仓库补货并写入流水, reference 是调用方的单号 (例如采购入库单), 为空时按时间生成。
+// 补货后检查补货点并按 FIFO 通知到货提醒的订阅者。
+func (s *Service) Restock(ctx context.Context, warehouseID, itemID string, quantity int64, reference string) error {
+	if quantity <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+	if reference == "" {
+		reference = "restock-" + time.Now().Format("20060102150405")
+	}
+	a := Adjustment{WarehouseID: warehouseID, ItemID: itemID, Quantity: quantity, Reason: ReasonRestock}
+	var result *AdjustmentResult
+	err := s.store.WithTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		result, err = s.store.applyAdjustment(ctx, tx, ModeDelta, reference, a)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.afterAdjustments(ctx, []AdjustmentResult{*result})
+	return nil
+}
+
+// Subscribe 订阅某个商品的到货提醒
+func (s *Service) Subscribe(ctx context.Context, itemID, userID string, quantity int64) (*Subscription, error) {
+	if quantity <= 0 {
+		quantity = 1
+	}
+	return s.store.Subscribe(ctx, itemID, userID, quantity)
+}
+
+// Unsubscribe 取消某个商品的到货提醒
+func (s *Service) Unsubscribe(ctx context.Context, itemID, userID string) (bool, error) {
+	return s.store.Unsubscribe(ctx, itemID, userID)
+}
+
+// Waitlist 返回某个商品等待中的订阅, 按先来后到排序
+func (s *Service) Waitlist(ctx context.Context, itemID string) ([]Subscription, error) {
+	return s.store.WaitingSubscriptions(ctx, itemID)
+}
+
+// GetThreshold 查询某个商品的补货点
+func (s *Service) GetThreshold(ctx context.Context, itemID string) (*Threshold, error) {
+	return s.store.GetThreshold(ctx, itemID)
+}
+
+// SetThreshold 设置某个商品的补货点, 并立即按当前库存检查一次
+func (s *Service) SetThreshold(ctx context.Context, itemID string, reorderPoint int64) error {
+	if reorderPoint < 0 {
+		return fmt.Errorf("invalid reorder point %d", reorderPoint)
+	}
+	if err := s.store.UpsertThreshold(ctx, itemID, reorderPoint); err != nil {
+		return err
+	}
+	s.evaluateThreshold(ctx, itemID)
+	return nil
+}
+
+// StockLevels 返回某个商品在各仓库的库存
+func (s *Service) StockLevels(ctx context.Context, itemID string) ([]StockLevel, error) {
+	return s.store.StockLevels(ctx, itemID)
+}
+
+func withAvailable(levels []StockLevel) []StockLevel {
+	var out []StockLevel
+	for _, l := range levels {
+		if l.Available > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+// internal/inventory/stock_events.go
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"github.com/wangyingjie930/nexus-pkg/mq"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// StockLevelTopic 是商品库存水位变化的主题, pricing-service 据此做动态定价
+const StockLevelTopic = "stock-levels"
+
+// StockLevelEvent 是一个商品在所有启用仓库中的库存快照, 以商品 ID 作为消息 key
+type StockLevelEvent struct {
+	ItemID     string    `json:"itemId"`
+	Available  int64     `json:"available"`
+	Reserved   int64     `json:"reserved"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// StockPublisher 发布库存水位变化
+type StockPublisher interface {
+	PublishStockLevel(ctx context.Context, event StockLevelEvent) error
+}
+
+// KafkaStockPublisher 把库存水位写入 Kafka 的 stock-levels 主题
+type KafkaStockPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaStockPublisher 创建一个写入 stock-levels 主题的 StockPublisher
+func NewKafkaStockPublisher(brokers []string) *KafkaStockPublisher {
+	return &KafkaStockPublisher{writer: mq.NewKafkaWriter(brokers, StockLevelTopic)}
+}
+
+// PublishStockLevel 序列化事件并注入追踪上下文后发送
+func (p *KafkaStockPublisher) PublishStockLevel(ctx context.Context, event StockLevelEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal stock level: %w", err)
+	}
+	return mq.ProduceMessage(ctx, p.writer, []byte(event.ItemID), value)
+}
+
+// Close 关闭底层的 Kafka writer
+func (p *KafkaStockPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// stockChanged 在库存提交后执行所有的后续动作: 检查补货点, 发布最新的库存水位
+func (s *Service) stockChanged(ctx context.Context, itemID string) {
+	s.evaluateThreshold(ctx, itemID)
+	s.publishStockLevel(ctx, itemID)
+}
+
+// publishStockLevel 汇总各仓库的库存并发布。发布失败只记录日志, 下一次变化会带上最新的快照。
+func (s *Service) publishStockLevel(ctx context.Context, itemID string) {
+	if s.stockPublisher == nil {
+		return
+	}
+	levels, err := s.store.StockLevels(ctx, itemID)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to load stock levels for publishing")
+		return
+	}
+	event := StockLevelEvent{ItemID: itemID, OccurredAt: time.Now()}
+	for _, l := range levels {
+		event.Available += l.Available
+		event.Reserved += l.Reserved
+	}
+	if err := s.stockPublisher.PublishStockLevel(ctx, event); err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to publish stock level")
+	}
+}
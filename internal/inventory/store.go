@@ -0,0 +1,140 @@
+// internal/inventory/store.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// queryer 同时被 *sql.DB 和 *sql.Tx 实现, 方便在事务内外复用同一套查询
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Store 封装了分仓库存相关的所有 SQL
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore 创建一个基于 MySQL 的库存存储
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx 在一个事务中执行 fn, fn 返回错误时回滚
+func (s *Store) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+const stockLevelColumns = `w.id, w.name, w.region, w.latitude, w.longitude, w.priority, s.item_id, s.available, s.reserved`
+
+// StockLevels 返回某个商品在所有启用仓库中的库存
+func (s *Store) StockLevels(ctx context.Context, itemID string) ([]StockLevel, error) {
+	return s.stockLevels(ctx, s.db, itemID, false)
+}
+
+// lockStockLevels 在事务中查询并锁定某个商品在各仓库的库存行
+func (s *Store) lockStockLevels(ctx context.Context, tx *sql.Tx, itemID string) ([]StockLevel, error) {
+	return s.stockLevels(ctx, tx, itemID, true)
+}
+
+func (s *Store) stockLevels(ctx context.Context, q queryer, itemID string, forUpdate bool) ([]StockLevel, error) {
+	query := `SELECT ` + stockLevelColumns + `
+		FROM warehouse_stock s JOIN warehouse w ON w.id = s.warehouse_id
+		WHERE s.item_id = ? AND w.status = 1
+		ORDER BY w.priority, w.id`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.QueryContext(ctx, query, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("query stock levels for %s: %w", itemID, err)
+	}
+	defer rows.Close()
+
+	var levels []StockLevel
+	for rows.Next() {
+		var l StockLevel
+		if err := rows.Scan(&l.Warehouse.ID, &l.Warehouse.Name, &l.Warehouse.Region, &l.Warehouse.Latitude,
+			&l.Warehouse.Longitude, &l.Warehouse.Priority, &l.ItemID, &l.Available, &l.Reserved); err != nil {
+			return nil, fmt.Errorf("scan stock level: %w", err)
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// moveToReserved 把可售库存转为预占库存, 并写入预占记录
+func (s *Store) moveToReserved(ctx context.Context, tx *sql.Tx, orderID, itemID string, a Allocation) error {
+	res, err := tx.ExecContext(ctx,
+		`UPDATE warehouse_stock SET available = available - ?, reserved = reserved + ?
+		 WHERE warehouse_id = ? AND item_id = ? AND available >= ?`,
+		a.Quantity, a.Quantity, a.WarehouseID, itemID, a.Quantity)
+	if err != nil {
+		return fmt.Errorf("deduct stock in %s: %w", a.WarehouseID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("deduct stock in %s: %w", a.WarehouseID, ErrInsufficientStock)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO stock_reservation (order_id, item_id, warehouse_id, quantity, status) VALUES (?, ?, ?, ?, ?)`,
+		orderID, itemID, a.WarehouseID, a.Quantity, HoldStatusHeld)
+	if err != nil {
+		return fmt.Errorf("insert reservation: %w", err)
+	}
+	return nil
+}
+
+// lockHeldHolds 在事务中查询并锁定某个订单对某个商品仍处于预占中的记录
+func (s *Store) lockHeldHolds(ctx context.Context, tx *sql.Tx, orderID, itemID string) ([]Hold, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, order_id, item_id, warehouse_id, quantity, status, created_at
+		 FROM stock_reservation WHERE order_id = ? AND item_id = ? AND status = ? FOR UPDATE`,
+		orderID, itemID, HoldStatusHeld)
+	if err != nil {
+		return nil, fmt.Errorf("query holds for order %s: %w", orderID, err)
+	}
+	defer rows.Close()
+	return scanHolds(rows)
+}
+
+// releaseHold 把一条预占记录的数量退回可售库存
+func (s *Store) releaseHold(ctx context.Context, tx *sql.Tx, h Hold) error {
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE warehouse_stock SET available = available + ?, reserved = reserved - ?
+		 WHERE warehouse_id = ? AND item_id = ?`,
+		h.Quantity, h.Quantity, h.WarehouseID, h.ItemID); err != nil {
+		return fmt.Errorf("restore stock in %s: %w", h.WarehouseID, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE stock_reservation SET status = ? WHERE id = ?`, HoldStatusReleased, h.ID); err != nil {
+		return fmt.Errorf("mark hold %d released: %w", h.ID, err)
+	}
+	return nil
+}
+
+func scanHolds(rows *sql.Rows) ([]Hold, error) {
+	var holds []Hold
+	for rows.Next() {
+		var h Hold
+		if err := rows.Scan(&h.ID, &h.OrderID, &h.ItemID, &h.WarehouseID, &h.Quantity, &h.Status, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan hold: %w", err)
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
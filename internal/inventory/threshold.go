@@ -0,0 +1,174 @@
+// internal/inventory/threshold.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 告警状态, 与 stock_threshold.alert_state 字段保持一致
+const (
+	alertStateArmed   = 0
+	alertStateAlerted = 1
+)
+
+// Threshold 是某个商品的补货点配置
+type Threshold struct {
+	ItemID        string     `json:"itemId"`
+	ReorderPoint  int64      `json:"reorderPoint"`
+	Alerted       bool       `json:"alerted"`
+	LastAlertedAt *time.Time `json:"lastAlertedAt,omitempty"`
+}
+
+// GetThreshold 查询某个商品的补货点, 未配置时返回 nil
+func (s *Store) GetThreshold(ctx context.Context, itemID string) (*Threshold, error) {
+	var (
+		t           Threshold
+		state       int
+		lastAlerted sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT item_id, reorder_point, alert_state, last_alerted_at FROM stock_threshold WHERE item_id = ?`, itemID).
+		Scan(&t.ItemID, &t.ReorderPoint, &state, &lastAlerted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query threshold for %s: %w", itemID, err)
+	}
+	t.Alerted = state == alertStateAlerted
+	if lastAlerted.Valid {
+		t.LastAlertedAt = &lastAlerted.Time
+	}
+	return &t, nil
+}
+
+// UpsertThreshold 设置某个商品的补货点, 修改补货点会重新进入待触发状态
+func (s *Store) UpsertThreshold(ctx context.Context, itemID string, reorderPoint int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO stock_threshold (item_id, reorder_point, alert_state) VALUES (?, ?, ?)
+		 ON DUPLICATE KEY UPDATE reorder_point = VALUES(reorder_point), alert_state = VALUES(alert_state)`,
+		itemID, reorderPoint, alertStateArmed)
+	if err != nil {
+		return fmt.Errorf("upsert threshold for %s: %w", itemID, err)
+	}
+	return nil
+}
+
+// TotalAvailable 返回某个商品在所有启用仓库中的可售库存总和
+func (s *Store) TotalAvailable(ctx context.Context, itemID string) (int64, error) {
+	var total sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT SUM(s.available) FROM warehouse_stock s JOIN warehouse w ON w.id = s.warehouse_id
+		 WHERE s.item_id = ? AND w.status = 1`, itemID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("sum stock for %s: %w", itemID, err)
+	}
+	return total.Int64, nil
+}
+
+// tryMarkAlerted 原子地把阈值从待触发切换为已告警, 告警时间记为 now。
+// 只有处于待触发状态且已过冷却期时才会成功, 这保证了并发和抖动下每次下穿只告警一次。
+func (s *Store) tryMarkAlerted(ctx context.Context, itemID string, now, cooldownStart time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE stock_threshold SET alert_state = ?, last_alerted_at = ?
+		 WHERE item_id = ? AND alert_state = ? AND (last_alerted_at IS NULL OR last_alerted_at < ?)`,
+		alertStateAlerted, now, itemID, alertStateArmed, cooldownStart)
+	if err != nil {
+		return false, fmt.Errorf("mark threshold alerted for %s: %w", itemID, err)
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// unmarkAlerted 告警发送失败时撤销 tryMarkAlerted, 恢复待触发状态和上一次的告警时间,
+// 这样下一次库存变化会重新尝试告警。只撤销 markedAt 这一次标记, 不会覆盖之后的状态变化。
+func (s *Store) unmarkAlerted(ctx context.Context, itemID string, markedAt time.Time, previous *time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE stock_threshold SET alert_state = ?, last_alerted_at = ?
+		 WHERE item_id = ? AND alert_state = ? AND last_alerted_at = ?`,
+		alertStateArmed, previous, itemID, alertStateAlerted, markedAt)
+	if err != nil {
+		return fmt.Errorf("unmark threshold alerted for %s: %w", itemID, err)
+	}
+	return nil
+}
+
+// rearm 库存回到补货点及以上时, 让阈值重新进入待触发状态
+func (s *Store) rearm(ctx context.Context, itemID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE stock_threshold SET alert_state = ? WHERE item_id = ? AND alert_state = ?`,
+		alertStateArmed, itemID, alertStateAlerted)
+	if err != nil {
+		return fmt.Errorf("rearm threshold for %s: %w", itemID, err)
+	}
+	return nil
+}
+
+// evaluateThreshold 在库存变化后检查是否下穿补货点。
+// 去抖分两层: 告警后必须先回到补货点以上才会重新待触发, 并且两次告警之间至少间隔一个冷却期。
+// 告警失败只记录日志, 不影响库存操作本身。
+func (s *Service) evaluateThreshold(ctx context.Context, itemID string) {
+	if s.notifier == nil {
+		return
+	}
+	threshold, err := s.store.GetThreshold(ctx, itemID)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to load stock threshold")
+		return
+	}
+	if threshold == nil {
+		return
+	}
+	available, err := s.store.TotalAvailable(ctx, itemID)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to sum stock for threshold check")
+		return
+	}
+
+	if available >= threshold.ReorderPoint {
+		if threshold.Alerted {
+			if err := s.store.rearm(ctx, itemID); err != nil {
+				logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to rearm stock threshold")
+			}
+		}
+		return
+	}
+
+	// 先原子地占住这次告警, 保证并发下只有一个请求发送; 发送失败时撤销, 留给下一次库存变化重试。
+	// 告警时间截断到秒, 与 last_alerted_at 在 MySQL 中的精度一致, 撤销时才能按它匹配。
+	now := time.Now().Truncate(time.Second)
+	fired, err := s.store.tryMarkAlerted(ctx, itemID, now, now.Add(-s.alertCooldown))
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to update stock threshold")
+		return
+	}
+	if !fired {
+		return
+	}
+
+	event := NotificationEvent{
+		UserID:  s.alertRecipient,
+		Message: fmt.Sprintf("Low stock: item %s has %d unit(s) left, below reorder point %d", itemID, available, threshold.ReorderPoint),
+	}
+	if err := s.notifier.Notify(ctx, itemID, event); err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to publish low stock alert")
+		if err := s.store.unmarkAlerted(ctx, itemID, now, threshold.LastAlertedAt); err != nil {
+			logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to reset stock threshold after failed alert")
+		}
+		return
+	}
+	trace.SpanFromContext(ctx).AddEvent("Low stock alert published", trace.WithAttributes(
+		attribute.String("item.id", itemID),
+		attribute.Int64("stock.available", available),
+		attribute.Int64("stock.reorder_point", threshold.ReorderPoint),
+	))
+	logger.Ctx(ctx).Printf("Low stock alert published for item %s (%d < %d)", itemID, available, threshold.ReorderPoint)
+}
@@ -0,0 +1,190 @@
+// internal/inventory/waitlist.go
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 订阅状态, 与 stock_waitlist.status 字段保持一致
+const (
+	WaitlistStatusWaiting   = 1
+	WaitlistStatusNotified  = 2
+	WaitlistStatusCancelled = 3
+)
+
+// Subscription 是一条到货提醒订阅
+type Subscription struct {
+	ID        int64     `json:"id"`
+	ItemID    string    `json:"itemId"`
+	UserID    string    `json:"userId"`
+	Quantity  int64     `json:"quantity"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Subscribe 为用户订阅某个商品的到货提醒。
+// 同一用户对同一商品只有一条订阅 (uk_item_user): 等待中时重复订阅只更新数量, 不改变排队位置;
+// 已通知或已取消的旧订阅会被替换为一条新的订阅, 排到队尾。
+func (s *Store) Subscribe(ctx context.Context, itemID, userID string, quantity int64) (*Subscription, error) {
+	sub := &Subscription{ItemID: itemID, UserID: userID, Quantity: quantity, Status: WaitlistStatusWaiting}
+	err := s.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM stock_waitlist WHERE item_id = ? AND user_id = ? AND status <> ?`,
+			itemID, userID, WaitlistStatusWaiting); err != nil {
+			return fmt.Errorf("delete finished subscription: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO stock_waitlist (item_id, user_id, quantity, status) VALUES (?, ?, ?, ?)
+			 ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)`,
+			itemID, userID, quantity, WaitlistStatusWaiting); err != nil {
+			return fmt.Errorf("upsert subscription: %w", err)
+		}
+		err := tx.QueryRowContext(ctx,
+			`SELECT id, created_at FROM stock_waitlist WHERE item_id = ? AND user_id = ?`,
+			itemID, userID).Scan(&sub.ID, &sub.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("query subscription: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe 取消用户对某个商品的等待中订阅
+func (s *Store) Unsubscribe(ctx context.Context, itemID, userID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE stock_waitlist SET status = ? WHERE item_id = ? AND user_id = ? AND status = ?`,
+		WaitlistStatusCancelled, itemID, userID, WaitlistStatusWaiting)
+	if err != nil {
+		return false, fmt.Errorf("cancel subscription: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// WaitingSubscriptions 按先来后到的顺序返回某个商品所有等待中的订阅
+func (s *Store) WaitingSubscriptions(ctx context.Context, itemID string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, item_id, user_id, quantity, status, created_at FROM stock_waitlist
+		 WHERE item_id = ? AND status = ? ORDER BY id`, itemID, WaitlistStatusWaiting)
+	if err != nil {
+		return nil, fmt.Errorf("query waitlist for %s: %w", itemID, err)
+	}
+	defer rows.Close()
+	return scanSubscriptions(rows)
+}
+
+// claimSubscribers 按 FIFO 顺序领取一批等待中的订阅并标记为已通知, 累计数量不超过 budget。
+// 队头订阅放不下时就停止, 不允许后来者插队。整批在一个事务中锁住等待中的订阅,
+// 并发的补货不会领取到同一个用户; 事务内不做任何外部调用, 通知在提交之后再发出。
+func (s *Store) claimSubscribers(ctx context.Context, itemID string, budget int64) ([]Subscription, error) {
+	var claimed []Subscription
+	err := s.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, item_id, user_id, quantity, status, created_at FROM stock_waitlist
+			 WHERE item_id = ? AND status = ? ORDER BY id FOR UPDATE`, itemID, WaitlistStatusWaiting)
+		if err != nil {
+			return fmt.Errorf("query waitlist for %s: %w", itemID, err)
+		}
+		waiting, err := scanSubscriptions(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		remaining := budget
+		now := time.Now()
+		for _, sub := range waiting {
+			if sub.Quantity > remaining {
+				break
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE stock_waitlist SET status = ?, notified_at = ? WHERE id = ?`,
+				WaitlistStatusNotified, now, sub.ID); err != nil {
+				return fmt.Errorf("mark subscription %d notified: %w", sub.ID, err)
+			}
+			remaining -= sub.Quantity
+			sub.Status = WaitlistStatusNotified
+			claimed = append(claimed, sub)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// releaseSubscribers 把领取后未能通知的订阅退回等待状态, 保留原来的排队位置 (id 不变)。
+// 只退回仍处于已通知状态的订阅, 期间被用户取消或重新订阅的不受影响。
+func (s *Store) releaseSubscribers(ctx context.Context, subs []Subscription) error {
+	for _, sub := range subs {
+		if _, err := s.db.ExecContext(ctx,
+			`UPDATE stock_waitlist SET status = ?, notified_at = NULL WHERE id = ? AND status = ?`,
+			WaitlistStatusWaiting, sub.ID, WaitlistStatusNotified); err != nil {
+			return fmt.Errorf("release subscription %d: %w", sub.ID, err)
+		}
+	}
+	return nil
+}
+
+func scanSubscriptions(rows *sql.Rows) ([]Subscription, error) {
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.ID, &sub.ItemID, &sub.UserID, &sub.Quantity, &sub.Status, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan subscription: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// notifyWaitlist 在补货后按 FIFO 通知等待中的用户, 本批通知的总数量不超过补货数量。
+// 先在事务中领取订阅, 提交后再逐条发送通知; 某条通知失败时, 它和排在后面的订阅都退回等待状态,
+// 下一次补货时重新通知。失败只记录日志, 不影响补货本身。
+func (s *Service) notifyWaitlist(ctx context.Context, itemID string, restocked int64) {
+	if s.notifier == nil || restocked <= 0 {
+		return
+	}
+	claimed, err := s.store.claimSubscribers(ctx, itemID, restocked)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to claim waitlist subscribers")
+		return
+	}
+
+	notified := 0
+	for _, sub := range claimed {
+		err := s.notifier.Notify(ctx, sub.UserID, NotificationEvent{
+			UserID:  sub.UserID,
+			Message: fmt.Sprintf("Good news! Item %s is back in stock.", itemID),
+		})
+		if err != nil {
+			logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Str("user", sub.UserID).Msg("Failed to publish back-in-stock notification")
+			if err := s.store.releaseSubscribers(ctx, claimed[notified:]); err != nil {
+				// 退回失败的订阅停留在已通知状态, 这些用户不会再收到本次到货的通知
+				logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to release unnotified waitlist subscribers")
+			}
+			break
+		}
+		notified++
+	}
+	if notified > 0 {
+		trace.SpanFromContext(ctx).AddEvent("Waitlist notified", trace.WithAttributes(
+			attribute.String("item.id", itemID),
+			attribute.Int("waitlist.notified", notified),
+			attribute.Int64("stock.restocked", restocked),
+		))
+		logger.Ctx(ctx).Printf("Notified %d waitlist subscriber(s) for item %s", notified, itemID)
+	}
+}
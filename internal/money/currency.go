@@ -0,0 +1,84 @@
+// internal/money/currency.go
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownCurrency 不是支持的 ISO-4217 货币代码
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// Currency 描述一种 ISO-4217 货币的精度和舍入规则
+type Currency struct {
+	Code string
+	// Digits 是最小货币单位的小数位数, 例如 CNY 为 2 (分), JPY 为 0
+	Digits int
+	// Increment 是舍入步长 (以最小货币单位计), 例如 CHF 按 0.05 舍入时为 5, 默认为 1
+	Increment int64
+}
+
+// currencies 是支持的货币。新增货币时同时要在汇率表中发布对应汇率。
+var currencies = map[string]Currency{
+	"CNY": {Code: "CNY", Digits: 2, Increment: 1},
+	"USD": {Code: "USD", Digits: 2, Increment: 1},
+	"EUR": {Code: "EUR", Digits: 2, Increment: 1},
+	"GBP": {Code: "GBP", Digits: 2, Increment: 1},
+	"HKD": {Code: "HKD", Digits: 2, Increment: 1},
+	"SGD": {Code: "SGD", Digits: 2, Increment: 1},
+	"CHF": {Code: "CHF", Digits: 2, Increment: 5},
+	"JPY": {Code: "JPY", Digits: 0, Increment: 1},
+	"KRW": {Code: "KRW", Digits: 0, Increment: 1},
+}
+
+// LookupCurrency 按代码查找货币, 代码不区分大小写
+func LookupCurrency(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// MustCurrency 与 LookupCurrency 相同, 但找不到时 panic, 用于常量货币代码
+func MustCurrency(code string) Currency {
+	c, err := LookupCurrency(code)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// scale 返回 10^Digits
+func (c Currency) scale() int64 {
+	s := int64(1)
+	for i := 0; i < c.Digits; i++ {
+		s *= 10
+	}
+	return s
+}
+
+// round 把以最小货币单位计的金额按舍入步长四舍五入
+func (c Currency) round(minor int64) int64 {
+	if c.Increment <= 1 {
+		return minor
+	}
+	return divRound(minor, c.Increment) * c.Increment
+}
+
+// divRound 计算 n/d 并四舍五入 (远离零方向), d 必须为正
+func divRound(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
@@ -0,0 +1,249 @@
+// internal/money/money.go
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrCurrencyMismatch 对两种不同货币的金额做了运算
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Money 是一笔精确的金额, 以最小货币单位 (例如分) 的整数保存, 避免浮点误差。
+// 零值没有货币, 只能作为 "尚未赋值" 使用。
+type Money struct {
+	minor    int64
+	currency Currency
+}
+
+// New 用最小货币单位的整数创建金额
+func New(minor int64, c Currency) Money {
+	return Money{minor: minor, currency: c}
+}
+
+// Zero 返回指定货币的零金额
+func Zero(c Currency) Money {
+	return Money{currency: c}
+}
+
+// Parse 把十进制字符串 (例如 "99.99") 解析为指定货币的金额。
+// 小数位数超过该货币精度时返回错误, 而不是悄悄舍入。
+func Parse(s string, c Currency) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	// 去掉超出精度的尾随 0, 例如 MySQL DECIMAL(12,4) 的 "10.5000"
+	if len(fracPart) > c.Digits {
+		trimmed := strings.TrimRight(fracPart[c.Digits:], "0")
+		if trimmed != "" {
+			return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", s, c.Digits, c.Code)
+		}
+		fracPart = fracPart[:c.Digits]
+	}
+	fracPart += strings.Repeat("0", c.Digits-len(fracPart))
+	if intPart == "" {
+		intPart = "0"
+	}
+	minor, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	if neg {
+		minor = -minor
+	}
+	return Money{minor: minor, currency: c}, nil
+}
+
+// MustParse 与 Parse 相同, 解析失败时 panic, 用于常量金额
+func MustParse(s string, c Currency) Money {
+	m, err := Parse(s, c)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Minor 返回以最小货币单位计的金额
+func (m Money) Minor() int64 { return m.minor }
+
+// Currency 返回金额的货币
+func (m Money) Currency() Currency { return m.currency }
+
+// IsZero 金额是否为 0
+func (m Money) IsZero() bool { return m.minor == 0 }
+
+// IsNegative 金额是否小于 0
+func (m Money) IsNegative() bool { return m.minor < 0 }
+
+// IsPositive 金额是否大于 0
+func (m Money) IsPositive() bool { return m.minor > 0 }
+
+func (m Money) mustSame(o Money) {
+	if m.currency.Code != o.currency.Code {
+		panic(fmt.Errorf("%w: %s vs %s", ErrCurrencyMismatch, m.currency.Code, o.currency.Code))
+	}
+}
+
+// Add 返回 m + o, 货币不同时 panic
+func (m Money) Add(o Money) Money {
+	m.mustSame(o)
+	return Money{minor: m.minor + o.minor, currency: m.currency}
+}
+
+// Sub 返回 m - o, 货币不同时 panic
+func (m Money) Sub(o Money) Money {
+	m.mustSame(o)
+	return Money{minor: m.minor - o.minor, currency: m.currency}
+}
+
+// Mul 返回 m * n, 用于单价乘数量
+func (m Money) Mul(n int64) Money {
+	return Money{minor: m.minor * n, currency: m.currency}
+}
+
+// Cmp 比较两笔金额, 返回 -1、0 或 1
+func (m Money) Cmp(o Money) int {
+	m.mustSame(o)
+	switch {
+	case m.minor < o.minor:
+		return -1
+	case m.minor > o.minor:
+		return 1
+	}
+	return 0
+}
+
+// Min 返回两笔金额中较小的一笔
+func Min(a, b Money) Money {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+// Percent 返回 m 的 percent% (例如 12.5 表示 12.5%), 按货币规则四舍五入。
+// 百分比精确到万分之一, 足以表达常见的折扣率和税率。
+func (m Money) Percent(percent float64) Money {
+	return m.MulRatio(basisPoints(percent), 10000)
+}
+
+// IncludedPercent 把 m 视为含 percent% 税费的总额, 返回其中的税额, 即 m * p / (100 + p)
+func (m Money) IncludedPercent(percent float64) Money {
+	bp := basisPoints(percent)
+	return m.MulRatio(bp, 10000+bp)
+}
+
+// MulRatio 返回 m * num / den, 按货币规则四舍五入, den 必须为正
+func (m Money) MulRatio(num, den int64) Money {
+	return Money{minor: m.currency.round(divRound(m.minor*num, den)), currency: m.currency}
+}
+
+func basisPoints(percent float64) int64 {
+	return int64(math.Round(percent * 100))
+}
+
+// Allocate 按权重把金额拆分成若干份, 各份之和严格等于 m。
+// 使用最大余数法, 舍入差额分给余数最大的几份, 权重全为 0 时平均分配。
+func (m Money) Allocate(weights []int64) []Money {
+	parts := make([]Money, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		weights = make([]int64, len(weights))
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = int64(len(weights))
+	}
+
+	var allocated int64
+	remainders := make([]int64, len(weights))
+	for i, w := range weights {
+		parts[i] = Money{minor: m.minor * w / total, currency: m.currency}
+		remainders[i] = m.minor * w % total
+		allocated += parts[i].minor
+	}
+	// 把剩下的最小单位逐个分给余数最大的份额
+	for left := m.minor - allocated; left != 0; {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		step := int64(1)
+		if left < 0 {
+			step = -1
+		}
+		parts[best].minor += step
+		remainders[best] = math.MinInt64
+		left -= step
+	}
+	return parts
+}
+
+// Number 返回金额的 JSON 数字形式, 例如 99.99, 供按数字解析旧字段的调用方使用; 数字直接由 Decimal 得到, 不经过浮点数
+func (m Money) Number() json.Number {
+	return json.Number(m.Decimal())
+}
+
+// Decimal 返回十进制字符串, 例如 "99.99"、"1200"
+func (m Money) Decimal() string {
+	if m.currency.Digits == 0 {
+		return strconv.FormatInt(m.minor, 10)
+	}
+	sign, minor := "", m.minor
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	scale := m.currency.scale()
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, m.currency.Digits, minor%scale)
+}
+
+// String 返回带货币代码的金额, 例如 "99.99 CNY"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency.Code
+}
+
+type moneyJSON struct {
+	Amount     string `json:"amount"`
+	Currency   string `json:"currency"`
+	MinorUnits int64  `json:"minorUnits"`
+}
+
+// MarshalJSON 把金额编码为 {"amount": "99.99", "currency": "CNY", "minorUnits": 9999},
+// amount 使用字符串, 避免调用方把它当成浮点数处理
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.currency.Code, MinorUnits: m.minor})
+}
+
+// UnmarshalJSON 解析 MarshalJSON 的输出, 以 amount 字段为准
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c, err := LookupCurrency(v.Currency)
+	if err != nil {
+		return err
+	}
+	parsed, err := Parse(v.Amount, c)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,234 @@
+// internal/money/money_test.go
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+var (
+	cny = MustCurrency("CNY")
+	jpy = MustCurrency("JPY")
+	chf = MustCurrency("CHF")
+	usd = MustCurrency("USD")
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency Currency
+		minor    int64
+		wantErr  bool
+	}{
+		{"99.99", cny, 9999, false},
+		{"0.5", cny, 50, false},
+		{".5", cny, 50, false},
+		{"12", cny, 1200, false},
+		{"-3.10", cny, -310, false},
+		{"+3.10", cny, 310, false},
+		{" 7.00 ", cny, 700, false},
+		{"10.5000", cny, 1050, false}, // MySQL DECIMAL 的尾随 0
+		{"1200", jpy, 1200, false},
+		{"1200.0", jpy, 1200, false},
+		{"0.05", chf, 5, false},
+		{"1.001", cny, 0, true}, // 超出精度
+		{"1.5", jpy, 0, true},
+		{"", cny, 0, true},
+		{"-", cny, 0, true},
+		{"abc", cny, 0, true},
+		{"1.2.3", cny, 0, true},
+	}
+	for _, tt := range tests {
+		m, err := Parse(tt.in, tt.currency)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q, %s) = %v, want error", tt.in, tt.currency.Code, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q, %s) error: %v", tt.in, tt.currency.Code, err)
+			continue
+		}
+		if m.Minor() != tt.minor || m.Currency().Code != tt.currency.Code {
+			t.Errorf("Parse(%q, %s) = %d %s, want %d", tt.in, tt.currency.Code, m.Minor(), m.Currency().Code, tt.minor)
+		}
+	}
+}
+
+func TestDecimalAndNumber(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(9999, cny), "99.99"},
+		{New(5, cny), "0.05"},
+		{New(-310, cny), "-3.10"},
+		{New(1200, jpy), "1200"},
+		{Zero(cny), "0.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("Decimal() = %q, want %q", got, tt.want)
+		}
+		out, err := json.Marshal(struct {
+			N json.Number `json:"n"`
+		}{tt.m.Number()})
+		if err != nil {
+			t.Fatalf("marshal Number: %v", err)
+		}
+		if want := `{"n":` + tt.want + `}`; string(out) != want {
+			t.Errorf("Number() encodes as %s, want %s", out, want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	in := New(12345, usd)
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"amount":"123.45","currency":"USD","minorUnits":12345}`; string(data) != want {
+		t.Errorf("MarshalJSON = %s, want %s", data, want)
+	}
+	var out Money
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Cmp(in) != 0 {
+		t.Errorf("round trip = %v, want %v", out, in)
+	}
+}
+
+func TestMulRatioRoundsPerCurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        Money
+		num, den int64
+		want     int64
+	}{
+		{"exact", New(1000, cny), 1, 4, 250},
+		{"half rounds away from zero", New(5, cny), 1, 2, 3},
+		{"below half rounds down", New(10, cny), 1, 3, 3},
+		{"negative half", New(-5, cny), 1, 2, -3},
+		{"jpy has no minor digits", New(100, jpy), 1, 3, 33},
+		{"chf rounds to 0.05", New(1000, chf), 1, 3, 335},      // 3.3333 -> 3.35
+		{"chf rounds down to 0.05", New(1000, chf), 1, 7, 145}, // 1.4286 -> 1.45
+	}
+	for _, tt := range tests {
+		if got := tt.m.MulRatio(tt.num, tt.den); got.Minor() != tt.want {
+			t.Errorf("%s: MulRatio(%d, %d) of %v = %d, want %d", tt.name, tt.num, tt.den, tt.m, got.Minor(), tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if got := MustParse("99.99", cny).Percent(12.5); got.Minor() != 1250 { // 12.49875
+		t.Errorf("12.5%% of 99.99 = %v, want 12.50", got)
+	}
+	if got := MustParse("113.00", cny).IncludedPercent(13); got.Minor() != 1300 {
+		t.Errorf("13%% tax included in 113.00 = %v, want 13.00", got)
+	}
+}
+
+func TestAllocateSumsToTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       Money
+		weights []int64
+		want    []int64
+	}{
+		{"even split with remainder", New(100, cny), []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"weighted", New(1000, cny), []int64{1, 2, 7}, []int64{100, 200, 700}},
+		{"largest remainder wins", New(10, cny), []int64{3, 3, 4}, []int64{3, 3, 4}},
+		{"zero weights split evenly", New(5, cny), []int64{0, 0}, []int64{3, 2}},
+		{"negative amount", New(-100, cny), []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"zero weight gets nothing", New(999, cny), []int64{0, 1, 2}, []int64{0, 333, 666}},
+	}
+	for _, tt := range tests {
+		parts := tt.m.Allocate(tt.weights)
+		sum := Zero(tt.m.Currency())
+		for i, p := range parts {
+			sum = sum.Add(p)
+			if p.Minor() != tt.want[i] {
+				t.Errorf("%s: part %d = %d, want %d", tt.name, i, p.Minor(), tt.want[i])
+			}
+		}
+		if sum.Cmp(tt.m) != 0 {
+			t.Errorf("%s: parts sum to %v, want %v", tt.name, sum, tt.m)
+		}
+	}
+
+	// 任意权重下各份之和都严格等于总额
+	for total := int64(-50); total <= 50; total++ {
+		m := New(total, cny)
+		sum := Zero(cny)
+		for _, p := range m.Allocate([]int64{7, 0, 13, 1}) {
+			sum = sum.Add(p)
+		}
+		if sum.Minor() != total {
+			t.Fatalf("Allocate(%d) sums to %d", total, sum.Minor())
+		}
+	}
+	if parts := New(100, cny).Allocate(nil); len(parts) != 0 {
+		t.Errorf("Allocate(nil) = %v, want empty", parts)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	// 1 CNY = 0.14 USD = 21 JPY = 0.1234 CHF
+	table := &RateTable{Version: 3, Base: "CNY", rates: map[string]*big.Rat{
+		"CNY": big.NewRat(1, 1),
+		"USD": big.NewRat(14, 100),
+		"JPY": big.NewRat(21, 1),
+		"CHF": big.NewRat(1234, 10000),
+	}}
+	tests := []struct {
+		name string
+		m    Money
+		to   Currency
+		want int64
+	}{
+		{"same currency", New(9999, cny), cny, 9999},
+		{"base to quote", New(10000, cny), usd, 1400},
+		{"rounds half away from zero", New(25, cny), usd, 4}, // 0.035
+		{"to zero-digit currency", New(1050, cny), jpy, 221}, // 220.5
+		{"from zero-digit currency", New(2100, jpy), cny, 10000},
+		{"cross rate", New(100, usd), jpy, 150},            // 1 USD = 150 JPY
+		{"chf rounds to 0.05", New(10000, cny), chf, 1235}, // 12.34 -> 12.35
+		{"negative amount", New(-25, cny), usd, -4},
+	}
+	for _, tt := range tests {
+		got, err := table.Convert(tt.m, tt.to)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Minor() != tt.want || got.Currency().Code != tt.to.Code {
+			t.Errorf("%s: Convert(%v, %s) = %v (%d), want %d", tt.name, tt.m, tt.to.Code, got, got.Minor(), tt.want)
+		}
+	}
+
+	if _, err := table.Convert(New(100, cny), MustCurrency("KRW")); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert to a currency without a rate: err = %v, want ErrNoRate", err)
+	}
+	conv, err := table.Conversion(usd, jpy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Rate != "150.00000000" || conv.RateVersion != 3 {
+		t.Errorf("Conversion(USD, JPY) = %+v", conv)
+	}
+}
+
+func TestArithmeticRejectsMixedCurrencies(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("adding CNY and USD did not panic")
+		}
+	}()
+	New(1, cny).Add(New(1, usd))
+}
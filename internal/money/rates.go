@@ -0,0 +1,190 @@
+// internal/money/rates.go
+package money
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// ErrNoRate 汇率表中没有所需货币的汇率
+var ErrNoRate = errors.New("no exchange rate")
+
+// ErrUnknownRateVersion 请求的汇率版本不存在或尚未发布
+var ErrUnknownRateVersion = errors.New("unknown exchange rate version")
+
+// RateTable 是某一版本的汇率表。汇率一经发布不再修改, 调整汇率即发布新版本,
+// 因此同一个版本号在任何时候换算出的结果都相同。
+type RateTable struct {
+	Version     int64
+	Base        string
+	PublishedAt time.Time
+	// rates 是 1 单位基准货币可兑换的各货币数量, 基准货币自身为 1
+	rates map[string]*big.Rat
+}
+
+// Conversion 记录一次换算使用的汇率, 随计价结果返回以便核对
+type Conversion struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Rate        string `json:"rate"`
+	RateVersion int64  `json:"rateVersion"`
+}
+
+// Rate 返回 from -> to 的交叉汇率
+func (t *RateTable) Rate(from, to string) (*big.Rat, error) {
+	fromRate, ok := t.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (version %d)", ErrNoRate, from, t.Version)
+	}
+	toRate, ok := t.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (version %d)", ErrNoRate, to, t.Version)
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// Convert 把金额换算成目标货币, 结果按目标货币的精度和舍入步长四舍五入
+func (t *RateTable) Convert(m Money, to Currency) (Money, error) {
+	if m.currency.Code == to.Code {
+		return m, nil
+	}
+	rate, err := t.Rate(m.currency.Code, to.Code)
+	if err != nil {
+		return Money{}, err
+	}
+	// minorTo = minorFrom * rate * 10^digitsTo / 10^digitsFrom
+	v := new(big.Rat).SetInt64(m.minor)
+	v.Mul(v, rate)
+	v.Mul(v, new(big.Rat).SetFrac64(to.scale(), m.currency.scale()))
+	return Money{minor: to.round(roundRat(v)), currency: to}, nil
+}
+
+// Conversion 返回 from -> to 的换算记录
+func (t *RateTable) Conversion(from, to Currency) (Conversion, error) {
+	rate, err := t.Rate(from.Code, to.Code)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{From: from.Code, To: to.Code, Rate: rate.FloatString(8), RateVersion: t.Version}, nil
+}
+
+// roundRat 把有理数四舍五入 (远离零方向) 为整数
+func roundRat(v *big.Rat) int64 {
+	num, den := new(big.Int).Set(v.Num()), v.Denom()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	r.Abs(r).Mul(r, big.NewInt(2))
+	if r.Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+// RateStore 从 MySQL 读取版本化的汇率表。
+// 最新版本缓存 ttl 时间, 指定版本的汇率表不可变, 加载后一直缓存。
+type RateStore struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.Mutex
+	latest   *RateTable
+	loadedAt time.Time
+	versions map[int64]*RateTable
+}
+
+// NewRateStore 创建汇率表存储
+func NewRateStore(db *sql.DB, ttl time.Duration) *RateStore {
+	return &RateStore{db: db, ttl: ttl, versions: make(map[int64]*RateTable)}
+}
+
+// Table 返回指定版本的汇率表, version 为 0 时返回当前已发布的最新版本
+func (s *RateStore) Table(ctx context.Context, version int64) (*RateTable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version == 0 {
+		if s.latest != nil && time.Since(s.loadedAt) < s.ttl {
+			return s.latest, nil
+		}
+		err := s.db.QueryRowContext(ctx,
+			`SELECT version FROM exchange_rate_version WHERE published_at <= NOW() ORDER BY version DESC LIMIT 1`).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: no published version", ErrUnknownRateVersion)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query latest exchange rate version: %w", err)
+		}
+		t, err := s.load(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		s.latest, s.loadedAt = t, time.Now()
+		return t, nil
+	}
+	return s.load(ctx, version)
+}
+
+// TableAt 返回 at 时刻生效的汇率表, 即当时已发布的最新版本, 用于按历史汇率重新计价
+func (s *RateStore) TableAt(ctx context.Context, at time.Time) (*RateTable, error) {
+	var version int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT version FROM exchange_rate_version WHERE published_at <= ? ORDER BY version DESC LIMIT 1`, at).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: no version published before %s", ErrUnknownRateVersion, at.Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query exchange rate version at %s: %w", at.Format(time.RFC3339), err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(ctx, version)
+}
+
+// load 加载一个版本的汇率表, 调用方需持有 s.mu
+func (s *RateStore) load(ctx context.Context, version int64) (*RateTable, error) {
+	if t, ok := s.versions[version]; ok {
+		return t, nil
+	}
+	t := &RateTable{Version: version, rates: make(map[string]*big.Rat)}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT base_currency, published_at FROM exchange_rate_version WHERE version = ? AND published_at <= NOW()`, version).
+		Scan(&t.Base, &t.PublishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownRateVersion, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query exchange rate version %d: %w", version, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT currency, rate FROM exchange_rate WHERE version = ?`, version)
+	if err != nil {
+		return nil, fmt.Errorf("query exchange rates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code, raw string
+		if err := rows.Scan(&code, &raw); err != nil {
+			return nil, fmt.Errorf("scan exchange rate: %w", err)
+		}
+		rate, ok := new(big.Rat).SetString(raw)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q for %s (version %d)", raw, code, version)
+		}
+		t.rates[code] = rate
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	t.rates[t.Base] = big.NewRat(1, 1)
+
+	s.versions[version] = t
+	return t, nil
+}
@@ -0,0 +1,253 @@
+// internal/pricing/catalog.go
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"nexus/internal/money"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSKU 价格目录中没有该商品, 或商品已下架
+var ErrUnknownSKU = errors.New("unknown sku")
+
+// PriceListVIP 是 VIP 用户使用的价目表编码
+const PriceListVIP = "vip"
+
+// Tier 是一档数量阶梯价
+type Tier struct {
+	MinQuantity int64       `json:"minQuantity"`
+	UnitPrice   money.Money `json:"unitPrice"`
+}
+
+// CatalogEntry 是一个 SKU 的全部定价数据
+type CatalogEntry struct {
+	SKU       string                 `json:"sku"`
+	Name      string                 `json:"name"`
+	Category  string                 `json:"category"`
+	BasePrice money.Money            `json:"basePrice"`
+	Tiers     []Tier                 `json:"tiers,omitempty"` // 按 MinQuantity 升序
+	Lists     map[string]money.Money `json:"lists,omitempty"` // 价目表编码 -> 单价
+
+	// validUntil 是下一次排期价格生效或失效的时间, 缓存不能跨过这个时间点
+	validUntil time.Time
+}
+
+// TierPrice 返回购买 quantity 件时适用的阶梯单价, 没有适用阶梯时返回 false
+func (e *CatalogEntry) TierPrice(quantity int64) (money.Money, bool) {
+	var (
+		price money.Money
+		ok    bool
+	)
+	for _, t := range e.Tiers {
+		if quantity >= t.MinQuantity {
+			price, ok = t.UnitPrice, true
+		}
+	}
+	return price, ok
+}
+
+type cachedEntry struct {
+	entry     *CatalogEntry
+	expiresAt time.Time
+}
+
+// Catalog 从 MySQL 读取价格目录, 并在内存中缓存一段时间。
+// 价格修改后可以调用 Invalidate 立即失效, 否则最迟 ttl 之后生效;
+// 排期价格 (price_entry) 在生效和失效的时间点会自动让缓存过期。
+// 目录中的价格都以同一种基准货币计。
+type Catalog struct {
+	db       *sql.DB
+	ttl      time.Duration
+	currency money.Currency
+
+	mu    sync.RWMutex
+	cache map[string]cachedEntry
+}
+
+// NewCatalog 创建一个带缓存的价格目录, currency 是目录价格的货币
+func NewCatalog(db *sql.DB, ttl time.Duration, currency money.Currency) *Catalog {
+	return &Catalog{db: db, ttl: ttl, currency: currency, cache: make(map[string]cachedEntry)}
+}
+
+// Currency 返回目录价格的货币
+func (c *Catalog) Currency() money.Currency {
+	return c.currency
+}
+
+// Get 批量查询 SKU 的定价数据, 未命中缓存的 SKU 用一次查询加载。
+// 任意一个 SKU 不存在都会返回 ErrUnknownSKU。
+func (c *Catalog) Get(ctx context.Context, skus []string) (map[string]*CatalogEntry, error) {
+	result := make(map[string]*CatalogEntry, len(skus))
+	var missing []string
+	now := time.Now()
+
+	c.mu.RLock()
+	for _, sku := range skus {
+		if cached, ok := c.cache[sku]; ok && now.Before(cached.expiresAt) {
+			result[sku] = cached.entry
+		} else {
+			missing = append(missing, sku)
+		}
+	}
+	c.mu.RUnlock()
+
+	if len(missing) > 0 {
+		loaded, err := c.load(ctx, dedupe(missing), now)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		for sku, entry := range loaded {
+			expiresAt := now.Add(c.ttl)
+			if !entry.validUntil.IsZero() && entry.validUntil.Before(expiresAt) {
+				expiresAt = entry.validUntil
+			}
+			c.cache[sku] = cachedEntry{entry: entry, expiresAt: expiresAt}
+			result[sku] = entry
+		}
+		c.mu.Unlock()
+	}
+	return result, checkKnown(result, skus)
+}
+
+// GetAsOf 查询 SKU 在 at 时刻生效的排期价格, 不经过缓存; 没有排期覆盖的部分使用当前的静态目录价
+func (c *Catalog) GetAsOf(ctx context.Context, skus []string, at time.Time) (map[string]*CatalogEntry, error) {
+	result, err := c.load(ctx, dedupe(append([]string(nil), skus...)), at)
+	if err != nil {
+		return nil, err
+	}
+	return result, checkKnown(result, skus)
+}
+
+func checkKnown(result map[string]*CatalogEntry, skus []string) error {
+	for _, sku := range skus {
+		if _, ok := result[sku]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSKU, sku)
+		}
+	}
+	return nil
+}
+
+// Invalidate 使指定 SKU 的缓存失效, 不传参数时清空全部缓存
+func (c *Catalog) Invalidate(skus ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(skus) == 0 {
+		c.cache = make(map[string]cachedEntry)
+		return
+	}
+	for _, sku := range skus {
+		delete(c.cache, sku)
+	}
+}
+
+// load 加载 SKU 的静态价格, 再用 at 时刻生效的排期价格覆盖
+func (c *Catalog) load(ctx context.Context, skus []string, at time.Time) (map[string]*CatalogEntry, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(skus)), ",")
+	args := make([]any, len(skus))
+	for i, sku := range skus {
+		args[i] = sku
+	}
+
+	entries := make(map[string]*CatalogEntry, len(skus))
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT sku, name, category, base_price FROM price_catalog WHERE status = 1 AND sku IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query price catalog: %w", err)
+	}
+	for rows.Next() {
+		var (
+			e     = &CatalogEntry{Lists: map[string]money.Money{}}
+			price string
+		)
+		if err := rows.Scan(&e.SKU, &e.Name, &e.Category, &price); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan price catalog: %w", err)
+		}
+		if e.BasePrice, err = money.Parse(price, c.currency); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("base price of %s: %w", e.SKU, err)
+		}
+		entries[e.SKU] = e
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = c.db.QueryContext(ctx,
+		`SELECT sku, min_quantity, unit_price FROM price_tier WHERE sku IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query price tiers: %w", err)
+	}
+	for rows.Next() {
+		var (
+			sku, price string
+			t          Tier
+		)
+		if err := rows.Scan(&sku, &t.MinQuantity, &price); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan price tier: %w", err)
+		}
+		if t.UnitPrice, err = money.Parse(price, c.currency); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("tier price of %s: %w", sku, err)
+		}
+		if e, ok := entries[sku]; ok {
+			e.Tiers = append(e.Tiers, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = c.db.QueryContext(ctx,
+		`SELECT list_code, sku, unit_price FROM price_list_item WHERE sku IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query price lists: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var list, sku, price string
+		if err := rows.Scan(&list, &sku, &price); err != nil {
+			return nil, fmt.Errorf("scan price list: %w", err)
+		}
+		m, err := money.Parse(price, c.currency)
+		if err != nil {
+			return nil, fmt.Errorf("%s list price of %s: %w", list, sku, err)
+		}
+		if e, ok := entries[sku]; ok {
+			e.Lists[list] = m
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := c.applyScheduled(ctx, entries, placeholders, args, at); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		sort.Slice(e.Tiers, func(i, j int) bool { return e.Tiers[i].MinQuantity < e.Tiers[j].MinQuantity })
+	}
+	return entries, nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,445 @@
+// internal/pricing/dynamic.go
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"math"
+	"nexus/internal/money"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DynamicDataID 是动态定价配置在 Nacos 中的 Data ID
+const DynamicDataID = "nexus-pricing-dynamic.yaml"
+
+// 动态定价使用的库存指标
+const (
+	// MetricAvailability 可售库存 / 参考库存, 低于 1 表示偏紧, 高于 1 表示积压
+	MetricAvailability = "availability"
+	// MetricReservation 已预占 / (可售 + 已预占), 反映需求的热度
+	MetricReservation = "reservation"
+)
+
+// StockLevelTopic 是 inventory-service 发布库存水位的主题
+const StockLevelTopic = "stock-levels"
+
+// 调价原因
+const (
+	DynamicReasonStock  = "stock"
+	DynamicReasonConfig = "config"
+)
+
+// StockLevelEvent 与 inventory-service 发布到 stock-levels 主题的事件结构保持一致
+type StockLevelEvent struct {
+	ItemID     string    `json:"itemId"`
+	Available  int64     `json:"available"`
+	Reserved   int64     `json:"reserved"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// DynamicConfig 是 nexus-pricing-dynamic.yaml 的结构
+type DynamicConfig struct {
+	Enabled bool                   `yaml:"enabled"`
+	Items   map[string]DynamicItem `yaml:"items"`
+}
+
+// DynamicItem 是一个商品的动态定价配置。未配置或 enabled 为 false 的商品不调价。
+type DynamicItem struct {
+	Enabled bool   `yaml:"enabled"`
+	Metric  string `yaml:"metric"`
+	// ReferenceStock 是 availability 指标的分母, 即该商品的 "正常" 库存量
+	ReferenceStock int64 `yaml:"referenceStock"`
+	// Curve 是指标到价格倍数的分段线性曲线, 超出两端时取端点的倍数
+	Curve []CurvePoint `yaml:"curve"`
+	// Floor 和 Ceiling 是调价后单价的下限和上限 (目录货币), 调价不会越过它们
+	Floor   string `yaml:"floor"`
+	Ceiling string `yaml:"ceiling"`
+
+	floor, ceiling money.Money
+}
+
+// CurvePoint 是价格曲线上的一个点
+type CurvePoint struct {
+	At         float64 `yaml:"at"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// DynamicAudit 是一条调价记录
+type DynamicAudit struct {
+	ID            int64     `json:"id"`
+	ItemID        string    `json:"itemId"`
+	OldMultiplier float64   `json:"oldMultiplier"`
+	NewMultiplier float64   `json:"newMultiplier"`
+	Metric        string    `json:"metric"`
+	MetricValue   float64   `json:"metricValue"`
+	Available     int64     `json:"available"`
+	Reserved      int64     `json:"reserved"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// DynamicState 是一个商品当前的动态定价状态
+type DynamicState struct {
+	ItemID     string           `json:"itemId"`
+	Multiplier float64          `json:"multiplier"`
+	Stock      *StockLevelEvent `json:"stock,omitempty"`
+}
+
+// DynamicPricer 根据 inventory-service 发布的库存水位计算各商品的价格倍数。
+// 倍数只在库存变化或配置变化时重新计算, 每次倍数变化都写入 dynamic_price_audit 表;
+// 计价时只读取当前倍数, 因此每一个实际生效过的价格都能在审计表中找到来源。
+type DynamicPricer struct {
+	db       *sql.DB
+	currency money.Currency
+	cfg      atomic.Pointer[DynamicConfig]
+
+	// apply 串行化 recompute, 保证先写审计再生效的倍数与审计记录的顺序一致
+	apply   sync.Mutex
+	mu      sync.RWMutex
+	signals map[string]StockLevelEvent
+	current map[string]float64
+}
+
+// NewDynamicPricer 创建动态定价器, 默认关闭
+func NewDynamicPricer(db *sql.DB, currency money.Currency) *DynamicPricer {
+	p := &DynamicPricer{
+		db:       db,
+		currency: currency,
+		signals:  make(map[string]StockLevelEvent),
+		current:  make(map[string]float64),
+	}
+	p.cfg.Store(&DynamicConfig{})
+	return p
+}
+
+// Load 从审计表恢复每个商品最后一次生效的倍数, 避免重启后重放库存事件时重复记录相同的调价
+func (p *DynamicPricer) Load(ctx context.Context) error {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT a.item_id, a.new_multiplier FROM dynamic_price_audit a
+		 JOIN (SELECT item_id, MAX(id) AS id FROM dynamic_price_audit GROUP BY item_id) last ON last.id = a.id`)
+	if err != nil {
+		return fmt.Errorf("load dynamic multipliers: %w", err)
+	}
+	defer rows.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for rows.Next() {
+		var (
+			itemID string
+			mult   float64
+		)
+		if err := rows.Scan(&itemID, &mult); err != nil {
+			return fmt.Errorf("scan dynamic multiplier: %w", err)
+		}
+		p.current[itemID] = mult
+	}
+	return rows.Err()
+}
+
+// Update 用新的配置替换当前配置, 并按新配置重新计算所有已知商品的倍数。
+// 有任何一个商品的配置不合法时整份配置都不生效。
+// 启动后还没有收到库存水位的商品保留 Load 恢复的倍数, 等下一次库存变化时再按新配置计算。
+func (p *DynamicPricer) Update(cfg DynamicConfig) {
+	for itemID, item := range cfg.Items {
+		if err := item.compile(p.currency); err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid dynamic pricing config for %s, keeping previous config: %v", itemID, err)
+			return
+		}
+		cfg.Items[itemID] = item
+	}
+	p.cfg.Store(&cfg)
+	logger.Logger.Printf("✅ Dynamic pricing config applied: enabled=%v, %d item(s)", cfg.Enabled, len(cfg.Items))
+
+	p.mu.RLock()
+	items := make([]string, 0, len(p.current)+len(p.signals))
+	for itemID := range p.current {
+		items = append(items, itemID)
+	}
+	for itemID := range p.signals {
+		if _, ok := p.current[itemID]; !ok {
+			items = append(items, itemID)
+		}
+	}
+	p.mu.RUnlock()
+	for _, itemID := range items {
+		p.recompute(context.Background(), itemID, DynamicReasonConfig)
+	}
+}
+
+// Observe 记录一次库存水位变化并重新计算该商品的倍数
+func (p *DynamicPricer) Observe(ctx context.Context, event StockLevelEvent) {
+	p.mu.Lock()
+	if last, ok := p.signals[event.ItemID]; ok && event.OccurredAt.Before(last.OccurredAt) {
+		// 同一商品的事件按 key 分区, 正常情况下有序, 这里只防御重放的旧事件
+		p.mu.Unlock()
+		return
+	}
+	p.signals[event.ItemID] = event
+	p.mu.Unlock()
+	p.recompute(ctx, event.ItemID, DynamicReasonStock)
+}
+
+// Adjust 返回调价后的单价和使用的倍数; 商品没有启用动态定价或倍数为 1 时返回 false
+func (p *DynamicPricer) Adjust(itemID string, unit money.Money) (money.Money, float64, bool) {
+	item, mult := p.multiplier(itemID)
+	if mult == 1 {
+		return unit, 1, false
+	}
+	return item.bound(unit, mult), mult, true
+}
+
+// multiplier 返回商品当前生效的倍数, 没有启用动态定价时为 1
+func (p *DynamicPricer) multiplier(itemID string) (DynamicItem, float64) {
+	if p == nil {
+		return DynamicItem{}, 1
+	}
+	cfg := p.cfg.Load()
+	item, ok := cfg.Items[itemID]
+	if !cfg.Enabled || !ok || !item.Enabled {
+		return item, 1
+	}
+	p.mu.RLock()
+	mult, ok := p.current[itemID]
+	p.mu.RUnlock()
+	if !ok {
+		return item, 1
+	}
+	return item, mult
+}
+
+// AdjustAt 与 Adjust 相同, 但使用审计表中 at 时刻生效的倍数, 用于按历史价格重新计价。
+// 倍数的开关已经体现在审计记录中 (关闭时倍数回到 1), 上下限使用当前配置。
+func (p *DynamicPricer) AdjustAt(ctx context.Context, itemID string, unit money.Money, at time.Time) (money.Money, float64, bool, error) {
+	if p == nil {
+		return unit, 1, false, nil
+	}
+	var mult float64
+	err := p.db.QueryRowContext(ctx,
+		`SELECT new_multiplier FROM dynamic_price_audit WHERE item_id = ? AND created_at <= ? ORDER BY id DESC LIMIT 1`,
+		itemID, at).Scan(&mult)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && mult == 1) {
+		return unit, 1, false, nil
+	}
+	if err != nil {
+		return unit, 1, false, fmt.Errorf("query dynamic multiplier of %s at %s: %w", itemID, at.Format(time.RFC3339), err)
+	}
+	item := p.cfg.Load().Items[itemID]
+	return item.bound(unit, mult), mult, true, nil
+}
+
+// Bound 按商品的动态定价上下限约束单价从 from 调整到 to 的幅度, 规则与倍数调价相同。
+// 价格实验的变体在动态调价之后生效, 用它保证变体同样不会越过上下限; 没有启用动态定价的商品原样返回 to。
+func (p *DynamicPricer) Bound(itemID string, from, to money.Money) money.Money {
+	if p == nil {
+		return to
+	}
+	cfg := p.cfg.Load()
+	item, ok := cfg.Items[itemID]
+	if !cfg.Enabled || !ok || !item.Enabled {
+		return to
+	}
+	return item.clamp(from, to)
+}
+
+// bound 把倍数作用到单价上, 并按上下限约束调价幅度
+func (d *DynamicItem) bound(unit money.Money, mult float64) money.Money {
+	return d.clamp(unit, unit.MulRatio(int64(math.Round(mult*10000)), 10000))
+}
+
+// clamp 按上下限约束单价从 unit 调整到 adjusted 的幅度
+func (d *DynamicItem) clamp(unit, adjusted money.Money) money.Money {
+	// 下限只约束降价, 上限只约束涨价; 原价本身已经越界时保持原价, 不会被反向拉回
+	if d.Floor != "" && adjusted.Cmp(unit) < 0 && adjusted.Cmp(d.floor) < 0 {
+		adjusted = money.Min(unit, d.floor)
+	}
+	if d.Ceiling != "" && adjusted.Cmp(unit) > 0 && adjusted.Cmp(d.ceiling) > 0 {
+		if unit.Cmp(d.ceiling) > 0 {
+			adjusted = unit
+		} else {
+			adjusted = d.ceiling
+		}
+	}
+	return adjusted
+}
+
+// States 返回所有已知商品当前的倍数和最近一次库存快照
+func (p *DynamicPricer) States() []DynamicState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	states := make([]DynamicState, 0, len(p.current))
+	for itemID, mult := range p.current {
+		state := DynamicState{ItemID: itemID, Multiplier: mult}
+		if signal, ok := p.signals[itemID]; ok {
+			state.Stock = &signal
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ItemID < states[j].ItemID })
+	return states
+}
+
+// Audit 按时间倒序返回某个商品的调价记录, itemID 为空时返回所有商品的
+func (p *DynamicPricer) Audit(ctx context.Context, itemID string, limit int) ([]DynamicAudit, error) {
+	query := `SELECT id, item_id, old_multiplier, new_multiplier, metric, metric_value, available, reserved, reason, created_at
+		FROM dynamic_price_audit`
+	args := []any{}
+	if itemID != "" {
+		query += ` WHERE item_id = ?`
+		args = append(args, itemID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query dynamic price audit: %w", err)
+	}
+	defer rows.Close()
+	audits := []DynamicAudit{}
+	for rows.Next() {
+		var a DynamicAudit
+		if err := rows.Scan(&a.ID, &a.ItemID, &a.OldMultiplier, &a.NewMultiplier, &a.Metric, &a.MetricValue,
+			&a.Available, &a.Reserved, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan dynamic price audit: %w", err)
+		}
+		audits = append(audits, a)
+	}
+	return audits, rows.Err()
+}
+
+// recompute 按当前配置和库存快照计算倍数, 倍数变化时先写审计记录, 写入成功后才生效。
+// 商品仍启用动态定价但还没有库存快照时 (例如刚重启) 无法计算, 保持当前倍数不变。
+func (p *DynamicPricer) recompute(ctx context.Context, itemID, reason string) {
+	p.apply.Lock()
+	defer p.apply.Unlock()
+
+	cfg := p.cfg.Load()
+	item, configured := cfg.Items[itemID]
+	active := cfg.Enabled && configured && item.Enabled
+
+	p.mu.RLock()
+	signal, hasSignal := p.signals[itemID]
+	old, known := p.current[itemID]
+	p.mu.RUnlock()
+	if active && !hasSignal {
+		return
+	}
+	if !known {
+		old = 1
+	}
+	mult, metricValue := 1.0, 0.0
+	if active {
+		metricValue = item.metricValue(signal)
+		mult = item.multiplier(metricValue)
+	}
+	if math.Abs(mult-old) < 1e-4 {
+		return
+	}
+
+	audit := DynamicAudit{
+		ItemID: itemID, OldMultiplier: old, NewMultiplier: mult, Metric: item.Metric, MetricValue: metricValue,
+		Available: signal.Available, Reserved: signal.Reserved, Reason: reason,
+	}
+	if err := p.writeAudit(ctx, audit); err != nil {
+		// 审计没有写入时不调价, 保证每一个生效过的倍数都能在审计表中找到; 下一次库存变化时会重试
+		logger.Ctx(ctx).Error().Err(err).Str("item", itemID).Msg("Failed to write dynamic price audit, keeping previous multiplier")
+		return
+	}
+	p.mu.Lock()
+	p.current[itemID] = mult
+	p.mu.Unlock()
+	logger.Ctx(ctx).Printf("Dynamic price multiplier for %s changed %.4f -> %.4f (%s=%.4f, reason=%s)",
+		itemID, old, mult, item.Metric, metricValue, reason)
+}
+
+// writeAudit 写入调价记录。多个 pricing-service 实例会各自算出同一次调价,
+// 因此只有当该商品最后一条记录的倍数与本次不同时才插入。
+func (p *DynamicPricer) writeAudit(ctx context.Context, a DynamicAudit) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO dynamic_price_audit (item_id, old_multiplier, new_multiplier, metric, metric_value, available, reserved, reason)
+		 SELECT ?, ?, ?, ?, ?, ?, ?, ? FROM DUAL
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM (SELECT new_multiplier FROM dynamic_price_audit WHERE item_id = ? ORDER BY id DESC LIMIT 1) last
+		     WHERE last.new_multiplier = ?)`,
+		a.ItemID, a.OldMultiplier, a.NewMultiplier, a.Metric, a.MetricValue, a.Available, a.Reserved, a.Reason,
+		a.ItemID, a.NewMultiplier)
+	if err != nil {
+		return fmt.Errorf("insert dynamic price audit: %w", err)
+	}
+	return nil
+}
+
+func (d *DynamicItem) compile(currency money.Currency) error {
+	switch d.Metric {
+	case MetricAvailability:
+		if d.ReferenceStock <= 0 {
+			return fmt.Errorf("referenceStock must be positive for metric %s", d.Metric)
+		}
+	case MetricReservation:
+	default:
+		return fmt.Errorf("unknown metric %q", d.Metric)
+	}
+	if len(d.Curve) == 0 {
+		return fmt.Errorf("curve is empty")
+	}
+	sort.Slice(d.Curve, func(i, j int) bool { return d.Curve[i].At < d.Curve[j].At })
+	for _, pt := range d.Curve {
+		if pt.Multiplier <= 0 {
+			return fmt.Errorf("multiplier at %v must be positive", pt.At)
+		}
+	}
+	if d.Floor != "" {
+		m, err := money.Parse(d.Floor, currency)
+		if err != nil {
+			return fmt.Errorf("floor: %w", err)
+		}
+		d.floor = m
+	}
+	if d.Ceiling != "" {
+		m, err := money.Parse(d.Ceiling, currency)
+		if err != nil {
+			return fmt.Errorf("ceiling: %w", err)
+		}
+		d.ceiling = m
+	}
+	if d.Floor != "" && d.Ceiling != "" && d.floor.Cmp(d.ceiling) > 0 {
+		return fmt.Errorf("floor %s is above ceiling %s", d.floor, d.ceiling)
+	}
+	return nil
+}
+
+// metricValue 根据库存快照计算指标值
+func (d *DynamicItem) metricValue(s StockLevelEvent) float64 {
+	if d.Metric == MetricAvailability {
+		return float64(s.Available) / float64(d.ReferenceStock)
+	}
+	total := s.Available + s.Reserved
+	if total <= 0 {
+		return 0
+	}
+	return float64(s.Reserved) / float64(total)
+}
+
+// multiplier 在曲线上做线性插值, 结果保留 4 位小数
+func (d *DynamicItem) multiplier(x float64) float64 {
+	curve := d.Curve
+	mult := curve[len(curve)-1].Multiplier
+	if x <= curve[0].At {
+		mult = curve[0].Multiplier
+	} else {
+		for i := 1; i < len(curve); i++ {
+			if x <= curve[i].At {
+				a, b := curve[i-1], curve[i]
+				mult = a.Multiplier + (b.Multiplier-a.Multiplier)*(x-a.At)/(b.At-a.At)
+				break
+			}
+		}
+	}
+	return math.Round(mult*10000) / 10000
+}
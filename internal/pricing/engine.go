@@ -0,0 +1,451 @@
+// internal/pricing/engine.go
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nexus/internal/money"
+	"time"
+)
+
+// ErrEmptyCart 请求中没有任何商品
+var ErrEmptyCart = errors.New("cart is empty")
+
+// ErrInvalidQuantity 商品行的数量不是正整数
+var ErrInvalidQuantity = errors.New("invalid quantity")
+
+// 单价的来源
+const (
+	SourceBase = "base"
+	SourceTier = "tier"
+	SourceList = "list"
+)
+
+// CartLine 是购物车中的一行
+type CartLine struct {
+	ItemID   string `json:"itemId"`
+	Quantity int64  `json:"quantity"`
+}
+
+// PriceRequest 是一次计价请求
+type PriceRequest struct {
+	UserID string `json:"userId"`
+	IsVIP  bool   `json:"isVip"`
+	// Segments 是调用方已知的用户分群, 用于匹配折扣规则
+	Segments []string   `json:"segments,omitempty"`
+	Items    []CartLine `json:"items"`
+	// Currency 是结果使用的货币 (ISO-4217), 为空时使用价格目录的货币
+	Currency string `json:"currency,omitempty"`
+	// Region 是收货地区 (ISO 3166, 例如 CN、US-CA), 决定适用的税率, 为空时使用默认地区
+	Region string `json:"region,omitempty"`
+	// AsOf 不为空时按该时刻生效的排期价格、动态倍数、折扣时间窗口和汇率重新计价。
+	// 静态目录价、折扣规则、税率、价格实验和动态定价上下限都只有当前版本, 结果只是估算;
+	// 订单和退款应当使用下单时绑定的计价快照 (PriceSnapshot)
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+// segments 返回请求所属的全部用户分群
+func (r PriceRequest) segments() []string {
+	out := append([]string{}, r.Segments...)
+	if r.IsVIP {
+		return append(out, SegmentVIP)
+	}
+	return append(out, SegmentRegular)
+}
+
+// LineItem 是计价结果中的一行
+type LineItem struct {
+	ItemID        string      `json:"itemId"`
+	Name          string      `json:"name,omitempty"`
+	Quantity      int64       `json:"quantity"`
+	BaseUnitPrice money.Money `json:"baseUnitPrice"`
+	UnitPrice     money.Money `json:"unitPrice"`
+	PriceSource   string      `json:"priceSource"`
+	// DynamicMultiplier 是库存驱动的动态定价倍数, 未调价时为空
+	DynamicMultiplier float64     `json:"dynamicMultiplier,omitempty"`
+	Total             money.Money `json:"total"`
+	Discount          money.Money `json:"discount"`
+	TaxRate           string      `json:"taxRate,omitempty"`
+	TaxPercent        float64     `json:"taxPercent"`
+	Tax               money.Money `json:"tax"`
+	// TaxIncluded 为 true 时税额已包含在 Total 中, 否则需要另外加上
+	TaxIncluded bool `json:"taxIncluded"`
+	// Experiment 和 Variant 是这一行所属的价格实验和用户被分到的变体
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+	// Cached 为 true 表示折扣前的单价来自行缓存
+	Cached bool `json:"cached,omitempty"`
+}
+
+// Payable 返回这一行实际应付的金额: 折后金额, 价外税时再加上税额
+func (l LineItem) Payable() money.Money {
+	payable := l.Total.Sub(l.Discount)
+	if !l.TaxIncluded {
+		payable = payable.Add(l.Tax)
+	}
+	return payable
+}
+
+// PriceResult 是一次计价的结果, 所有金额都使用 Currency
+type PriceResult struct {
+	// PricedAt 是计价所依据的时刻, 历史计价时等于请求中的 AsOf
+	PricedAt  time.Time   `json:"pricedAt"`
+	Currency  string      `json:"currency"`
+	LineItems []LineItem  `json:"lineItems"`
+	Subtotal  money.Money `json:"subtotal"`
+	Discount  money.Money `json:"discount"`
+	Total     money.Money `json:"total"`
+	// AppliedRules 是本次生效的全部折扣规则, 按生效顺序排列
+	AppliedRules []AppliedRule `json:"appliedRules"`
+	// Experiments 是用户参与的价格实验及分到的变体, 用于和订单数据关联分析
+	Experiments []ExperimentAssignment `json:"experiments"`
+	// Region 和 TaxMode 是计税使用的地区和方式, 没有配置税率时为空
+	Region       string      `json:"region,omitempty"`
+	TaxMode      string      `json:"taxMode,omitempty"`
+	Tax          money.Money `json:"tax"`
+	TaxBreakdown []TaxLine   `json:"taxBreakdown"`
+	// Conversion 记录了目录货币到结果货币的换算, 未换算时为空
+	Conversion *money.Conversion `json:"conversion,omitempty"`
+	// Price 是 Total 的 JSON 数字形式 (例如 99.99), 保留给按数字解析旧字段的调用方 (订单服务);
+	// PriceMoney 与 Total 相同
+	Price      json.Number `json:"price"`
+	PriceMoney money.Money `json:"priceMoney"`
+
+	// QuoteID 和 QuoteToken 由 QuoteSigner 签发, 下单时用 /verify_quote 锁定价格
+	QuoteID        string     `json:"quoteId,omitempty"`
+	QuoteToken     string     `json:"quoteToken,omitempty"`
+	QuoteExpiresAt *time.Time `json:"quoteExpiresAt,omitempty"`
+}
+
+// EngineOptions 是计价引擎除价格目录外的各个组成部分
+type EngineOptions struct {
+	// Rules 是折扣规则, 为 nil 时不打折
+	Rules *RuleSet
+	// Rates 是汇率表, 为 nil 时只能以目录货币计价
+	Rates *money.RateStore
+	// Taxes 是税率规则, 为 nil 时不计税
+	Taxes *TaxRules
+	// Dynamic 是库存驱动的动态定价, 为 nil 时不调价
+	Dynamic *DynamicPricer
+	// Experiments 是价格实验, 为 nil 时不做实验
+	Experiments *Experiments
+	// Lines 是 Redis 行缓存, 为 nil 时 CalculateCart 不使用缓存
+	Lines *LineCache
+}
+
+// Engine 根据价格目录、动态定价、折扣规则和税率计算购物车价格
+type Engine struct {
+	catalog *Catalog
+	rules   *RuleSet
+	rates   *money.RateStore
+	taxes   *TaxRules
+	dynamic *DynamicPricer
+	exps    *Experiments
+	lines   *LineCache
+}
+
+// NewEngine 创建计价引擎
+func NewEngine(catalog *Catalog, opts EngineOptions) *Engine {
+	if opts.Rules == nil {
+		opts.Rules = NewRuleSet(catalog.Currency())
+	}
+	if opts.Taxes == nil {
+		opts.Taxes = NewTaxRules()
+	}
+	return &Engine{catalog: catalog, rules: opts.Rules, rates: opts.Rates, taxes: opts.Taxes,
+		dynamic: opts.Dynamic, exps: opts.Experiments, lines: opts.Lines}
+}
+
+// Calculate 计算购物车中每一行的单价和小计, 然后按优先级叠加折扣规则。
+// 单价取基础价、适用的数量阶梯价、以及 VIP 价目表价格中最低的一个, 再乘以动态定价倍数,
+// 最后按用户所在的价格实验变体调整。
+// 价格和折扣以目录货币计算, 按最新汇率换算成请求的货币后, 再按收货地区计税,
+// 这样税额直接按结果货币的规则舍入。
+func (e *Engine) Calculate(ctx context.Context, req PriceRequest) (*PriceResult, error) {
+	return e.calculate(ctx, req, false, nil)
+}
+
+// CalculateCart 与 Calculate 相同, 但各行折扣前的单价优先从 Redis 行缓存读取,
+// 用于一次调用给整个购物车计价。命中缓存的行在结果中 Cached 为 true。
+// 按历史时刻计价或没有配置行缓存时不使用缓存。
+func (e *Engine) CalculateCart(ctx context.Context, req PriceRequest) (*PriceResult, error) {
+	return e.calculate(ctx, req, true, nil)
+}
+
+// calculate 是 Calculate、CalculateCart 和 Explain 共用的实现,
+// cached 为 true 时使用行缓存, explain 不为 nil 时记录计算过程 (此时不使用缓存)
+func (e *Engine) calculate(ctx context.Context, req PriceRequest, cached bool, explain *ExplainNode) (*PriceResult, error) {
+	if len(req.Items) == 0 {
+		return nil, ErrEmptyCart
+	}
+	base := e.catalog.Currency()
+	target := base
+	if req.Currency != "" {
+		c, err := money.LookupCurrency(req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		target = c
+	}
+	var (
+		taxRegion TaxRegion
+		taxCode   string
+	)
+	if e.taxes.Enabled() {
+		code, region, err := e.taxes.region(req.Region)
+		if err != nil {
+			return nil, err
+		}
+		taxCode, taxRegion = code, region
+	}
+	skus := make([]string, 0, len(req.Items))
+	for _, line := range req.Items {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: %d for %s", ErrInvalidQuantity, line.Quantity, line.ItemID)
+		}
+		skus = append(skus, line.ItemID)
+	}
+	pricedAt, historical := time.Now(), req.AsOf != nil
+	if historical {
+		pricedAt = *req.AsOf
+	}
+
+	// 批量计价时先查行缓存, 只有未命中的行才需要读取价格目录
+	var (
+		hits         = make([]*linePrice, len(req.Items))
+		cacheVersion int64
+	)
+	useCache := cached && e.lines != nil && !historical && explain == nil
+	if useCache {
+		hits, cacheVersion, useCache = e.lines.lookupLines(ctx, base.Code, req, e.dynamic)
+	}
+	missing := make([]string, 0, len(skus))
+	for i, sku := range skus {
+		if hits[i] == nil {
+			missing = append(missing, sku)
+		}
+	}
+	entries := map[string]*CatalogEntry{}
+	if len(missing) > 0 {
+		var err error
+		if historical {
+			entries, err = e.catalog.GetAsOf(ctx, missing, pricedAt)
+		} else {
+			entries, err = e.catalog.Get(ctx, missing)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &PriceResult{
+		PricedAt:  pricedAt,
+		Currency:  base.Code,
+		LineItems: make([]LineItem, 0, len(req.Items)),
+		Subtotal:  money.Zero(base),
+		Discount:  money.Zero(base),
+	}
+	categories := make(map[string]string, len(req.Items))
+	basePrices := explain.child(StepBasePrices, "", "", "unit price is the lowest of base, tier and list prices")
+	for i, line := range req.Items {
+		priced, fromCache := hits[i], hits[i] != nil
+		var (
+			entry  = entries[line.ItemID]
+			chosen money.Money
+		)
+		if !fromCache {
+			p, c, err := e.priceLine(ctx, entry, line, req.IsVIP, pricedAt, historical)
+			if err != nil {
+				return nil, err
+			}
+			priced, chosen = &p, c
+			if useCache {
+				mult := p.DynamicMultiplier
+				if mult == 0 {
+					mult = 1
+				}
+				e.lines.store(ctx, lineKey(cacheVersion, base.Code, line.ItemID, line.Quantity, req.IsVIP, mult), p, entry.validUntil)
+			}
+		}
+		categories[line.ItemID] = priced.Category
+
+		unit := priced.UnitPrice
+		beforeExperiment := unit
+		exp, variant := e.exps.variantFor(req.UserID, line.ItemID)
+		if variant != nil {
+			// 变体在动态调价之后生效, 调整后的单价同样受动态定价上下限约束
+			unit = e.dynamic.Bound(line.ItemID, beforeExperiment, variant.apply(unit))
+		}
+
+		item := LineItem{
+			ItemID:            line.ItemID,
+			Name:              priced.Name,
+			Quantity:          line.Quantity,
+			BaseUnitPrice:     priced.BaseUnitPrice,
+			UnitPrice:         unit,
+			PriceSource:       priced.Source,
+			DynamicMultiplier: priced.DynamicMultiplier,
+			Total:             unit.Mul(line.Quantity),
+			Discount:          money.Zero(base),
+			Cached:            fromCache,
+		}
+		if variant != nil {
+			item.Experiment, item.Variant = exp.ID, variant.Name
+		}
+		result.LineItems = append(result.LineItems, item)
+		result.Subtotal = result.Subtotal.Add(item.Total)
+
+		if explain == nil {
+			continue
+		}
+		lineNode := basePrices.child(StepLine, line.ItemID, priced.Source,
+			fmt.Sprintf("%d x %s", line.Quantity, unit)).amount(item.Total)
+		explainCandidates(lineNode, entry, line, req.IsVIP, priced.Source)
+		if priced.DynamicMultiplier != 0 {
+			lineNode.child(StepCandidate, "dynamic", OutcomeApplied,
+				fmt.Sprintf("stock-driven multiplier %.4f on %s, bounded by floor/ceiling", priced.DynamicMultiplier, chosen)).amount(beforeExperiment)
+		}
+		if variant != nil {
+			lineNode.child(StepCandidate, "experiment", OutcomeApplied,
+				fmt.Sprintf("experiment %s variant %s (%+v%%, %s) on %s, bounded by floor/ceiling", exp.ID, variant.Name, variant.Percent, variant.amount, beforeExperiment)).amount(unit)
+		}
+	}
+	explain.child(StepBasePrices, "subtotal", "", "").amount(result.Subtotal)
+	result.Experiments = collectAssignments(result.LineItems)
+
+	rc := ruleContext{segments: req.segments(), categories: categories, now: pricedAt}
+	var evaluations []RuleEvaluation
+	result.AppliedRules, evaluations = applyRules(e.rules.Rules(), rc, result)
+	for _, applied := range result.AppliedRules {
+		result.Discount = result.Discount.Add(applied.Discount)
+	}
+	if explain != nil {
+		discounts := explain.child(StepDiscounts, "", "", fmt.Sprintf("segments %v", rc.segments)).amount(result.Discount)
+		for _, ev := range evaluations {
+			node := discounts.child(StepRule, ev.RuleID, ev.Outcome, ev.Reason)
+			if ev.Outcome == OutcomeApplied {
+				node.amount(ev.Discount)
+			}
+		}
+	}
+
+	if target.Code != base.Code {
+		if err := e.convert(ctx, result, target, historical); err != nil {
+			return nil, err
+		}
+		c := result.Conversion
+		explain.child(StepConversion, c.From+"->"+c.To, OutcomeApplied,
+			fmt.Sprintf("rate %s (version %d), each line converted separately", c.Rate, c.RateVersion)).amount(result.Subtotal)
+	}
+	result.Tax = money.Zero(result.Subtotal.Currency())
+	result.TaxBreakdown = []TaxLine{}
+	if taxCode != "" {
+		applyTax(taxRegion, categories, result)
+		result.Region, result.TaxMode = taxCode, taxRegion.Mode
+
+		taxNode := explain.child(StepTax, taxCode, taxRegion.Mode, "").amount(result.Tax)
+		for _, line := range result.LineItems {
+			taxNode.child(StepLine, line.ItemID, line.TaxRate,
+				fmt.Sprintf("%v%% on %s", line.TaxPercent, line.Total.Sub(line.Discount))).amount(line.Tax)
+		}
+	} else {
+		explain.child(StepTax, "", OutcomeSkipped, "no tax rules configured")
+		for i := range result.LineItems {
+			result.LineItems[i].Tax = result.Tax
+		}
+	}
+	result.Total = result.Subtotal.Sub(result.Discount)
+	if result.TaxMode == TaxExclusive {
+		result.Total = result.Total.Add(result.Tax)
+	}
+	result.Price, result.PriceMoney = result.Total.Number(), result.Total
+	explain.child(StepTotal, "", "", fmt.Sprintf("subtotal %s - discount %s, tax %s (%s)",
+		result.Subtotal, result.Discount, result.Tax, result.TaxMode)).amount(result.Total)
+	return result, nil
+}
+
+// priceLine 计算一行折扣前的单价: 取基础价、适用的阶梯价和 VIP 价目表价格中最低的一个,
+// 再乘以动态定价倍数。同时返回乘以倍数之前的单价, 供解释计价过程使用。
+func (e *Engine) priceLine(ctx context.Context, entry *CatalogEntry, line CartLine, isVIP bool, pricedAt time.Time, historical bool) (linePrice, money.Money, error) {
+	unit, source := entry.BasePrice, SourceBase
+	if tier, ok := entry.TierPrice(line.Quantity); ok && tier.Cmp(unit) < 0 {
+		unit, source = tier, SourceTier
+	}
+	if isVIP {
+		if vip, ok := entry.Lists[PriceListVIP]; ok && vip.Cmp(unit) < 0 {
+			unit, source = vip, SourceList
+		}
+	}
+	priced := linePrice{
+		Name: entry.Name, Category: entry.Category, BaseUnitPrice: entry.BasePrice, UnitPrice: unit, Source: source,
+	}
+	if historical {
+		adjusted, mult, ok, err := e.dynamic.AdjustAt(ctx, line.ItemID, unit, pricedAt)
+		if err != nil {
+			return priced, unit, err
+		}
+		if ok {
+			priced.UnitPrice, priced.DynamicMultiplier = adjusted, mult
+		}
+	} else if adjusted, mult, ok := e.dynamic.Adjust(line.ItemID, unit); ok {
+		priced.UnitPrice, priced.DynamicMultiplier = adjusted, mult
+	}
+	return priced, unit, nil
+}
+
+// convert 把计价结果换算成目标货币。
+// 每一行单独换算, 小计和折扣由换算后的各行相加, 保证结果内部的加总关系依然成立。
+// historical 为 true 时使用 result.PricedAt 时刻生效的汇率版本。
+func (e *Engine) convert(ctx context.Context, result *PriceResult, target money.Currency, historical bool) error {
+	if e.rates == nil {
+		return fmt.Errorf("%w: exchange rates are not configured", money.ErrNoRate)
+	}
+	var (
+		table *money.RateTable
+		err   error
+	)
+	if historical {
+		table, err = e.rates.TableAt(ctx, result.PricedAt)
+	} else {
+		table, err = e.rates.Table(ctx, 0)
+	}
+	if err != nil {
+		return err
+	}
+	conversion, err := table.Conversion(money.MustCurrency(result.Currency), target)
+	if err != nil {
+		return err
+	}
+	conv := func(m *money.Money) {
+		if err == nil {
+			*m, err = table.Convert(*m, target)
+		}
+	}
+
+	result.Subtotal, result.Discount = money.Zero(target), money.Zero(target)
+	for i := range result.LineItems {
+		line := &result.LineItems[i]
+		conv(&line.BaseUnitPrice)
+		conv(&line.UnitPrice)
+		conv(&line.Total)
+		conv(&line.Discount)
+		if err != nil {
+			return err
+		}
+		result.Subtotal = result.Subtotal.Add(line.Total)
+		result.Discount = result.Discount.Add(line.Discount)
+	}
+	// 规则的优惠金额不单独换算, 而是把换算后的总优惠按原金额比例分摊, 保证各规则之和等于 Discount
+	weights := make([]int64, len(result.AppliedRules))
+	for i, applied := range result.AppliedRules {
+		weights[i] = applied.Discount.Minor()
+	}
+	for i, part := range result.Discount.Allocate(weights) {
+		result.AppliedRules[i].Discount = part
+	}
+	result.Currency = target.Code
+	result.Conversion = &conversion
+	return nil
+}
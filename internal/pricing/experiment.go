@@ -0,0 +1,203 @@
+// internal/pricing/experiment.go
+package pricing
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"sort"
+	"sync/atomic"
+)
+
+// ExperimentsDataID 是价格实验配置在 Nacos 中的 Data ID
+const ExperimentsDataID = "nexus-pricing-experiments.yaml"
+
+// bucketCount 是分桶数量, 流量比例可以精确到 0.01%
+const bucketCount = 10000
+
+// ExperimentsConfig 是 nexus-pricing-experiments.yaml 的结构
+type ExperimentsConfig struct {
+	Experiments []Experiment `yaml:"experiments"`
+}
+
+// Experiment 是一个价格实验。
+// 用户按 sha256(salt:user_id) 稳定地落入 10000 个桶之一, 各变体按 traffic 依次占用一段桶,
+// 没有落入任何变体的用户不参加实验。修改 traffic 只会影响边界附近的用户, 更换 salt 会重新打散所有用户。
+type Experiment struct {
+	ID      string   `yaml:"id"`
+	Enabled bool     `yaml:"enabled"`
+	Salt    string   `yaml:"salt"` // 为空时使用 ID
+	Items   []string `yaml:"items"`
+	// Variants 按顺序占用桶区间, traffic 之和不能超过 100
+	Variants []Variant `yaml:"variants"`
+}
+
+// Variant 是实验的一个变体
+type Variant struct {
+	Name string `yaml:"name"`
+	// Traffic 是分到该变体的流量百分比, 例如 25 表示 25%
+	Traffic float64 `yaml:"traffic"`
+	// Percent 是对单价的百分比调整, 负数表示降价, 例如 -5 表示 95 折
+	Percent float64 `yaml:"percent"`
+	// Amount 是对单价的固定调整 (目录货币), 负数表示降价, 在 Percent 之后应用
+	Amount string `yaml:"amount"`
+
+	amount money.Money
+	upper  int // 占用的桶区间上界 (不含)
+}
+
+// ExperimentAssignment 是用户在一个实验中被分到的变体
+type ExperimentAssignment struct {
+	ExperimentID string   `json:"experimentId"`
+	Variant      string   `json:"variant"`
+	Items        []string `json:"items"`
+}
+
+// Experiments 持有当前生效的价格实验, 支持热更新
+type Experiments struct {
+	currency money.Currency
+	cfg      atomic.Pointer[compiledExperiments]
+}
+
+type compiledExperiments struct {
+	experiments []Experiment
+	byItem      map[string]int // sku -> experiments 下标
+}
+
+// NewExperiments 创建一个空的实验集合
+func NewExperiments(currency money.Currency) *Experiments {
+	e := &Experiments{currency: currency}
+	e.cfg.Store(&compiledExperiments{byItem: map[string]int{}})
+	return e
+}
+
+// Update 用新的配置替换当前实验。
+// 同一个商品同时只能属于一个启用的实验, 否则实验之间会互相干扰; 不合法的配置整份不生效。
+func (e *Experiments) Update(cfg ExperimentsConfig) {
+	compiled := &compiledExperiments{byItem: map[string]int{}}
+	for _, exp := range cfg.Experiments {
+		if !exp.Enabled {
+			continue
+		}
+		if err := exp.compile(e.currency); err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid pricing experiment, keeping previous experiments: %v", err)
+			return
+		}
+		for _, sku := range exp.Items {
+			if other, ok := compiled.byItem[sku]; ok {
+				logger.Logger.Printf("❌ ERROR: Item %s is in both experiments %s and %s, keeping previous experiments",
+					sku, compiled.experiments[other].ID, exp.ID)
+				return
+			}
+			compiled.byItem[sku] = len(compiled.experiments)
+		}
+		compiled.experiments = append(compiled.experiments, exp)
+	}
+	e.cfg.Store(compiled)
+	logger.Logger.Printf("✅ Pricing experiments applied: %d active experiment(s)", len(compiled.experiments))
+}
+
+func (exp *Experiment) compile(currency money.Currency) error {
+	if exp.ID == "" {
+		return fmt.Errorf("experiment without id")
+	}
+	if len(exp.Items) == 0 || len(exp.Variants) == 0 {
+		return fmt.Errorf("experiment %s needs items and variants", exp.ID)
+	}
+	if exp.Salt == "" {
+		exp.Salt = exp.ID
+	}
+	upper := 0
+	for i := range exp.Variants {
+		v := &exp.Variants[i]
+		if v.Name == "" || v.Traffic <= 0 {
+			return fmt.Errorf("experiment %s: variant needs a name and positive traffic", exp.ID)
+		}
+		v.amount = money.Zero(currency)
+		if v.Amount != "" {
+			m, err := money.Parse(v.Amount, currency)
+			if err != nil {
+				return fmt.Errorf("experiment %s variant %s: amount: %w", exp.ID, v.Name, err)
+			}
+			v.amount = m
+		}
+		upper += int(v.Traffic * bucketCount / 100)
+		v.upper = upper
+	}
+	if upper > bucketCount {
+		return fmt.Errorf("experiment %s: traffic adds up to more than 100%%", exp.ID)
+	}
+	return nil
+}
+
+// bucket 返回用户在实验中的桶号, 同一个 salt 和 user_id 永远落在同一个桶
+func bucket(salt, userID string) int {
+	sum := sha256.Sum256([]byte(salt + ":" + userID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % bucketCount)
+}
+
+// assign 返回用户在该实验中被分到的变体, 没有分到任何变体时返回 nil
+func (exp *Experiment) assign(userID string) *Variant {
+	b := bucket(exp.Salt, userID)
+	for i := range exp.Variants {
+		if b < exp.Variants[i].upper {
+			return &exp.Variants[i]
+		}
+	}
+	return nil
+}
+
+// apply 把变体的调整作用到单价上, 单价不会低于 0
+func (v *Variant) apply(unit money.Money) money.Money {
+	adjusted := unit
+	if v.Percent != 0 {
+		adjusted = adjusted.Add(unit.Percent(v.Percent))
+	}
+	adjusted = adjusted.Add(v.amount)
+	if adjusted.IsNegative() {
+		return money.Zero(unit.Currency())
+	}
+	return adjusted
+}
+
+// variantFor 返回用户在某个商品所属实验中的变体; 匿名用户不参加实验
+func (e *Experiments) variantFor(userID, sku string) (*Experiment, *Variant) {
+	if e == nil || userID == "" {
+		return nil, nil
+	}
+	cfg := e.cfg.Load()
+	idx, ok := cfg.byItem[sku]
+	if !ok {
+		return nil, nil
+	}
+	exp := &cfg.experiments[idx]
+	v := exp.assign(userID)
+	if v == nil {
+		return nil, nil
+	}
+	return exp, v
+}
+
+// collectAssignments 按实验汇总各行的变体
+func collectAssignments(lines []LineItem) []ExperimentAssignment {
+	byExp := map[string]*ExperimentAssignment{}
+	for _, line := range lines {
+		if line.Experiment == "" {
+			continue
+		}
+		a, ok := byExp[line.Experiment]
+		if !ok {
+			a = &ExperimentAssignment{ExperimentID: line.Experiment, Variant: line.Variant}
+			byExp[line.Experiment] = a
+		}
+		a.Items = append(a.Items, line.ItemID)
+	}
+	out := make([]ExperimentAssignment, 0, len(byExp))
+	for _, a := range byExp {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExperimentID < out[j].ExperimentID })
+	return out
+}
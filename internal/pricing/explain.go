@@ -0,0 +1,118 @@
+// internal/pricing/explain.go
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"nexus/internal/money"
+	"time"
+)
+
+// 计算过程的步骤
+const (
+	StepPrice      = "price"
+	StepBasePrices = "base_prices"
+	StepLine       = "line"
+	StepCandidate  = "candidate"
+	StepDiscounts  = "discounts"
+	StepRule       = "rule"
+	StepConversion = "conversion"
+	StepTax        = "tax"
+	StepTotal      = "total"
+)
+
+// 步骤的结果
+const (
+	OutcomeChosen     = "chosen"
+	OutcomeConsidered = "considered"
+	OutcomeIgnored    = "ignored"
+	OutcomeApplied    = "applied"
+	OutcomeRejected   = "rejected"
+	OutcomeSkipped    = "skipped"
+)
+
+// ExplainNode 是计价过程树中的一个节点。
+// 所有方法在接收者为 nil 时都是空操作, 正常计价时传入 nil 即可不产生任何开销。
+type ExplainNode struct {
+	Step     string         `json:"step"`
+	Subject  string         `json:"subject,omitempty"`
+	Outcome  string         `json:"outcome,omitempty"`
+	Detail   string         `json:"detail,omitempty"`
+	Amount   *money.Money   `json:"amount,omitempty"`
+	Children []*ExplainNode `json:"children,omitempty"`
+}
+
+// child 添加一个子节点并返回它, 接收者为 nil 时返回 nil
+func (n *ExplainNode) child(step, subject, outcome, detail string) *ExplainNode {
+	if n == nil {
+		return nil
+	}
+	c := &ExplainNode{Step: step, Subject: subject, Outcome: outcome, Detail: detail}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// amount 给节点附上金额, 返回节点本身以便链式调用
+func (n *ExplainNode) amount(m money.Money) *ExplainNode {
+	if n != nil {
+		n.Amount = &m
+	}
+	return n
+}
+
+// Walk 以深度优先顺序访问节点, depth 从 0 开始
+func (n *ExplainNode) Walk(fn func(depth int, node *ExplainNode)) {
+	var walk func(int, *ExplainNode)
+	walk = func(depth int, node *ExplainNode) {
+		fn(depth, node)
+		for _, c := range node.Children {
+			walk(depth+1, c)
+		}
+	}
+	if n != nil {
+		walk(0, n)
+	}
+}
+
+// Explain 与 Calculate 的计算完全相同, 同时返回完整的计算过程树:
+// 每一行的候选单价、每一条折扣规则的评估结果 (生效或被拒绝及原因)、汇率换算和计税步骤。
+func (e *Engine) Explain(ctx context.Context, req PriceRequest) (*PriceResult, *ExplainNode, error) {
+	root := &ExplainNode{Step: StepPrice, Subject: req.UserID}
+	if req.AsOf != nil {
+		root.Detail = "priced as of " + req.AsOf.Format(time.RFC3339)
+	}
+	result, err := e.calculate(ctx, req, false, root)
+	if err != nil {
+		root.child(StepTotal, "", OutcomeRejected, err.Error())
+		return nil, root, err
+	}
+	return result, root, nil
+}
+
+// explainCandidates 记录一行所有候选单价以及最终选中的来源
+func explainCandidates(node *ExplainNode, entry *CatalogEntry, line CartLine, isVIP bool, source string) {
+	if node == nil {
+		return
+	}
+	outcome := func(s string) string {
+		if s == source {
+			return OutcomeChosen
+		}
+		return OutcomeConsidered
+	}
+	node.child(StepCandidate, SourceBase, outcome(SourceBase), "catalog base price").amount(entry.BasePrice)
+	if tier, ok := entry.TierPrice(line.Quantity); ok {
+		node.child(StepCandidate, SourceTier, outcome(SourceTier),
+			fmt.Sprintf("quantity %d reaches a volume tier", line.Quantity)).amount(tier)
+	} else if len(entry.Tiers) > 0 {
+		node.child(StepCandidate, SourceTier, OutcomeIgnored,
+			fmt.Sprintf("quantity %d is below the first tier (%d)", line.Quantity, entry.Tiers[0].MinQuantity))
+	}
+	if vip, ok := entry.Lists[PriceListVIP]; ok {
+		if isVIP {
+			node.child(StepCandidate, SourceList, outcome(SourceList), "vip price list").amount(vip)
+		} else {
+			node.child(StepCandidate, SourceList, OutcomeIgnored, "vip price list requires a vip user").amount(vip)
+		}
+	}
+}
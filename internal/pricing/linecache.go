@@ -0,0 +1,152 @@
+// internal/pricing/linecache.go
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"strconv"
+	"time"
+)
+
+// lineCacheVersionKey 保存行缓存的全局版本号, 价格目录或规则变化时加一,
+// 所有实例读到新版本后自然不再命中旧的缓存条目, 旧条目等 TTL 到期后由 Redis 清理
+const lineCacheVersionKey = "pricing:line-cache:version"
+
+// 行缓存的查询结果
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+var lineCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pricing_line_cache_lookups_total",
+	Help: "Number of per-line price cache lookups by result.",
+}, []string{"result"})
+
+// linePrice 是单行的计价结果: 折扣和价格实验之前的单价, 批量计价时缓存在 Redis 中
+type linePrice struct {
+	Name              string      `json:"name"`
+	Category          string      `json:"category"`
+	BaseUnitPrice     money.Money `json:"baseUnitPrice"`
+	UnitPrice         money.Money `json:"unitPrice"`
+	Source            string      `json:"source"`
+	DynamicMultiplier float64     `json:"dynamicMultiplier,omitempty"`
+}
+
+// LineCache 在 Redis 中按 SKU、数量、价目表和动态倍数缓存单行的单价, 供批量计价使用。
+// 折扣、价格实验、汇率和税依赖整个购物车或用户, 不进入缓存, 每次都重新计算。
+// 条目的 TTL 不会超过排期价格的下一个变化时间; 目录或规则变化时调用 Bump 整体失效。
+type LineCache struct {
+	rdb redis.UniversalClient
+	ttl time.Duration
+}
+
+// NewLineCache 创建行缓存, ttl 是单个条目的最长有效期
+func NewLineCache(rdb redis.UniversalClient, ttl time.Duration) *LineCache {
+	return &LineCache{rdb: rdb, ttl: ttl}
+}
+
+// Bump 让所有实例的全部行缓存失效
+func (c *LineCache) Bump(ctx context.Context, reason string) {
+	if c == nil {
+		return
+	}
+	version, err := c.rdb.Incr(ctx, lineCacheVersionKey).Result()
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Str("reason", reason).Msg("failed to bump price line cache version")
+		return
+	}
+	logger.Ctx(ctx).Printf("Price line cache invalidated (%s), version is now %d", reason, version)
+}
+
+// version 返回当前的全局版本号, 从未失效过时为 0
+func (c *LineCache) version(ctx context.Context) (int64, error) {
+	v, err := c.rdb.Get(ctx, lineCacheVersionKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// lookupLines 查询购物车各行的缓存条目, 返回的 version 用于写回新计算的条目;
+// 读不到版本号时返回 ok=false, 这次计价不读也不写缓存
+func (c *LineCache) lookupLines(ctx context.Context, currency string, req PriceRequest, dynamic *DynamicPricer) (hits []*linePrice, version int64, ok bool) {
+	hits = make([]*linePrice, len(req.Items))
+	version, err := c.version(ctx)
+	if err != nil {
+		lineCacheLookups.WithLabelValues(CacheError).Add(float64(len(req.Items)))
+		logger.Ctx(ctx).Warn().Err(err).Msg("failed to read price line cache version, computing all lines")
+		return hits, 0, false
+	}
+	keys := make([]string, len(req.Items))
+	for i, line := range req.Items {
+		_, mult := dynamic.multiplier(line.ItemID)
+		keys[i] = lineKey(version, currency, line.ItemID, line.Quantity, req.IsVIP, mult)
+	}
+	return c.lookup(ctx, keys), version, true
+}
+
+// lineKey 返回一行的缓存 key。动态倍数作为 key 的一部分, 库存变化导致调价时自然换用新条目。
+// 同一版本的 key 带有相同的 hash tag {pricing:line:v<N>}, 落在 Redis Cluster 的同一个 slot 上, 才能用一次 MGET 批量读取。
+func lineKey(version int64, currency, sku string, quantity int64, vip bool, mult float64) string {
+	list := "-"
+	if vip {
+		list = PriceListVIP
+	}
+	return fmt.Sprintf("{pricing:line:v%d}:%s:%s:q%d:%s:m%s",
+		version, currency, sku, quantity, list, strconv.FormatFloat(mult, 'f', -1, 64))
+}
+
+// lookup 批量读取缓存条目, 未命中的位置为 nil。Redis 出错时全部视为未命中, 计价不受影响。
+func (c *LineCache) lookup(ctx context.Context, keys []string) []*linePrice {
+	lines := make([]*linePrice, len(keys))
+	values, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		lineCacheLookups.WithLabelValues(CacheError).Add(float64(len(keys)))
+		logger.Ctx(ctx).Warn().Err(err).Msg("price line cache lookup failed, computing all lines")
+		return lines
+	}
+	for i, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			lineCacheLookups.WithLabelValues(CacheMiss).Inc()
+			continue
+		}
+		var line linePrice
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			lineCacheLookups.WithLabelValues(CacheError).Inc()
+			continue
+		}
+		lines[i] = &line
+		lineCacheLookups.WithLabelValues(CacheHit).Inc()
+	}
+	return lines
+}
+
+// store 写入新计算的条目, validUntil 非零时条目不会活过这个时间点
+func (c *LineCache) store(ctx context.Context, key string, line linePrice, validUntil time.Time) {
+	ttl := c.ttl
+	if !validUntil.IsZero() {
+		if until := time.Until(validUntil); until < ttl {
+			ttl = until
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	raw, err := json.Marshal(line)
+	if err == nil {
+		err = c.rdb.Set(ctx, key, raw, ttl).Err()
+	}
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("failed to store price line cache")
+	}
+}
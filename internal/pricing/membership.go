@@ -0,0 +1,50 @@
+// internal/pricing/membership.go
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// MembershipStore 从 user_segment 表读取用户所属的分群, 是报价令牌中用户分群的唯一来源。
+// 计价请求中的 isVip / segments 由调用方填写, 只影响本次计价, 不会签入报价令牌;
+// 下游服务 (例如运费优惠) 只信任令牌中这里查出的分群。
+type MembershipStore struct {
+	db *sql.DB
+}
+
+// NewMembershipStore 创建用户分群存储
+func NewMembershipStore(db *sql.DB) *MembershipStore {
+	return &MembershipStore{db: db}
+}
+
+// Segments 返回用户当前所属的全部分群, 与计价一样不属于 vip 的用户归入 regular; 匿名用户没有分群
+func (s *MembershipStore) Segments(ctx context.Context, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT segment FROM user_segment
+		 WHERE user_id = ? AND (expires_at IS NULL OR expires_at > NOW()) ORDER BY segment`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query user segments: %w", err)
+	}
+	defer rows.Close()
+	var segments []string
+	for rows.Next() {
+		var segment string
+		if err := rows.Scan(&segment); err != nil {
+			return nil, fmt.Errorf("scan user segment: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query user segments: %w", err)
+	}
+	if !slices.Contains(segments, SegmentVIP) {
+		segments = append(segments, SegmentRegular)
+	}
+	return segments, nil
+}
@@ -0,0 +1,152 @@
+// internal/pricing/quote.go
+package pricing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nexus/internal/money"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 报价校验失败的原因
+var (
+	ErrQuoteInvalid  = errors.New("quote token is invalid")
+	ErrQuoteExpired  = errors.New("quote has expired")
+	ErrQuoteMismatch = errors.New("quote does not match the order")
+)
+
+// quoteTokenVersion 是令牌格式的版本前缀, 修改签名内容时需要升级
+const quoteTokenVersion = "v1"
+
+// QuoteLine 是报价中的一行, 用于核对下单的商品和数量
+type QuoteLine struct {
+	ItemID   string      `json:"itemId"`
+	Quantity int64       `json:"quantity"`
+	Total    money.Money `json:"total"`
+}
+
+// QuoteClaims 是报价令牌签名覆盖的全部内容。
+// Segments 是签发时从 MembershipStore 查出的用户分群, 不是计价请求中调用方填写的分群。
+type QuoteClaims struct {
+	QuoteID     string      `json:"quoteId"`
+	UserID      string      `json:"userId,omitempty"`
+	Segments    []string    `json:"segments,omitempty"`
+	Items       []QuoteLine `json:"items"`
+	Total       money.Money `json:"total"`
+	RateVersion int64       `json:"rateVersion,omitempty"`
+	IssuedAt    time.Time   `json:"issuedAt"`
+	ExpiresAt   time.Time   `json:"expiresAt"`
+}
+
+// QuoteSigner 为计价结果签发带有效期的 HMAC 报价令牌, 并校验令牌。
+// 令牌格式为 v1.<base64url(claims)>.<base64url(HMAC-SHA256)>,
+// 所有持有同一密钥的 pricing-service 实例都能校验彼此签发的令牌。
+type QuoteSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewQuoteSigner 创建报价签名器, ttl 是报价的有效期
+func NewQuoteSigner(secret []byte, ttl time.Duration) *QuoteSigner {
+	return &QuoteSigner{secret: secret, ttl: ttl}
+}
+
+// Issue 为计价结果签发报价, 把报价 ID、令牌和过期时间写回 result。
+// segments 是服务端为 userID 查出的用户分群, 会签入令牌。
+func (s *QuoteSigner) Issue(userID string, segments []string, result *PriceResult) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	claims := QuoteClaims{
+		QuoteID:   uuid.NewString(),
+		UserID:    userID,
+		Segments:  segments,
+		Items:     make([]QuoteLine, 0, len(result.LineItems)),
+		Total:     result.Total,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if result.Conversion != nil {
+		claims.RateVersion = result.Conversion.RateVersion
+	}
+	for _, line := range result.LineItems {
+		claims.Items = append(claims.Items, QuoteLine{
+			ItemID: line.ItemID, Quantity: line.Quantity, Total: line.Payable(),
+		})
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return fmt.Errorf("marshal quote claims: %w", err)
+	}
+	signed := quoteTokenVersion + "." + base64.RawURLEncoding.EncodeToString(payload)
+	result.QuoteID = claims.QuoteID
+	result.QuoteToken = signed + "." + base64.RawURLEncoding.EncodeToString(s.sign(signed))
+	expiresAt := claims.ExpiresAt
+	result.QuoteExpiresAt = &expiresAt
+	return nil
+}
+
+// Verify 校验令牌的签名和有效期, 成功时返回令牌中的报价内容。
+// 令牌过期时同时返回报价内容和 ErrQuoteExpired, 便于调用方记录是哪一份报价。
+func (s *QuoteSigner) Verify(token string, now time.Time) (*QuoteClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != quoteTokenVersion {
+		return nil, fmt.Errorf("%w: malformed token", ErrQuoteInvalid)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, s.sign(parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: bad signature", ErrQuoteInvalid)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad payload encoding", ErrQuoteInvalid)
+	}
+	var claims QuoteClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad payload: %v", ErrQuoteInvalid, err)
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return &claims, fmt.Errorf("%w: expired at %s", ErrQuoteExpired, claims.ExpiresAt.Format(time.RFC3339))
+	}
+	return &claims, nil
+}
+
+// Matches 检查报价是否属于该用户、且覆盖的商品和数量与下单内容完全一致。
+// userID 或 items 为空时跳过对应的检查。
+func (c *QuoteClaims) Matches(userID string, items []CartLine) error {
+	if userID != "" && c.UserID != userID {
+		return fmt.Errorf("%w: quote was issued to another user", ErrQuoteMismatch)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	quoted := make(map[string]int64, len(c.Items))
+	for _, line := range c.Items {
+		quoted[line.ItemID] += line.Quantity
+	}
+	ordered := make(map[string]int64, len(items))
+	for _, line := range items {
+		ordered[line.ItemID] += line.Quantity
+	}
+	if len(quoted) != len(ordered) {
+		return fmt.Errorf("%w: item set differs", ErrQuoteMismatch)
+	}
+	for itemID, qty := range ordered {
+		if quoted[itemID] != qty {
+			return fmt.Errorf("%w: quantity of %s is %d, quoted %d", ErrQuoteMismatch, itemID, qty, quoted[itemID])
+		}
+	}
+	return nil
+}
+
+func (s *QuoteSigner) sign(data string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
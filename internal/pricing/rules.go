@@ -0,0 +1,293 @@
+// internal/pricing/rules.go
+package pricing
+
+import (
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// RulesDataID 是折扣规则在 Nacos 中的 Data ID
+const RulesDataID = "nexus-pricing-rules.yaml"
+
+// 折扣动作类型
+const (
+	ActionPercentOff = "percent_off"
+	ActionFixedOff   = "fixed_off"
+	ActionBuyXGetY   = "buy_x_get_y"
+)
+
+// 用户分群, 除了请求中显式传入的分群外, 每个用户都属于 vip 或 regular 之一
+const (
+	SegmentVIP     = "vip"
+	SegmentRegular = "regular"
+)
+
+// RulesConfig 是 nexus-pricing-rules.yaml 的结构
+type RulesConfig struct {
+	Rules []DiscountRule `yaml:"rules"`
+}
+
+// DiscountRule 是一条声明式折扣规则。
+// 规则按 Priority 从小到大依次评估; 同一个 Group 内最多只有一条规则生效;
+// Exclusive 的规则生效后, 后续规则都不再评估。
+type DiscountRule struct {
+	ID        string     `yaml:"id" json:"id"`
+	Name      string     `yaml:"name" json:"name"`
+	Enabled   *bool      `yaml:"enabled" json:"-"`
+	Priority  int        `yaml:"priority" json:"priority"`
+	Group     string     `yaml:"group" json:"group,omitempty"`
+	Exclusive bool       `yaml:"exclusive" json:"exclusive,omitempty"`
+	When      Conditions `yaml:"when" json:"-"`
+	Action    Action     `yaml:"action" json:"-"`
+}
+
+// Conditions 是规则生效的条件, 未填写的条件视为满足。金额均以价格目录的基准货币计。
+type Conditions struct {
+	Segments     []string  `yaml:"segments"`
+	MinCartTotal string    `yaml:"minCartTotal"`
+	Categories   []string  `yaml:"categories"` // 只有这些分类的商品参与折扣
+	Items        []string  `yaml:"items"`      // 只有这些 SKU 参与折扣
+	StartAt      time.Time `yaml:"startAt"`
+	EndAt        time.Time `yaml:"endAt"`
+
+	minCartTotal money.Money
+}
+
+// Action 是规则生效后执行的折扣动作
+type Action struct {
+	Type        string  `yaml:"type"`
+	Percent     float64 `yaml:"percent"`     // percent_off: 折扣百分比, 10 表示减 10%
+	Amount      string  `yaml:"amount"`      // fixed_off: 从参与折扣的商品中减去的金额
+	BuyQuantity int64   `yaml:"buyQuantity"` // buy_x_get_y: 每买 X 件
+	GetQuantity int64   `yaml:"getQuantity"` // buy_x_get_y: 送 Y 件
+
+	amount money.Money
+}
+
+// AppliedRule 是计价结果中生效的一条规则
+type AppliedRule struct {
+	RuleID   string      `json:"ruleId"`
+	Name     string      `json:"name"`
+	Action   string      `json:"action"`
+	Discount money.Money `json:"discount"`
+	Items    []string    `json:"items"`
+}
+
+// compile 校验规则, 并把配置中的金额解析为 currency 的精确金额
+func (r *DiscountRule) compile(currency money.Currency) error {
+	if r.ID == "" {
+		return fmt.Errorf("rule without id")
+	}
+	r.When.minCartTotal = money.Zero(currency)
+	if r.When.MinCartTotal != "" {
+		m, err := money.Parse(r.When.MinCartTotal, currency)
+		if err != nil {
+			return fmt.Errorf("rule %s: minCartTotal: %w", r.ID, err)
+		}
+		r.When.minCartTotal = m
+	}
+	switch r.Action.Type {
+	case ActionPercentOff:
+		if r.Action.Percent <= 0 || r.Action.Percent > 100 {
+			return fmt.Errorf("rule %s: percent must be in (0, 100]", r.ID)
+		}
+	case ActionFixedOff:
+		m, err := money.Parse(r.Action.Amount, currency)
+		if err != nil {
+			return fmt.Errorf("rule %s: amount: %w", r.ID, err)
+		}
+		if !m.IsPositive() {
+			return fmt.Errorf("rule %s: amount must be positive", r.ID)
+		}
+		r.Action.amount = m
+	case ActionBuyXGetY:
+		if r.Action.BuyQuantity <= 0 || r.Action.GetQuantity <= 0 {
+			return fmt.Errorf("rule %s: buyQuantity and getQuantity must be positive", r.ID)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown action type %q", r.ID, r.Action.Type)
+	}
+	return nil
+}
+
+// RuleSet 持有当前生效的折扣规则, 支持热更新
+type RuleSet struct {
+	currency money.Currency
+	rules    atomic.Pointer[[]DiscountRule]
+}
+
+// NewRuleSet 创建一个空的规则集, 规则中的金额按 currency 解析
+func NewRuleSet(currency money.Currency) *RuleSet {
+	rs := &RuleSet{currency: currency}
+	rs.rules.Store(&[]DiscountRule{})
+	return rs
+}
+
+// Update 用新的配置替换当前规则。有任何一条规则不合法时整份配置都不生效。
+func (rs *RuleSet) Update(cfg RulesConfig) {
+	rules := slices.Clone(cfg.Rules)
+	for i := range rules {
+		if err := rules[i].compile(rs.currency); err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid discount rule, keeping previous rules: %v", err)
+			return
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	rs.rules.Store(&rules)
+	logger.Logger.Printf("✅ Discount rules applied: %d rule(s)", len(rules))
+}
+
+// Rules 返回当前生效的规则, 已按优先级排序
+func (rs *RuleSet) Rules() []DiscountRule {
+	return *rs.rules.Load()
+}
+
+// ruleContext 是评估规则时需要的上下文
+type ruleContext struct {
+	segments   []string
+	categories map[string]string // sku -> category
+	now        time.Time
+}
+
+// RuleEvaluation 记录一条规则的评估结果, 用于解释计价过程
+type RuleEvaluation struct {
+	RuleID   string
+	Outcome  string // applied / rejected / skipped
+	Reason   string
+	Discount money.Money
+}
+
+// applyRules 按优先级依次评估规则, 把折扣记到各行上, 返回生效的规则和每条规则的评估结果
+func applyRules(rules []DiscountRule, rc ruleContext, result *PriceResult) ([]AppliedRule, []RuleEvaluation) {
+	applied := []AppliedRule{}
+	evaluations := make([]RuleEvaluation, 0, len(rules))
+	usedGroups := make(map[string]string)
+	exclusiveBy := ""
+	for _, rule := range rules {
+		reject := func(outcome, reason string) {
+			evaluations = append(evaluations, RuleEvaluation{RuleID: rule.ID, Outcome: outcome, Reason: reason})
+		}
+		if exclusiveBy != "" {
+			reject(OutcomeSkipped, fmt.Sprintf("exclusive rule %s already applied", exclusiveBy))
+			continue
+		}
+		if rule.Enabled != nil && !*rule.Enabled {
+			reject(OutcomeSkipped, "disabled")
+			continue
+		}
+		if winner, taken := usedGroups[rule.Group]; rule.Group != "" && taken {
+			reject(OutcomeRejected, fmt.Sprintf("group %s already taken by %s", rule.Group, winner))
+			continue
+		}
+		if reason, ok := rule.matches(rc, result.Subtotal); !ok {
+			reject(OutcomeRejected, reason)
+			continue
+		}
+		eligible := rule.eligibleLines(rc, result.LineItems)
+		if len(eligible) == 0 {
+			reject(OutcomeRejected, "no eligible items in cart")
+			continue
+		}
+
+		discount, items := rule.apply(result.LineItems, eligible)
+		if !discount.IsPositive() {
+			reject(OutcomeRejected, "rule produced no discount")
+			continue
+		}
+		applied = append(applied, AppliedRule{
+			RuleID: rule.ID, Name: rule.Name, Action: rule.Action.Type, Discount: discount, Items: items,
+		})
+		evaluations = append(evaluations, RuleEvaluation{
+			RuleID: rule.ID, Outcome: OutcomeApplied, Reason: fmt.Sprintf("%s on %v", rule.Action.Type, items), Discount: discount,
+		})
+		if rule.Group != "" {
+			usedGroups[rule.Group] = rule.ID
+		}
+		if rule.Exclusive {
+			exclusiveBy = rule.ID
+		}
+	}
+	return applied, evaluations
+}
+
+// matches 检查与具体商品无关的条件, 不满足时返回原因
+func (r *DiscountRule) matches(rc ruleContext, cartTotal money.Money) (string, bool) {
+	if len(r.When.Segments) > 0 && !slices.ContainsFunc(r.When.Segments, func(s string) bool { return slices.Contains(rc.segments, s) }) {
+		return "segment not matched", false
+	}
+	if r.When.minCartTotal.IsPositive() && cartTotal.Cmp(r.When.minCartTotal) < 0 {
+		return fmt.Sprintf("cart total %s below %s", cartTotal, r.When.minCartTotal), false
+	}
+	if !r.When.StartAt.IsZero() && rc.now.Before(r.When.StartAt) {
+		return "not started yet", false
+	}
+	if !r.When.EndAt.IsZero() && !rc.now.Before(r.When.EndAt) {
+		return "already ended", false
+	}
+	return "", true
+}
+
+// eligibleLines 返回参与折扣的行下标
+func (r *DiscountRule) eligibleLines(rc ruleContext, lines []LineItem) []int {
+	var idx []int
+	for i, line := range lines {
+		if len(r.When.Items) > 0 && !slices.Contains(r.When.Items, line.ItemID) {
+			continue
+		}
+		if len(r.When.Categories) > 0 && !slices.Contains(r.When.Categories, rc.categories[line.ItemID]) {
+			continue
+		}
+		if !line.Total.Sub(line.Discount).IsPositive() {
+			continue
+		}
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// apply 执行折扣动作, 折扣不会让任何一行的金额低于 0
+func (r *DiscountRule) apply(lines []LineItem, eligible []int) (money.Money, []string) {
+	total := money.Zero(lines[eligible[0]].Total.Currency())
+	var items []string
+	remaining := func(i int) money.Money { return lines[i].Total.Sub(lines[i].Discount) }
+	take := func(i int, amount money.Money) {
+		amount = money.Min(amount, remaining(i))
+		if !amount.IsPositive() {
+			return
+		}
+		lines[i].Discount = lines[i].Discount.Add(amount)
+		total = total.Add(amount)
+		items = append(items, lines[i].ItemID)
+	}
+
+	switch r.Action.Type {
+	case ActionPercentOff:
+		for _, i := range eligible {
+			take(i, remaining(i).Percent(r.Action.Percent))
+		}
+	case ActionFixedOff:
+		// 固定金额按各行剩余金额的比例分摊, 各份之和严格等于优惠金额
+		weights := make([]int64, len(eligible))
+		base := money.Zero(total.Currency())
+		for n, i := range eligible {
+			weights[n] = remaining(i).Minor()
+			base = base.Add(remaining(i))
+		}
+		for n, share := range money.Min(r.Action.amount, base).Allocate(weights) {
+			take(eligible[n], share)
+		}
+	case ActionBuyXGetY:
+		group := r.Action.BuyQuantity + r.Action.GetQuantity
+		for _, i := range eligible {
+			if free := lines[i].Quantity / group * r.Action.GetQuantity; free > 0 {
+				take(i, lines[i].UnitPrice.Mul(free))
+			}
+		}
+	}
+	return total, items
+}
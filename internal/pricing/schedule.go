@@ -0,0 +1,220 @@
+// internal/pricing/schedule.go
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"nexus/internal/money"
+	"time"
+)
+
+// ErrInvalidPriceEntry 排期价格不合法
+var ErrInvalidPriceEntry = errors.New("invalid price entry")
+
+// 排期价格的种类, 分别覆盖基础价、数量阶梯价和价目表价格
+const (
+	EntryKindBase = "base"
+	EntryKindTier = "tier"
+	EntryKindList = "list"
+)
+
+// scheduleClockSkew 是允许的时钟偏差, 生效时间早于 "现在" 超过这个值的排期会被拒绝
+const scheduleClockSkew = time.Minute
+
+// PriceEntry 是一条带生效时间的价格。
+// 价格记录只增不改: 新的排期覆盖时间上重叠的旧排期 (id 更大的优先), 旧记录永久保留,
+// 并且不允许排期到过去, 因此任意历史时刻生效的排期价格都可以查出来。
+// 静态目录价和其它计价配置没有版本, 订单的成交价格以计价快照 (PriceSnapshot) 为准。
+type PriceEntry struct {
+	ID            int64       `json:"id"`
+	SKU           string      `json:"sku"`
+	Kind          string      `json:"kind"`
+	ListCode      string      `json:"listCode,omitempty"`
+	MinQuantity   int64       `json:"minQuantity,omitempty"`
+	UnitPrice     money.Money `json:"unitPrice"`
+	EffectiveFrom time.Time   `json:"effectiveFrom"`
+	EffectiveTo   *time.Time  `json:"effectiveTo,omitempty"`
+	Note          string      `json:"note,omitempty"`
+	CreatedAt     time.Time   `json:"createdAt"`
+}
+
+// Schedule 校验并写入一条排期价格, 写入后让该 SKU 的缓存失效
+func (c *Catalog) Schedule(ctx context.Context, e PriceEntry) (*PriceEntry, error) {
+	if err := c.validateEntry(ctx, &e); err != nil {
+		return nil, err
+	}
+	res, err := c.db.ExecContext(ctx,
+		`INSERT INTO price_entry (sku, kind, list_code, min_quantity, unit_price, effective_from, effective_to, note)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.SKU, e.Kind, e.ListCode, e.MinQuantity, e.UnitPrice.Decimal(), e.EffectiveFrom, e.EffectiveTo, e.Note)
+	if err != nil {
+		return nil, fmt.Errorf("insert price entry: %w", err)
+	}
+	e.ID, _ = res.LastInsertId()
+	e.CreatedAt = time.Now()
+	c.Invalidate(e.SKU)
+	return &e, nil
+}
+
+func (c *Catalog) validateEntry(ctx context.Context, e *PriceEntry) error {
+	if e.UnitPrice.Currency().Code != c.currency.Code {
+		return fmt.Errorf("%w: unit price must be in %s", ErrInvalidPriceEntry, c.currency.Code)
+	}
+	if e.UnitPrice.IsNegative() {
+		return fmt.Errorf("%w: unit price must not be negative", ErrInvalidPriceEntry)
+	}
+	switch e.Kind {
+	case EntryKindBase:
+		e.ListCode, e.MinQuantity = "", 0
+	case EntryKindTier:
+		if e.MinQuantity <= 1 {
+			return fmt.Errorf("%w: tier needs minQuantity above 1", ErrInvalidPriceEntry)
+		}
+		e.ListCode = ""
+	case EntryKindList:
+		if e.ListCode == "" {
+			return fmt.Errorf("%w: list price needs a listCode", ErrInvalidPriceEntry)
+		}
+		e.MinQuantity = 0
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidPriceEntry, e.Kind)
+	}
+	if e.EffectiveFrom.IsZero() {
+		e.EffectiveFrom = time.Now()
+	}
+	if e.EffectiveFrom.Before(time.Now().Add(-scheduleClockSkew)) {
+		return fmt.Errorf("%w: effectiveFrom is in the past, historical prices cannot be rewritten", ErrInvalidPriceEntry)
+	}
+	if e.EffectiveTo != nil && !e.EffectiveTo.After(e.EffectiveFrom) {
+		return fmt.Errorf("%w: effectiveTo must be after effectiveFrom", ErrInvalidPriceEntry)
+	}
+	// 只允许给目录中存在的 SKU 排期
+	if _, err := c.Get(ctx, []string{e.SKU}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// History 按生效时间返回某个 SKU 的全部排期价格, 包括已经失效的
+func (c *Catalog) History(ctx context.Context, sku string) ([]PriceEntry, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, sku, kind, list_code, min_quantity, unit_price, effective_from, effective_to, note, created_at
+		 FROM price_entry WHERE sku = ? ORDER BY effective_from, id`, sku)
+	if err != nil {
+		return nil, fmt.Errorf("query price history: %w", err)
+	}
+	defer rows.Close()
+	entries := []PriceEntry{}
+	for rows.Next() {
+		var (
+			e     PriceEntry
+			price string
+			to    sql.NullTime
+		)
+		if err := rows.Scan(&e.ID, &e.SKU, &e.Kind, &e.ListCode, &e.MinQuantity, &price,
+			&e.EffectiveFrom, &to, &e.Note, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan price entry: %w", err)
+		}
+		if e.UnitPrice, err = money.Parse(price, c.currency); err != nil {
+			return nil, fmt.Errorf("price entry %d: %w", e.ID, err)
+		}
+		if to.Valid {
+			e.EffectiveTo = &to.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// applyScheduled 用 at 时刻生效的排期价格覆盖静态价格:
+// 基础价和每个价目表各自取 id 最大的生效记录; 只要有生效的阶梯价记录, 就整体替换静态阶梯。
+// 同时记录下一次价格变化的时间, 供缓存决定何时过期。
+func (c *Catalog) applyScheduled(ctx context.Context, entries map[string]*CatalogEntry, placeholders string, args []any, at time.Time) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	queryArgs := append(append([]any{}, args...), at, at)
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT sku, kind, list_code, min_quantity, unit_price, effective_to FROM price_entry
+		 WHERE sku IN (`+placeholders+`) AND effective_from <= ? AND (effective_to IS NULL OR effective_to > ?)
+		 ORDER BY id`, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("query scheduled prices: %w", err)
+	}
+	defer rows.Close()
+
+	tiers := make(map[string]map[int64]money.Money)
+	for rows.Next() {
+		var (
+			sku, kind, listCode, price string
+			minQuantity                int64
+			to                         sql.NullTime
+		)
+		if err := rows.Scan(&sku, &kind, &listCode, &minQuantity, &price, &to); err != nil {
+			return fmt.Errorf("scan scheduled price: %w", err)
+		}
+		e, ok := entries[sku]
+		if !ok {
+			continue
+		}
+		m, err := money.Parse(price, c.currency)
+		if err != nil {
+			return fmt.Errorf("scheduled price of %s: %w", sku, err)
+		}
+		switch kind {
+		case EntryKindBase:
+			e.BasePrice = m
+		case EntryKindTier:
+			if tiers[sku] == nil {
+				tiers[sku] = make(map[int64]money.Money)
+			}
+			tiers[sku][minQuantity] = m
+		case EntryKindList:
+			e.Lists[listCode] = m
+		}
+		if to.Valid {
+			e.validUntil = earliest(e.validUntil, to.Time)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for sku, byQty := range tiers {
+		e := entries[sku]
+		e.Tiers = e.Tiers[:0]
+		for qty, price := range byQty {
+			e.Tiers = append(e.Tiers, Tier{MinQuantity: qty, UnitPrice: price})
+		}
+	}
+
+	// 尚未生效的排期中最早的一条, 到时缓存必须过期
+	rows, err = c.db.QueryContext(ctx,
+		`SELECT sku, MIN(effective_from) FROM price_entry WHERE sku IN (`+placeholders+`) AND effective_from > ? GROUP BY sku`,
+		append(append([]any{}, args...), at)...)
+	if err != nil {
+		return fmt.Errorf("query upcoming prices: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			sku  string
+			next time.Time
+		)
+		if err := rows.Scan(&sku, &next); err != nil {
+			return fmt.Errorf("scan upcoming price: %w", err)
+		}
+		if e, ok := entries[sku]; ok {
+			e.validUntil = earliest(e.validUntil, next)
+		}
+	}
+	return rows.Err()
+}
+
+func earliest(current, t time.Time) time.Time {
+	if current.IsZero() || t.Before(current) {
+		return t
+	}
+	return current
+}
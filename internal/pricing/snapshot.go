@@ -0,0 +1,118 @@
+// internal/pricing/snapshot.go
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrSnapshotNotFound 没有对应的计价快照
+	ErrSnapshotNotFound = errors.New("price snapshot not found")
+	// ErrSnapshotBound 报价已经绑定到另一个订单, 或订单已经绑定了另一份报价
+	ErrSnapshotBound = errors.New("price snapshot is already bound")
+)
+
+// PriceSnapshot 是签发报价时保存的完整计价结果。
+// 折扣规则、税率、价格实验和动态定价上下限都只保留当前配置, 按历史时刻重新计价无法精确复原;
+// 订单和退款应当以下单时绑定的快照为准。
+type PriceSnapshot struct {
+	QuoteID   string       `json:"quoteId"`
+	OrderID   string       `json:"orderId,omitempty"`
+	UserID    string       `json:"userId,omitempty"`
+	Result    *PriceResult `json:"result"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// SnapshotStore 把计价快照保存在 price_snapshot 表中, 快照写入后不再修改, 只会绑定一次订单号
+type SnapshotStore struct {
+	db *sql.DB
+}
+
+// NewSnapshotStore 创建计价快照存储
+func NewSnapshotStore(db *sql.DB) *SnapshotStore {
+	return &SnapshotStore{db: db}
+}
+
+// Save 保存一次已签发报价的计价结果。报价令牌本身不落库, 持有快照的人不能再用它下单。
+func (s *SnapshotStore) Save(ctx context.Context, userID string, result *PriceResult) error {
+	if result.QuoteID == "" {
+		return fmt.Errorf("save price snapshot: result has no quote")
+	}
+	stored := *result
+	stored.QuoteToken = ""
+	payload, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("marshal price snapshot: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO price_snapshot (quote_id, user_id, result, priced_at) VALUES (?, ?, ?, ?)`,
+		result.QuoteID, userID, payload, result.PricedAt); err != nil {
+		return fmt.Errorf("insert price snapshot: %w", err)
+	}
+	return nil
+}
+
+// Bind 把报价的快照绑定到订单。同一个订单重复绑定是幂等的;
+// 报价已绑定到其它订单, 或订单已绑定了另一份报价时返回 ErrSnapshotBound (uk_order_id 兜底并发的情况)。
+func (s *SnapshotStore) Bind(ctx context.Context, quoteID, orderID string) error {
+	if bound, err := s.ByOrder(ctx, orderID); err == nil && bound.QuoteID != quoteID {
+		return fmt.Errorf("%w: order %s uses quote %s", ErrSnapshotBound, orderID, bound.QuoteID)
+	} else if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE price_snapshot SET order_id = ? WHERE quote_id = ? AND (order_id IS NULL OR order_id = ?)`,
+		orderID, quoteID, orderID)
+	if err != nil {
+		return fmt.Errorf("bind price snapshot: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	// 没有更新任何行: 快照不存在, 或者已经绑定了别的订单 (绑定同一订单时 MySQL 也报告 0 行变化)
+	snapshot, err := s.get(ctx, `quote_id = ?`, quoteID)
+	if err != nil {
+		return err
+	}
+	if snapshot.OrderID != orderID {
+		return fmt.Errorf("%w: quote %s belongs to order %s", ErrSnapshotBound, quoteID, snapshot.OrderID)
+	}
+	return nil
+}
+
+// ByOrder 返回订单绑定的计价快照
+func (s *SnapshotStore) ByOrder(ctx context.Context, orderID string) (*PriceSnapshot, error) {
+	return s.get(ctx, `order_id = ?`, orderID)
+}
+
+// ByQuote 返回报价的计价快照
+func (s *SnapshotStore) ByQuote(ctx context.Context, quoteID string) (*PriceSnapshot, error) {
+	return s.get(ctx, `quote_id = ?`, quoteID)
+}
+
+func (s *SnapshotStore) get(ctx context.Context, where string, arg any) (*PriceSnapshot, error) {
+	var (
+		snapshot PriceSnapshot
+		orderID  sql.NullString
+		payload  []byte
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT quote_id, order_id, user_id, result, created_at FROM price_snapshot WHERE `+where, arg).
+		Scan(&snapshot.QuoteID, &orderID, &snapshot.UserID, &payload, &snapshot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotNotFound, arg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query price snapshot: %w", err)
+	}
+	snapshot.OrderID = orderID.String
+	if err := json.Unmarshal(payload, &snapshot.Result); err != nil {
+		return nil, fmt.Errorf("decode price snapshot %s: %w", snapshot.QuoteID, err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,193 @@
+// internal/pricing/tax.go
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// TaxDataID 是税率规则在 Nacos 中的 Data ID
+const TaxDataID = "nexus-pricing-tax.yaml"
+
+// ErrUnknownTaxRegion 没有为目的地区配置税率, 且没有默认地区
+var ErrUnknownTaxRegion = errors.New("unknown tax region")
+
+// 计税方式
+const (
+	TaxInclusive = "inclusive" // 价格已含税, 税额从价格中拆出
+	TaxExclusive = "exclusive" // 价格不含税, 税额加在价格之上
+)
+
+// 税率名称
+const (
+	TaxRateStandard = "standard"
+	TaxRateExempt   = "exempt"
+)
+
+// TaxConfig 是 nexus-pricing-tax.yaml 的结构
+type TaxConfig struct {
+	// DefaultRegion 是请求未指定目的地区时使用的地区
+	DefaultRegion string               `yaml:"defaultRegion"`
+	Regions       map[string]TaxRegion `yaml:"regions"`
+}
+
+// TaxRegion 是一个地区的税率规则。地区代码使用 ISO 3166, 例如 CN、DE、US-CA,
+// 与 shipping-service 的目的地区一致; 查找时先精确匹配, 再退回到国家代码。
+type TaxRegion struct {
+	Mode         string        `yaml:"mode"`
+	StandardRate float64       `yaml:"standardRate"`
+	Rates        []CategoryTax `yaml:"rates"`
+}
+
+// CategoryTax 为若干商品分类指定低税率或免税
+type CategoryTax struct {
+	Name       string   `yaml:"name"`
+	Categories []string `yaml:"categories"`
+	Rate       float64  `yaml:"rate"`
+	Exempt     bool     `yaml:"exempt"`
+}
+
+// TaxLine 是按税率汇总的税额
+type TaxLine struct {
+	Rate    string      `json:"rate"`
+	Percent float64     `json:"percent"`
+	Taxable money.Money `json:"taxable"` // 不含税的计税金额
+	Tax     money.Money `json:"tax"`
+}
+
+// TaxRules 持有当前生效的税率规则, 支持热更新
+type TaxRules struct {
+	cfg atomic.Pointer[TaxConfig]
+}
+
+// NewTaxRules 创建一个空的税率规则, 没有配置时不计税
+func NewTaxRules() *TaxRules {
+	t := &TaxRules{}
+	t.cfg.Store(&TaxConfig{})
+	return t
+}
+
+// Update 用新的配置替换当前税率规则。有任何一个地区不合法时整份配置都不生效。
+func (t *TaxRules) Update(cfg TaxConfig) {
+	for code, region := range cfg.Regions {
+		if region.Mode != TaxInclusive && region.Mode != TaxExclusive {
+			logger.Logger.Printf("❌ ERROR: Invalid tax mode %q for region %s, keeping previous rules", region.Mode, code)
+			return
+		}
+		if !validTaxPercent(region.StandardRate) {
+			logger.Logger.Printf("❌ ERROR: Standard tax rate %v for region %s is outside 0-100, keeping previous rules", region.StandardRate, code)
+			return
+		}
+		for _, rate := range region.Rates {
+			if rate.Name == "" || len(rate.Categories) == 0 {
+				logger.Logger.Printf("❌ ERROR: Tax rate in region %s needs a name and categories, keeping previous rules", code)
+				return
+			}
+			if !validTaxPercent(rate.Rate) {
+				logger.Logger.Printf("❌ ERROR: Tax rate %s %v in region %s is outside 0-100, keeping previous rules", rate.Name, rate.Rate, code)
+				return
+			}
+		}
+	}
+	if cfg.DefaultRegion != "" {
+		if _, ok := cfg.Regions[cfg.DefaultRegion]; !ok {
+			logger.Logger.Printf("❌ ERROR: Default tax region %s is not configured, keeping previous rules", cfg.DefaultRegion)
+			return
+		}
+	}
+	t.cfg.Store(&cfg)
+	logger.Logger.Printf("✅ Tax rules applied: %d region(s), default %q", len(cfg.Regions), cfg.DefaultRegion)
+}
+
+// validTaxPercent 税率是百分比, 必须在 0 到 100 之间 (NaN 同样不合法)
+func validTaxPercent(percent float64) bool {
+	return percent >= 0 && percent <= 100
+}
+
+// Enabled 是否配置了任何税率
+func (t *TaxRules) Enabled() bool {
+	return len(t.cfg.Load().Regions) > 0
+}
+
+// region 返回目的地区适用的税率规则及其代码
+func (t *TaxRules) region(code string) (string, TaxRegion, error) {
+	cfg := t.cfg.Load()
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		code = cfg.DefaultRegion
+	}
+	if region, ok := cfg.Regions[code]; ok {
+		return code, region, nil
+	}
+	if country, _, ok := strings.Cut(code, "-"); ok {
+		if region, ok := cfg.Regions[country]; ok {
+			return country, region, nil
+		}
+	}
+	return "", TaxRegion{}, fmt.Errorf("%w: %q", ErrUnknownTaxRegion, code)
+}
+
+// rateFor 返回商品分类适用的税率名称和百分比
+func (r TaxRegion) rateFor(category string) (string, float64) {
+	for _, rate := range r.Rates {
+		if slices.Contains(rate.Categories, category) {
+			if rate.Exempt {
+				return TaxRateExempt, 0
+			}
+			return rate.Name, rate.Rate
+		}
+	}
+	return TaxRateStandard, r.StandardRate
+}
+
+// applyTax 对折后金额逐行计税, 并按税率汇总
+func applyTax(region TaxRegion, categories map[string]string, result *PriceResult) {
+	inclusive := region.Mode == TaxInclusive
+	zero := money.Zero(result.Subtotal.Currency())
+	byRate := make(map[string]*TaxLine)
+	result.Tax = zero
+
+	for i := range result.LineItems {
+		line := &result.LineItems[i]
+		name, percent := region.rateFor(categories[line.ItemID])
+		net := line.Total.Sub(line.Discount)
+
+		tax, taxable := zero, net
+		if percent > 0 {
+			if inclusive {
+				tax = net.IncludedPercent(percent)
+				taxable = net.Sub(tax)
+			} else {
+				tax = net.Percent(percent)
+			}
+		}
+		line.TaxRate, line.TaxPercent, line.Tax, line.TaxIncluded = name, percent, tax, inclusive
+		result.Tax = result.Tax.Add(tax)
+
+		agg, ok := byRate[name]
+		if !ok {
+			agg = &TaxLine{Rate: name, Percent: percent, Taxable: zero, Tax: zero}
+			byRate[name] = agg
+		}
+		agg.Taxable = agg.Taxable.Add(taxable)
+		agg.Tax = agg.Tax.Add(tax)
+	}
+
+	result.TaxBreakdown = make([]TaxLine, 0, len(byRate))
+	for _, agg := range byRate {
+		result.TaxBreakdown = append(result.TaxBreakdown, *agg)
+	}
+	sort.Slice(result.TaxBreakdown, func(i, j int) bool {
+		a, b := result.TaxBreakdown[i], result.TaxBreakdown[j]
+		if a.Percent != b.Percent {
+			return a.Percent > b.Percent
+		}
+		return a.Rate < b.Rate
+	})
+}
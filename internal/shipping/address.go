@@ -0,0 +1,272 @@
+// internal/shipping/address.go
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidAddress 地址缺少必填字段或格式不合法, 具体的字段见 AddressError
+var ErrInvalidAddress = errors.New("invalid address")
+
+// 地址字段错误的类型
+const (
+	AddressRequired       = "required"
+	AddressInvalidFormat  = "invalid_format"
+	AddressUnknownCountry = "unknown_country"
+	AddressUnknownState   = "unknown_state"
+	AddressTooLong        = "too_long"
+)
+
+// maxAddressField 是每个地址字段的最大字符数
+const maxAddressField = 128
+
+var phonePattern = regexp.MustCompile(`^\+?\d{6,15}$`)
+
+// Address 是收发货地址
+type Address struct {
+	Name       string `json:"name"`
+	Phone      string `json:"phone,omitempty"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Country    string `json:"country"`
+}
+
+// Region 返回地址所在的地区代码 (ISO 3166): 有省/州时为 CN-SH 形式, 否则只有国家代码
+func (a Address) Region() string {
+	country := strings.ToUpper(strings.TrimSpace(a.Country))
+	if state := strings.ToUpper(strings.TrimSpace(a.State)); state != "" {
+		return country + "-" + state
+	}
+	return country
+}
+
+// FieldError 是一个地址字段的错误, Field 是 JSON 字段名
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Correction 记录规范化时改写过的字段
+type Correction struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// AddressError 是地址校验失败的错误, Field 指明是哪个地址 (例如 to)
+type AddressError struct {
+	Field  string
+	Errors []FieldError
+}
+
+func (e *AddressError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		parts = append(parts, fe.Field+": "+fe.Message)
+	}
+	return fmt.Sprintf("%v (%s): %s", ErrInvalidAddress, e.Field, strings.Join(parts, "; "))
+}
+
+func (e *AddressError) Unwrap() error { return ErrInvalidAddress }
+
+// AddressValidation 是地址校验的结果: 规范化后的地址、字段错误和对应的运费区域
+type AddressValidation struct {
+	Valid       bool         `json:"valid"`
+	Address     Address      `json:"address"`
+	Region      string       `json:"region"`
+	Zone        string       `json:"zone,omitempty"`
+	Serviceable bool         `json:"serviceable"` // 地区在费率表中有对应的运费区域
+	Errors      []FieldError `json:"errors,omitempty"`
+	Corrections []Correction `json:"corrections,omitempty"`
+}
+
+// ValidateAddress 规范化并校验地址, 地址合法时按费率表映射到运费区域
+func ValidateAddress(a Address, rates *RateTable) AddressValidation {
+	normalized, corrections, errs := NormalizeAddress(a)
+	v := AddressValidation{
+		Valid:       len(errs) == 0,
+		Address:     normalized,
+		Region:      normalized.Region(),
+		Errors:      errs,
+		Corrections: corrections,
+	}
+	if v.Valid {
+		if zone, err := rates.Zone(v.Region); err == nil {
+			v.Zone, v.Serviceable = zone, true
+		}
+	}
+	return v
+}
+
+// NormalizeAddress 按国家/地区的格式规范化地址: 国家和省/州转为代码, 统一大小写、缩写和邮编格式,
+// 然后检查必填字段和格式。返回规范化后的地址、改写过的字段和字段错误。
+func NormalizeAddress(a Address) (Address, []Correction, []FieldError) {
+	var errs []FieldError
+	n := Address{
+		Name: collapse(a.Name), Phone: collapse(a.Phone), Line1: collapse(a.Line1), Line2: collapse(a.Line2),
+		City: collapse(a.City), State: collapse(a.State), PostalCode: collapse(a.PostalCode), Country: collapse(a.Country),
+	}
+
+	format := genericFormat
+	if n.Country != "" {
+		country := strings.ToUpper(n.Country)
+		if code, ok := countryAliases[country]; ok {
+			country = code
+		}
+		if f, ok := addressFormats[country]; ok {
+			format = f
+		} else if !isCountryCode(country) {
+			errs = append(errs, FieldError{"country", AddressUnknownCountry, fmt.Sprintf("unknown country %q, use an ISO 3166-1 code", n.Country)})
+		}
+		n.Country = country
+	}
+
+	if n.State != "" && format.states != nil {
+		if code, ok := lookupState(format.states, n.State); ok {
+			n.State = code
+		} else {
+			errs = append(errs, FieldError{"state", AddressUnknownState, fmt.Sprintf("unknown state %q for %s", n.State, n.Country)})
+		}
+	} else {
+		n.State = strings.ToUpper(n.State)
+	}
+
+	n.PostalCode = normalizePostal(n.Country, n.PostalCode)
+	if format.noPostal {
+		n.PostalCode = ""
+	}
+	if n.PostalCode != "" && format.postal != nil && !format.postal.MatchString(n.PostalCode) {
+		errs = append(errs, FieldError{"postalCode", AddressInvalidFormat, fmt.Sprintf("postal code must look like %s", format.postalExample)})
+	}
+
+	if n.Phone != "" {
+		n.Phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(n.Phone)
+		if !phonePattern.MatchString(n.Phone) {
+			errs = append(errs, FieldError{"phone", AddressInvalidFormat, "phone must be 6 to 15 digits with an optional leading +"})
+		}
+	}
+
+	for _, line := range []*string{&n.Line1, &n.Line2, &n.City} {
+		*line = abbreviate(*line, format)
+		if format.upperCase {
+			*line = strings.ToUpper(*line)
+		}
+	}
+
+	fields := addressFields(&n)
+	for _, name := range format.required {
+		if *fields[name] == "" {
+			errs = append(errs, FieldError{name, AddressRequired, name + " is required"})
+		}
+	}
+	for _, name := range addressFieldNames {
+		if utf8.RuneCountInString(*fields[name]) > maxAddressField {
+			errs = append(errs, FieldError{name, AddressTooLong, fmt.Sprintf("%s must be at most %d characters", name, maxAddressField)})
+		}
+	}
+
+	var corrections []Correction
+	original := addressFields(&a)
+	for _, name := range addressFieldNames {
+		if from, to := strings.TrimSpace(*original[name]), *fields[name]; from != to {
+			corrections = append(corrections, Correction{Field: name, From: from, To: to})
+		}
+	}
+	return n, corrections, errs
+}
+
+// addressFieldNames 是地址字段的 JSON 名称, 按地址的书写顺序排列
+var addressFieldNames = []string{"name", "phone", "line1", "line2", "city", "state", "postalCode", "country"}
+
+func addressFields(a *Address) map[string]*string {
+	return map[string]*string{
+		"name": &a.Name, "phone": &a.Phone, "line1": &a.Line1, "line2": &a.Line2,
+		"city": &a.City, "state": &a.State, "postalCode": &a.PostalCode, "country": &a.Country,
+	}
+}
+
+// collapse 去掉首尾空白, 并把连续的空白合并为一个空格
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// stateSuffixes 是中文省级行政区名称的后缀, 匹配名称前去掉
+var stateSuffixes = []string{"壮族自治区", "回族自治区", "维吾尔自治区", "自治区", "特别行政区", "省", "市"}
+
+// lookupState 按代码或名称查找省/州, 返回代码
+func lookupState(states map[string][]string, state string) (string, bool) {
+	key := strings.ToUpper(state)
+	if _, ok := states[key]; ok {
+		return key, true
+	}
+	for _, suffix := range stateSuffixes {
+		if trimmed := strings.TrimSuffix(key, suffix); trimmed != key && trimmed != "" {
+			key = trimmed
+			break
+		}
+	}
+	for code, names := range states {
+		for _, name := range names {
+			if key == name {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizePostal 统一邮编的写法: 美国的 9 位邮编加上连字符, 英国的邮编在最后三位前加空格
+func normalizePostal(country, postal string) string {
+	postal = strings.ToUpper(strings.ReplaceAll(postal, " ", ""))
+	switch country {
+	case "US":
+		if digits := strings.ReplaceAll(postal, "-", ""); len(digits) == 9 {
+			return digits[:5] + "-" + digits[5:]
+		}
+	case "GB":
+		if len(postal) > 3 {
+			return postal[:len(postal)-3] + " " + postal[len(postal)-3:]
+		}
+	}
+	return postal
+}
+
+// abbreviate 把地址行中的完整单词替换为该国家的标准缩写
+func abbreviate(line string, f addressFormat) string {
+	if line == "" || (f.abbreviations == nil && f.suffixes == nil) {
+		return line
+	}
+	words := strings.Fields(line)
+	for i, word := range words {
+		bare := strings.TrimRight(word, ".,")
+		trailing := word[len(bare):]
+		if abbr, ok := f.abbreviations[strings.ToUpper(bare)]; ok {
+			words[i] = abbr + strings.TrimPrefix(trailing, ".")
+			continue
+		}
+		lower := strings.ToLower(bare)
+		for suffix, abbr := range f.suffixes {
+			if strings.HasSuffix(lower, suffix) && len(lower) == len(bare) {
+				prefix := bare[:len(bare)-len(suffix)]
+				if prefix == "" {
+					abbr = strings.ToUpper(abbr[:1]) + abbr[1:]
+				}
+				words[i] = prefix + abbr + strings.TrimPrefix(trailing, ".")
+				break
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,112 @@
+// internal/shipping/address_data.go
+package shipping
+
+import "regexp"
+
+// addressFormat 是一个国家/地区的地址格式
+type addressFormat struct {
+	// required 是必填字段 (JSON 字段名)
+	required []string
+	// postal 是规范化之后邮编的格式, 为 nil 时不校验
+	postal        *regexp.Regexp
+	postalExample string
+	// states 是省/州的代码 (ISO 3166-2 的后半部分) -> 名称, 名称可以有多个写法
+	states map[string][]string
+	// noPostal 为 true 时该地区不使用邮编, 填写的邮编会被去掉
+	noPostal bool
+	// upperCase 为 true 时地址行和城市转为大写 (美国邮政的规范写法)
+	upperCase bool
+	// abbreviations 把地址行中的完整单词替换为标准缩写, 键为大写
+	abbreviations map[string]string
+	// suffixes 把地址行中以这些后缀结尾的单词缩写, 例如德语的 Hauptstraße -> Hauptstr., 键为小写
+	suffixes map[string]string
+}
+
+// genericFormat 用于没有专门格式的国家/地区
+var genericFormat = addressFormat{required: []string{"name", "line1", "city", "country"}}
+
+// countryAliases 把常见的国家写法映射到 ISO 3166-1 代码, 键为大写
+var countryAliases = map[string]string{
+	"CHINA": "CN", "PRC": "CN", "中国": "CN", "中华人民共和国": "CN", "CHN": "CN",
+	"USA": "US", "UNITED STATES": "US", "UNITED STATES OF AMERICA": "US", "AMERICA": "US", "美国": "US",
+	"GERMANY": "DE", "DEUTSCHLAND": "DE", "DEU": "DE", "德国": "DE",
+	"HONG KONG": "HK", "HONGKONG": "HK", "香港": "HK", "HKG": "HK",
+	"SINGAPORE": "SG", "新加坡": "SG", "SGP": "SG",
+	"UNITED KINGDOM": "GB", "UK": "GB", "GREAT BRITAIN": "GB", "英国": "GB", "GBR": "GB",
+}
+
+// usAbbreviations 是美国邮政 (USPS Publication 28) 的常用街道后缀、方位和单元缩写
+var usAbbreviations = map[string]string{
+	"STREET": "ST", "AVENUE": "AVE", "ROAD": "RD", "BOULEVARD": "BLVD", "DRIVE": "DR", "LANE": "LN",
+	"COURT": "CT", "PLACE": "PL", "TERRACE": "TER", "HIGHWAY": "HWY", "PARKWAY": "PKWY", "CIRCLE": "CIR",
+	"SQUARE": "SQ", "EXPRESSWAY": "EXPY", "FREEWAY": "FWY",
+	"NORTH": "N", "SOUTH": "S", "EAST": "E", "WEST": "W",
+	"NORTHEAST": "NE", "NORTHWEST": "NW", "SOUTHEAST": "SE", "SOUTHWEST": "SW",
+	"APARTMENT": "APT", "SUITE": "STE", "BUILDING": "BLDG", "FLOOR": "FL", "ROOM": "RM", "UNIT": "UNIT",
+}
+
+var addressFormats = map[string]addressFormat{
+	"CN": {
+		// 国内快递必须有收件人电话; 邮编不是必填, 填了就要合法
+		required:      []string{"name", "phone", "line1", "city", "state", "country"},
+		postal:        regexp.MustCompile(`^\d{6}$`),
+		postalExample: "200000",
+		states: map[string][]string{
+			"AH": {"安徽", "ANHUI"}, "BJ": {"北京", "BEIJING"}, "CQ": {"重庆", "CHONGQING"}, "FJ": {"福建", "FUJIAN"},
+			"GD": {"广东", "GUANGDONG"}, "GS": {"甘肃", "GANSU"}, "GX": {"广西", "GUANGXI"}, "GZ": {"贵州", "GUIZHOU"},
+			"HA": {"河南", "HENAN"}, "HB": {"湖北", "HUBEI"}, "HE": {"河北", "HEBEI"}, "HI": {"海南", "HAINAN"},
+			"HL": {"黑龙江", "HEILONGJIANG"}, "HN": {"湖南", "HUNAN"}, "JL": {"吉林", "JILIN"}, "JS": {"江苏", "JIANGSU"},
+			"JX": {"江西", "JIANGXI"}, "LN": {"辽宁", "LIAONING"}, "NM": {"内蒙古", "NEI MONGOL", "INNER MONGOLIA"},
+			"NX": {"宁夏", "NINGXIA"}, "QH": {"青海", "QINGHAI"}, "SC": {"四川", "SICHUAN"}, "SD": {"山东", "SHANDONG"},
+			"SH": {"上海", "SHANGHAI"}, "SN": {"陕西", "SHAANXI"}, "SX": {"山西", "SHANXI"}, "TJ": {"天津", "TIANJIN"},
+			"XJ": {"新疆", "XINJIANG"}, "XZ": {"西藏", "XIZANG", "TIBET"}, "YN": {"云南", "YUNNAN"}, "ZJ": {"浙江", "ZHEJIANG"},
+		},
+	},
+	"US": {
+		required:      []string{"name", "line1", "city", "state", "postalCode", "country"},
+		postal:        regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+		postalExample: "94105 或 94105-1234",
+		upperCase:     true,
+		abbreviations: usAbbreviations,
+		states: map[string][]string{
+			"AL": {"ALABAMA"}, "AK": {"ALASKA"}, "AZ": {"ARIZONA"}, "AR": {"ARKANSAS"}, "CA": {"CALIFORNIA"},
+			"CO": {"COLORADO"}, "CT": {"CONNECTICUT"}, "DE": {"DELAWARE"}, "DC": {"DISTRICT OF COLUMBIA"},
+			"FL": {"FLORIDA"}, "GA": {"GEORGIA"}, "HI": {"HAWAII"}, "ID": {"IDAHO"}, "IL": {"ILLINOIS"},
+			"IN": {"INDIANA"}, "IA": {"IOWA"}, "KS": {"KANSAS"}, "KY": {"KENTUCKY"}, "LA": {"LOUISIANA"},
+			"ME": {"MAINE"}, "MD": {"MARYLAND"}, "MA": {"MASSACHUSETTS"}, "MI": {"MICHIGAN"}, "MN": {"MINNESOTA"},
+			"MS": {"MISSISSIPPI"}, "MO": {"MISSOURI"}, "MT": {"MONTANA"}, "NE": {"NEBRASKA"}, "NV": {"NEVADA"},
+			"NH": {"NEW HAMPSHIRE"}, "NJ": {"NEW JERSEY"}, "NM": {"NEW MEXICO"}, "NY": {"NEW YORK"},
+			"NC": {"NORTH CAROLINA"}, "ND": {"NORTH DAKOTA"}, "OH": {"OHIO"}, "OK": {"OKLAHOMA"}, "OR": {"OREGON"},
+			"PA": {"PENNSYLVANIA"}, "RI": {"RHODE ISLAND"}, "SC": {"SOUTH CAROLINA"}, "SD": {"SOUTH DAKOTA"},
+			"TN": {"TENNESSEE"}, "TX": {"TEXAS"}, "UT": {"UTAH"}, "VT": {"VERMONT"}, "VA": {"VIRGINIA"},
+			"WA": {"WASHINGTON"}, "WV": {"WEST VIRGINIA"}, "WI": {"WISCONSIN"}, "WY": {"WYOMING"},
+		},
+	},
+	"DE": {
+		required:      []string{"name", "line1", "city", "postalCode", "country"},
+		postal:        regexp.MustCompile(`^\d{5}$`),
+		postalExample: "10115",
+		suffixes:      map[string]string{"strasse": "str.", "straße": "str."},
+		states: map[string][]string{
+			"BW": {"BADEN-WÜRTTEMBERG", "BADEN-WUERTTEMBERG"}, "BY": {"BAYERN", "BAVARIA"}, "BE": {"BERLIN"},
+			"BB": {"BRANDENBURG"}, "HB": {"BREMEN"}, "HH": {"HAMBURG"}, "HE": {"HESSEN", "HESSE"},
+			"MV": {"MECKLENBURG-VORPOMMERN"}, "NI": {"NIEDERSACHSEN", "LOWER SAXONY"},
+			"NW": {"NORDRHEIN-WESTFALEN", "NORTH RHINE-WESTPHALIA"}, "RP": {"RHEINLAND-PFALZ"}, "SL": {"SAARLAND"},
+			"SN": {"SACHSEN", "SAXONY"}, "ST": {"SACHSEN-ANHALT"}, "SH": {"SCHLESWIG-HOLSTEIN"}, "TH": {"THÜRINGEN", "THUERINGEN"},
+		},
+	},
+	"HK": {
+		required: []string{"name", "phone", "line1", "city", "country"},
+		noPostal: true,
+	},
+	"SG": {
+		required:      []string{"name", "phone", "line1", "postalCode", "country"},
+		postal:        regexp.MustCompile(`^\d{6}$`),
+		postalExample: "018956",
+	},
+	"GB": {
+		required:      []string{"name", "line1", "city", "postalCode", "country"},
+		postal:        regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`),
+		postalExample: "SW1A 1AA",
+	},
+}
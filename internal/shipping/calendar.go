@@ -0,0 +1,258 @@
+// internal/shipping/calendar.go
+package shipping
+
+import (
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CalendarDataID 是发货截单时间和各地区工作日历在 Nacos 中的 Data ID
+const CalendarDataID = "nexus-shipping-calendar.yaml"
+
+// dateLayout 是日历中日期的格式, 也是响应中送达日期的格式
+const dateLayout = "2006-01-02"
+
+// maxCalendarScan 是寻找下一个工作日时最多向后查找的天数, 防止节假日配置错误时死循环
+const maxCalendarScan = 366
+
+// CalendarConfig 是 nexus-shipping-calendar.yaml 的结构
+type CalendarConfig struct {
+	// Calendars 是按名称定义的工作日历, 例如 CN、US
+	Calendars map[string]BusinessCalendar `yaml:"calendars"`
+	// Regions 把地区代码 (ISO 3166) 映射到工作日历的名称, 查找顺序: 精确匹配 -> 国家代码
+	Regions map[string]string `yaml:"regions"`
+	// Warehouses 是各发货地区的截单时间, 键同样是地区代码, 查找顺序与 Regions 相同
+	Warehouses map[string]Warehouse `yaml:"warehouses"`
+}
+
+// BusinessCalendar 是一个地区的工作日历: 周末、法定节假日和调休上班日
+type BusinessCalendar struct {
+	TimeZone string   `yaml:"timeZone"` // IANA 时区, 例如 Asia/Shanghai
+	Weekends []string `yaml:"weekends"` // 不发货也不派送的星期, 例如 [saturday, sunday]
+	Holidays []string `yaml:"holidays"` // 节假日, 格式 2006-01-02
+	Workdays []string `yaml:"workdays"` // 调休上班的周末, 格式 2006-01-02
+
+	location *time.Location
+	weekends [7]bool
+	holidays map[string]bool
+	workdays map[string]bool
+}
+
+// Warehouse 是一个发货地区的截单时间: 截单前的订单当天发货, 之后的订单下一个工作日发货
+type Warehouse struct {
+	Calendar string `yaml:"calendar"` // 工作日历的名称, 为空时按 Regions 查找
+	Cutoff   string `yaml:"cutoff"`   // 截单时间 (发货地区的当地时间), 格式 15:04, 为空时不截单
+
+	cutoff time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// compile 解析时区和日期, 校验日历至少有一个工作日
+func (c *BusinessCalendar) compile() error {
+	var err error
+	if c.location, err = time.LoadLocation(c.TimeZone); err != nil || c.TimeZone == "" {
+		return fmt.Errorf("invalid timeZone %q", c.TimeZone)
+	}
+	for _, name := range c.Weekends {
+		day, ok := weekdays[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", name)
+		}
+		c.weekends[day] = true
+	}
+	if c.weekends == [7]bool{true, true, true, true, true, true, true} {
+		return fmt.Errorf("every day of the week is a weekend")
+	}
+	if c.holidays, err = parseDates(c.Holidays); err != nil {
+		return fmt.Errorf("holidays: %w", err)
+	}
+	if c.workdays, err = parseDates(c.Workdays); err != nil {
+		return fmt.Errorf("workdays: %w", err)
+	}
+	return nil
+}
+
+func parseDates(dates []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		t, err := time.Parse(dateLayout, strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", d)
+		}
+		set[t.Format(dateLayout)] = true
+	}
+	return set, nil
+}
+
+// isBusinessDay 判断 day (只看年月日) 是否发货和派送。调休上班日优先于周末, 节假日优先于其他规则。
+func (c *BusinessCalendar) isBusinessDay(day time.Time) bool {
+	key := day.Format(dateLayout)
+	if c.holidays[key] {
+		return false
+	}
+	return c.workdays[key] || !c.weekends[day.Weekday()]
+}
+
+// nextBusinessDay 返回 day 之后 (不含 day) 的第一个工作日
+func (c *BusinessCalendar) nextBusinessDay(day time.Time) (time.Time, error) {
+	for i := 0; i < maxCalendarScan; i++ {
+		day = day.AddDate(0, 0, 1)
+		if c.isBusinessDay(day) {
+			return day, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no business day within %d days after %s", maxCalendarScan, day.Format(dateLayout))
+}
+
+// addBusinessDays 返回 day 之后第 n 个工作日; n 为 0 且 day 不是工作日时顺延到下一个工作日
+func (c *BusinessCalendar) addBusinessDays(day time.Time, n int) (time.Time, error) {
+	var err error
+	if n == 0 && !c.isBusinessDay(day) {
+		return c.nextBusinessDay(day)
+	}
+	for i := 0; i < n && err == nil; i++ {
+		day, err = c.nextBusinessDay(day)
+	}
+	return day, err
+}
+
+// naturalCalendar 在没有配置日历时使用: 每天都是工作日, 与只按自然日计算的结果一致
+var naturalCalendar = &BusinessCalendar{TimeZone: "Local", location: time.Local}
+
+// DeliveryCalendar 按发货地区的截单时间、承运商的在途工作日、目的地的周末和节假日估算送达日期, 支持热更新
+type DeliveryCalendar struct {
+	cfg atomic.Pointer[CalendarConfig]
+}
+
+// NewDeliveryCalendar 创建一个空的日历, 未配置时送达日期按自然日计算
+func NewDeliveryCalendar() *DeliveryCalendar {
+	c := &DeliveryCalendar{}
+	c.cfg.Store(&CalendarConfig{})
+	return c
+}
+
+// Update 用新的配置替换当前日历。有任何一个日历或截单时间不合法时整份配置都不生效。
+func (c *DeliveryCalendar) Update(cfg CalendarConfig) {
+	calendars := make(map[string]BusinessCalendar, len(cfg.Calendars))
+	for name, cal := range cfg.Calendars {
+		if err := cal.compile(); err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid delivery calendar, keeping previous calendar: calendar %s: %v", name, err)
+			return
+		}
+		calendars[name] = cal
+	}
+	regions := make(map[string]string, len(cfg.Regions))
+	for region, name := range cfg.Regions {
+		if _, ok := calendars[name]; !ok {
+			logger.Logger.Printf("❌ ERROR: Invalid delivery calendar, keeping previous calendar: region %s uses unknown calendar %q", region, name)
+			return
+		}
+		regions[strings.ToUpper(region)] = name
+	}
+	warehouses := make(map[string]Warehouse, len(cfg.Warehouses))
+	for region, wh := range cfg.Warehouses {
+		if _, ok := calendars[wh.Calendar]; wh.Calendar != "" && !ok {
+			logger.Logger.Printf("❌ ERROR: Invalid delivery calendar, keeping previous calendar: warehouse %s uses unknown calendar %q", region, wh.Calendar)
+			return
+		}
+		if wh.Cutoff != "" {
+			t, err := time.Parse("15:04", wh.Cutoff)
+			if err != nil {
+				logger.Logger.Printf("❌ ERROR: Invalid delivery calendar, keeping previous calendar: warehouse %s: invalid cutoff %q", region, wh.Cutoff)
+				return
+			}
+			wh.cutoff = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+		warehouses[strings.ToUpper(region)] = wh
+	}
+	cfg.Calendars, cfg.Regions, cfg.Warehouses = calendars, regions, warehouses
+	c.cfg.Store(&cfg)
+	logger.Logger.Printf("✅ Delivery calendar applied: %d calendar(s), %d region mapping(s), %d warehouse(s)",
+		len(calendars), len(regions), len(warehouses))
+}
+
+// lookupRegion 按地区代码查找, 先精确匹配, 再退回国家代码 (CN-SH 退回 CN)
+func lookupRegion[T any](m map[string]T, region string) (T, bool) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if v, ok := m[region]; ok {
+		return v, true
+	}
+	if country, _, ok := strings.Cut(region, "-"); ok {
+		v, ok := m[country]
+		return v, ok
+	}
+	var zero T
+	return zero, false
+}
+
+// calendar 返回地区的工作日历, 没有配置时返回 naturalCalendar
+func (cfg *CalendarConfig) calendar(name, region string) *BusinessCalendar {
+	if name == "" {
+		name, _ = lookupRegion(cfg.Regions, region)
+	}
+	if cal, ok := cfg.Calendars[name]; ok {
+		return &cal
+	}
+	return naturalCalendar
+}
+
+// Estimate 估算从 origin 发货、在途 minDays 到 maxDays 个工作日的包裹的送达日期。
+// 发货日: 发货地区当地时间在截单前且当天是工作日时为当天, 否则为下一个工作日;
+// 送达日: 从发货日起按目的地的工作日历数 minDays / maxDays 个工作日。
+func (c *DeliveryCalendar) Estimate(origin, destination string, now time.Time, minDays, maxDays int) (DeliveryWindow, error) {
+	cfg := c.cfg.Load()
+	wh, _ := lookupRegion(cfg.Warehouses, origin)
+	originCal := cfg.calendar(wh.Calendar, origin)
+	destCal := cfg.calendar("", destination)
+
+	local := now.In(originCal.location)
+	shipDate := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, originCal.location)
+	window := DeliveryWindow{MinDays: minDays, MaxDays: maxDays, TimeZone: destCal.location.String()}
+	if wh.Cutoff != "" {
+		if cutoff := shipDate.Add(wh.cutoff); !local.Before(cutoff) {
+			shipDate = shipDate.AddDate(0, 0, 1)
+		}
+	}
+	var err error
+	if shipDate, err = originCal.addBusinessDays(shipDate, 0); err != nil {
+		return window, err
+	}
+	if wh.Cutoff != "" {
+		orderBy := shipDate.Add(wh.cutoff)
+		window.OrderBy = &orderBy
+	}
+
+	// 日期在两个日历之间按年月日传递, 与时区无关
+	day := time.Date(shipDate.Year(), shipDate.Month(), shipDate.Day(), 0, 0, 0, 0, destCal.location)
+	earliest, err := destCal.addBusinessDays(day, minDays)
+	if err != nil {
+		return window, err
+	}
+	latest, err := destCal.addBusinessDays(day, maxDays)
+	if err != nil {
+		return window, err
+	}
+	window.ShipDate = shipDate.Format(dateLayout)
+	window.EarliestDate, window.LatestDate = earliest.Format(dateLayout), latest.Format(dateLayout)
+	window.Earliest, window.Latest = earliest, latest
+	return window, nil
+}
+
+// Apply 用 Estimate 的结果替换每个可选方式按自然日计算的送达时间
+func (c *DeliveryCalendar) Apply(origin, destination string, now time.Time, options []Option) error {
+	for i := range options {
+		window, err := c.Estimate(origin, destination, now, options[i].Delivery.MinDays, options[i].Delivery.MaxDays)
+		if err != nil {
+			return err
+		}
+		options[i].Delivery = window
+	}
+	return nil
+}
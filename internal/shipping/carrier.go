@@ -0,0 +1,310 @@
+// internal/shipping/carrier.go
+package shipping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CarriersDataID 是承运商注册表在 Nacos 中的 Data ID
+const CarriersDataID = "nexus-shipping-carriers.yaml"
+
+// 承运商的接入方式
+const (
+	// CarrierTypeTable 使用 nexus-shipping-rates.yaml 中的费率表报价, 不支持下单和跟踪
+	CarrierTypeTable = "table"
+	// CarrierTypeFake 通过 HTTP 调用本地的假承运商 (cmd/fake-carrier), 用于离线联调和端到端测试
+	CarrierTypeFake = "fake"
+)
+
+// defaultCarrierTimeout 是未配置超时时间时每次调用承运商的超时
+const defaultCarrierTimeout = 3 * time.Second
+
+var (
+	// ErrUnknownCarrier 注册表中没有该承运商, 或承运商已停用
+	ErrUnknownCarrier = errors.New("unknown carrier")
+	// ErrUnsupported 承运商不支持该操作
+	ErrUnsupported = errors.New("operation not supported by carrier")
+	// ErrCarrierUnavailable 调用承运商失败: 网络错误、超时或承运商返回 5xx
+	ErrCarrierUnavailable = errors.New("carrier unavailable")
+	// ErrCarrierRejected 承运商拒绝了请求 (4xx)
+	ErrCarrierRejected = errors.New("carrier rejected request")
+	// ErrTrackingNotFound 承运商找不到该运单
+	ErrTrackingNotFound = errors.New("tracking number not found")
+	// ErrCannotCancel 运单已经揽收, 不能再取消
+	ErrCannotCancel = errors.New("shipment can no longer be cancelled")
+)
+
+// QuoteRequest 是向承运商询价的参数, Origin 和 Destination 是地区代码 (ISO 3166)
+type QuoteRequest struct {
+	Parcel       Parcel
+	Origin       string
+	Destination  string
+	ServiceLevel string // 为空时返回全部服务等级
+	Currency     money.Currency
+	Now          time.Time
+}
+
+// ShipmentRequest 是向承运商下单的参数
+type ShipmentRequest struct {
+	Reference    string         `json:"reference"` // 我方的单号, 例如订单号
+	ServiceLevel string         `json:"serviceLevel"`
+	Parcel       Parcel         `json:"parcel"`
+	From         Address        `json:"from"`
+	To           Address        `json:"to"`
+	Currency     money.Currency `json:"-"`
+}
+
+// CarrierShipment 是承运商下单成功后返回的运单
+type CarrierShipment struct {
+	Carrier        string      `json:"carrier"`
+	TrackingNumber string      `json:"trackingNumber"`
+	ServiceLevel   string      `json:"serviceLevel"`
+	Cost           money.Money `json:"cost"`
+	CreatedAt      time.Time   `json:"createdAt"`
+}
+
+// TrackingEvent 是承运商的一条轨迹, Code 是承运商自己的状态码
+type TrackingEvent struct {
+	Code        string    `json:"code"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt"`
+}
+
+// TrackingInfo 是一个运单的全部轨迹, 按时间先后排列
+type TrackingInfo struct {
+	Carrier        string          `json:"carrier"`
+	TrackingNumber string          `json:"trackingNumber"`
+	Events         []TrackingEvent `json:"events"`
+}
+
+// Carrier 是一个承运商的接入。实现必须可以并发调用, 并自行控制超时。
+type Carrier interface {
+	Code() string
+	// Quote 返回承运商对包裹的报价, 承运商不覆盖这条线路时返回空列表
+	Quote(ctx context.Context, req QuoteRequest) ([]Option, error)
+	CreateShipment(ctx context.Context, req ShipmentRequest) (*CarrierShipment, error)
+	Cancel(ctx context.Context, trackingNumber string) error
+	Track(ctx context.Context, trackingNumber string) (*TrackingInfo, error)
+}
+
+// StatusMapper 由能提供轨迹的承运商实现, 把承运商自己的状态码映射为运单状态;
+// 无法映射的状态码 (例如只是补充说明的轨迹) 返回 false
+type StatusMapper interface {
+	ShipmentStatus(code string) (string, bool)
+}
+
+// CarriersConfig 是 nexus-shipping-carriers.yaml 的结构
+type CarriersConfig struct {
+	Carriers []CarrierConfig `yaml:"carriers"`
+}
+
+// CarrierConfig 是注册表中一个承运商的配置
+type CarrierConfig struct {
+	Code     string        `yaml:"code"`
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type"`
+	Enabled  *bool         `yaml:"enabled"`
+	Endpoint string        `yaml:"endpoint"` // fake: 假承运商的地址
+	Timeout  time.Duration `yaml:"timeout"`  // 单次调用的超时, 默认 3s
+	// WebhookSecret 是校验承运商推送签名的 HMAC 密钥, 为空时拒绝该承运商的推送
+	WebhookSecret string `yaml:"webhookSecret"`
+}
+
+// CarrierFactory 按配置创建一个承运商
+type CarrierFactory func(cfg CarrierConfig) (Carrier, error)
+
+// Registry 持有当前启用的承运商, 支持热更新。
+// 没有配置任何承运商时, 费率表中的每个承运商都以 table 方式注册。
+type Registry struct {
+	rates *RateTable
+
+	mu        sync.RWMutex
+	factories map[string]CarrierFactory
+
+	state atomic.Pointer[registryState]
+}
+
+// registryState 是一份生效的注册表: 承运商和它们的推送密钥一起替换
+type registryState struct {
+	carriers []Carrier
+	secrets  map[string]string
+}
+
+// NewRegistry 创建承运商注册表, 内置 table 和 fake 两种接入方式
+func NewRegistry(rates *RateTable) *Registry {
+	r := &Registry{rates: rates, factories: make(map[string]CarrierFactory)}
+	r.RegisterType(CarrierTypeTable, func(cfg CarrierConfig) (Carrier, error) {
+		return &tableCarrier{code: cfg.Code, rates: rates}, nil
+	})
+	r.RegisterType(CarrierTypeFake, newHTTPCarrier)
+	r.state.Store(&registryState{})
+	return r
+}
+
+// RegisterType 注册一种接入方式, 接入新的承运商时在这里加上它的实现
+func (r *Registry) RegisterType(typ string, factory CarrierFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[typ] = factory
+}
+
+// Update 按新的配置重建承运商列表。有任何一个承运商配置不合法时整份配置都不生效。
+func (r *Registry) Update(cfg CarriersConfig) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	carriers := make([]Carrier, 0, len(cfg.Carriers))
+	secrets := make(map[string]string)
+	seen := make(map[string]bool, len(cfg.Carriers))
+	for _, c := range cfg.Carriers {
+		if c.Enabled != nil && !*c.Enabled {
+			continue
+		}
+		if c.Code == "" || seen[c.Code] {
+			logger.Logger.Printf("❌ ERROR: Invalid carrier registry, keeping previous carriers: missing or duplicate code %q", c.Code)
+			return
+		}
+		seen[c.Code] = true
+		factory, ok := r.factories[c.Type]
+		if !ok {
+			logger.Logger.Printf("❌ ERROR: Invalid carrier registry, keeping previous carriers: carrier %s has unknown type %q", c.Code, c.Type)
+			return
+		}
+		if c.Timeout <= 0 {
+			c.Timeout = defaultCarrierTimeout
+		}
+		carrier, err := factory(c)
+		if err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid carrier registry, keeping previous carriers: carrier %s: %v", c.Code, err)
+			return
+		}
+		carriers = append(carriers, carrier)
+		if c.WebhookSecret != "" {
+			secrets[c.Code] = c.WebhookSecret
+		}
+	}
+	r.state.Store(&registryState{carriers: carriers, secrets: secrets})
+	logger.Logger.Printf("✅ Carrier registry applied: %d carrier(s)", len(carriers))
+}
+
+// Carriers 返回当前启用的全部承运商
+func (r *Registry) Carriers() []Carrier {
+	if carriers := r.state.Load().carriers; len(carriers) > 0 {
+		return carriers
+	}
+	var carriers []Carrier
+	for _, c := range r.rates.cfg.Load().Carriers {
+		carriers = append(carriers, &tableCarrier{code: c.Code, rates: r.rates})
+	}
+	return carriers
+}
+
+// Get 按编码查找承运商
+func (r *Registry) Get(code string) (Carrier, error) {
+	for _, c := range r.Carriers() {
+		if c.Code() == code {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCarrier, code)
+}
+
+// TrackingCarriers 返回当前启用的、能提供轨迹 (实现了 StatusMapper) 的承运商编码
+func (r *Registry) TrackingCarriers() []string {
+	var codes []string
+	for _, c := range r.Carriers() {
+		if _, ok := c.(StatusMapper); ok {
+			codes = append(codes, c.Code())
+		}
+	}
+	return codes
+}
+
+// webhookSecret 返回承运商的推送签名密钥, 没有配置时返回 false
+func (r *Registry) webhookSecret(code string) (string, bool) {
+	secret, ok := r.state.Load().secrets[code]
+	return secret, ok
+}
+
+// CarrierError 记录询价时某个承运商的失败, 不影响其他承运商的报价
+type CarrierError struct {
+	Carrier string `json:"carrier"`
+	Error   string `json:"error"`
+}
+
+// Quote 并发向所有承运商询价, 合并后按运费从低到高排列。
+// 单个承运商失败时只记录在返回的 CarrierError 中; 所有承运商都没有报价时返回 ErrNoOption。
+func (r *Registry) Quote(ctx context.Context, req QuoteRequest) ([]Option, []CarrierError, error) {
+	carriers := r.Carriers()
+	results := make([][]Option, len(carriers))
+	errs := make([]error, len(carriers))
+	var wg sync.WaitGroup
+	for i, c := range carriers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = c.Quote(ctx, req)
+		}()
+	}
+	wg.Wait()
+
+	options := []Option{}
+	var failures []CarrierError
+	for i, c := range carriers {
+		if errs[i] != nil {
+			// 地区本身不合法时所有承运商都会失败, 直接返回给调用方
+			if errors.Is(errs[i], ErrUnknownZone) {
+				return nil, nil, errs[i]
+			}
+			failures = append(failures, CarrierError{Carrier: c.Code(), Error: errs[i].Error()})
+			continue
+		}
+		options = append(options, results[i]...)
+	}
+	if len(options) == 0 {
+		return nil, failures, fmt.Errorf("%w: %s -> %s, %d g", ErrNoOption, req.Origin, req.Destination, req.Parcel.WeightGrams)
+	}
+	sortOptions(options)
+	return options, failures, nil
+}
+
+func sortOptions(options []Option) {
+	sort.SliceStable(options, func(i, j int) bool {
+		if c := options[i].Cost.Cmp(options[j].Cost); c != 0 {
+			return c < 0
+		}
+		return options[i].Delivery.MaxDays < options[j].Delivery.MaxDays
+	})
+}
+
+// tableCarrier 按费率表报价, 没有下单和跟踪的接口
+type tableCarrier struct {
+	code  string
+	rates *RateTable
+}
+
+func (c *tableCarrier) Code() string { return c.code }
+
+func (c *tableCarrier) Quote(_ context.Context, req QuoteRequest) ([]Option, error) {
+	return c.rates.quote(req.Parcel, req.Origin, req.Destination, req.ServiceLevel, req.Now, c.code)
+}
+
+func (c *tableCarrier) CreateShipment(context.Context, ShipmentRequest) (*CarrierShipment, error) {
+	return nil, fmt.Errorf("%w: %s creates shipments offline", ErrUnsupported, c.code)
+}
+
+func (c *tableCarrier) Cancel(context.Context, string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupported, c.code)
+}
+
+func (c *tableCarrier) Track(context.Context, string) (*TrackingInfo, error) {
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, c.code)
+}
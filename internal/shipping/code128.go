@@ -0,0 +1,53 @@
+// internal/shipping/code128.go
+package shipping
+
+import "fmt"
+
+// code128Patterns 是 Code 128 每个码字的条空宽度 (条、空交替, 以模块为单位), 下标是码字的值。
+// 103/104/105 是 Start A/B/C, 106 是 Stop (多一个 2 模块宽的终止条)。
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// code128Modules 把 data 编码为 Code 128 (B 字符集), 返回条空交替的模块宽度, 第一个是条。
+// 运单号只包含可打印的 ASCII 字符, 其他字符返回错误。
+func code128Modules(data string) ([]int, error) {
+	if data == "" {
+		return nil, fmt.Errorf("barcode data is empty")
+	}
+	codes := []int{code128StartB}
+	checksum := code128StartB
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c < 32 || c > 126 {
+			return nil, fmt.Errorf("barcode data %q contains a character outside printable ASCII", data)
+		}
+		value := int(c) - 32
+		codes = append(codes, value)
+		checksum += (i + 1) * value
+	}
+	codes = append(codes, checksum%103, code128Stop)
+
+	var modules []int
+	for _, code := range codes {
+		for _, w := range code128Patterns[code] {
+			modules = append(modules, int(w-'0'))
+		}
+	}
+	return modules, nil
+}
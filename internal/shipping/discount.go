@@ -0,0 +1,250 @@
+// internal/shipping/discount.go
+package shipping
+
+import (
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DiscountsDataID 是运费优惠规则在 Nacos 中的 Data ID
+const DiscountsDataID = "nexus-shipping-discounts.yaml"
+
+// 运费优惠的动作类型
+const (
+	DiscountFree       = "free"        // 免运费
+	DiscountPercentOff = "percent_off" // 运费打折
+	DiscountFixedOff   = "fixed_off"   // 运费立减, 最多减到 0
+	DiscountFlatRate   = "flat_rate"   // 一口价, 只在比原运费低时生效
+)
+
+// DiscountsConfig 是 nexus-shipping-discounts.yaml 的结构
+type DiscountsConfig struct {
+	Rules []DiscountRule `yaml:"rules"`
+}
+
+// DiscountRule 是一条运费优惠规则。每个可选方式最多应用一条规则:
+// 取优惠后运费最低的一条, 相同时取 Priority 小的。
+type DiscountRule struct {
+	ID       string             `yaml:"id"`
+	Name     string             `yaml:"name"`
+	Enabled  *bool              `yaml:"enabled"`
+	Priority int                `yaml:"priority"`
+	When     DiscountConditions `yaml:"when"`
+	Action   DiscountAction     `yaml:"action"`
+}
+
+// DiscountConditions 是运费优惠生效的条件, 未填写的条件视为满足。金额以 SHIPPING_BASE_CURRENCY 计。
+type DiscountConditions struct {
+	Segments         []string  `yaml:"segments"`         // 用户分群, 满足其一即可
+	MinSubtotal      string    `yaml:"minSubtotal"`      // 商品报价应付总额下限
+	DestinationZones []string  `yaml:"destinationZones"` // 目的地的运费区域
+	ServiceLevels    []string  `yaml:"serviceLevels"`
+	Carriers         []string  `yaml:"carriers"`
+	StartAt          time.Time `yaml:"startAt"`
+	EndAt            time.Time `yaml:"endAt"` // 不包含
+
+	minSubtotal money.Money
+}
+
+// DiscountAction 是规则生效后对运费的调整
+type DiscountAction struct {
+	Type    string  `yaml:"type"`
+	Percent float64 `yaml:"percent"` // percent_off: 10 表示减 10%
+	Amount  string  `yaml:"amount"`  // fixed_off: 立减的金额; flat_rate: 一口价
+
+	amount money.Money
+}
+
+// AppliedDiscount 是一个可选方式上生效的优惠规则
+type AppliedDiscount struct {
+	RuleID   string      `json:"ruleId"`
+	Name     string      `json:"name"`
+	Action   string      `json:"action"`
+	Discount money.Money `json:"discount"`
+}
+
+// DiscountContext 是评估运费优惠时需要的上下文
+type DiscountContext struct {
+	Subtotal        money.Money // 商品报价的应付总额, 以 SHIPPING_BASE_CURRENCY 计
+	Segments        []string    // 商品报价中 pricing-service 计价时使用的用户分群
+	DestinationZone string
+	Now             time.Time
+}
+
+// compile 校验规则, 并把配置中的金额解析为 currency 的精确金额
+func (r *DiscountRule) compile(currency money.Currency) error {
+	if r.ID == "" {
+		return fmt.Errorf("rule without id")
+	}
+	r.When.minSubtotal = money.Zero(currency)
+	if r.When.MinSubtotal != "" {
+		m, err := money.Parse(r.When.MinSubtotal, currency)
+		if err != nil {
+			return fmt.Errorf("rule %s: minSubtotal: %w", r.ID, err)
+		}
+		r.When.minSubtotal = m
+	}
+	switch r.Action.Type {
+	case DiscountFree:
+	case DiscountPercentOff:
+		if r.Action.Percent <= 0 || r.Action.Percent > 100 {
+			return fmt.Errorf("rule %s: percent must be in (0, 100]", r.ID)
+		}
+	case DiscountFixedOff, DiscountFlatRate:
+		m, err := money.Parse(r.Action.Amount, currency)
+		if err != nil {
+			return fmt.Errorf("rule %s: amount: %w", r.ID, err)
+		}
+		if m.IsNegative() || (r.Action.Type == DiscountFixedOff && m.IsZero()) {
+			return fmt.Errorf("rule %s: amount must be positive", r.ID)
+		}
+		r.Action.amount = m
+	default:
+		return fmt.Errorf("rule %s: unknown action type %q", r.ID, r.Action.Type)
+	}
+	return nil
+}
+
+// matches 判断规则是否适用于这个可选方式
+func (r *DiscountRule) matches(dc DiscountContext, option Option) bool {
+	w := r.When
+	switch {
+	case len(w.Segments) > 0 && !slices.ContainsFunc(w.Segments, func(s string) bool { return slices.Contains(dc.Segments, s) }):
+		return false
+	case w.minSubtotal.IsPositive() && dc.Subtotal.Cmp(w.minSubtotal) < 0:
+		return false
+	case len(w.DestinationZones) > 0 && !slices.Contains(w.DestinationZones, dc.DestinationZone):
+		return false
+	case len(w.ServiceLevels) > 0 && !slices.Contains(w.ServiceLevels, option.ServiceLevel):
+		return false
+	case len(w.Carriers) > 0 && !slices.Contains(w.Carriers, option.Carrier):
+		return false
+	case !w.StartAt.IsZero() && dc.Now.Before(w.StartAt):
+		return false
+	case !w.EndAt.IsZero() && !dc.Now.Before(w.EndAt):
+		return false
+	}
+	return true
+}
+
+// matchesAll 判断规则是否适用于 options 中的每一个方式
+func (r *DiscountRule) matchesAll(dc DiscountContext, options []Option) bool {
+	for _, o := range options {
+		if !r.matches(dc, o) {
+			return false
+		}
+	}
+	return true
+}
+
+// discount 返回规则对运费 cost 的优惠金额, 不超过运费本身
+func (r *DiscountRule) discount(cost money.Money) money.Money {
+	switch r.Action.Type {
+	case DiscountFree:
+		return cost
+	case DiscountPercentOff:
+		return cost.Percent(r.Action.Percent)
+	case DiscountFixedOff:
+		return money.Min(cost, r.Action.amount)
+	case DiscountFlatRate:
+		if r.Action.amount.Cmp(cost) < 0 {
+			return cost.Sub(r.Action.amount)
+		}
+	}
+	return money.Zero(cost.Currency())
+}
+
+// DiscountRules 持有当前生效的运费优惠规则, 支持热更新
+type DiscountRules struct {
+	currency money.Currency
+	rules    atomic.Pointer[[]DiscountRule]
+}
+
+// NewDiscountRules 创建一个空的规则集, 规则中的金额按 currency 解析
+func NewDiscountRules(currency money.Currency) *DiscountRules {
+	d := &DiscountRules{currency: currency}
+	d.rules.Store(&[]DiscountRule{})
+	return d
+}
+
+// Update 用新的配置替换当前规则。有任何一条规则不合法时整份配置都不生效。
+func (d *DiscountRules) Update(cfg DiscountsConfig) {
+	rules := make([]DiscountRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		if r.Enabled != nil && !*r.Enabled {
+			continue
+		}
+		if err := r.compile(d.currency); err != nil {
+			logger.Logger.Printf("❌ ERROR: Invalid shipping discount rule, keeping previous rules: %v", err)
+			return
+		}
+		rules = append(rules, r)
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	d.rules.Store(&rules)
+	logger.Logger.Printf("✅ Shipping discount rules applied: %d rule(s)", len(rules))
+}
+
+// Apply 在承运商运费的基础上应用优惠规则, 把优惠后的运费写回 Cost, 原运费保存在 OriginalCost,
+// 然后按优惠后的运费重新排序。运费必须以规则的货币计, 即在换算货币之前调用。
+func (d *DiscountRules) Apply(dc DiscountContext, options []Option) {
+	rules := *d.rules.Load()
+	for i := range options {
+		o := &options[i]
+		best, bestDiscount := bestRule(rules, dc, options[i:i+1], o.Cost)
+		if best == nil {
+			continue
+		}
+		original := o.Cost
+		o.OriginalCost = &original
+		o.Cost = o.Cost.Sub(bestDiscount)
+		o.Discount = &AppliedDiscount{RuleID: best.ID, Name: best.Name, Action: best.Action.Type, Discount: bestDiscount}
+	}
+	sortOptions(options)
+}
+
+// OrderCost 是一个订单拆成多个包裹后的运费: Original 是各包裹运费之和, Cost 是优惠后的运费,
+// Discount 是生效的规则, 没有规则生效时为空
+type OrderCost struct {
+	Original money.Money
+	Cost     money.Money
+	Discount *AppliedDiscount
+}
+
+// ApplyOrder 把优惠规则作为整单优惠应用一次: options 是每个包裹采用的方式, 规则按全部包裹的运费之和计算,
+// 满额门槛只比较一次整单金额, fixed_off 只减一次, flat_rate 是整单的一口价;
+// 规则的服务等级和承运商条件要每个包裹都满足。运费必须以规则的货币计, options 不能为空。
+func (d *DiscountRules) ApplyOrder(dc DiscountContext, options []Option) OrderCost {
+	total := options[0].Cost
+	for _, o := range options[1:] {
+		total = total.Add(o.Cost)
+	}
+	oc := OrderCost{Original: total, Cost: total}
+	best, bestDiscount := bestRule(*d.rules.Load(), dc, options, total)
+	if best != nil {
+		oc.Cost = total.Sub(bestDiscount)
+		oc.Discount = &AppliedDiscount{RuleID: best.ID, Name: best.Name, Action: best.Action.Type, Discount: bestDiscount}
+	}
+	return oc
+}
+
+// bestRule 返回对运费 cost 优惠最多的规则及优惠金额, 规则要适用于 options 中的每一个方式; 没有适用的规则时返回 nil
+func bestRule(rules []DiscountRule, dc DiscountContext, options []Option, cost money.Money) (*DiscountRule, money.Money) {
+	var best *DiscountRule
+	bestDiscount := money.Zero(cost.Currency())
+	for j := range rules {
+		if !rules[j].matchesAll(dc, options) {
+			continue
+		}
+		// 规则已按优先级排序, 只有优惠更多时才替换
+		if discount := rules[j].discount(cost); discount.Cmp(bestDiscount) > 0 {
+			best, bestDiscount = &rules[j], discount
+		}
+	}
+	return best, bestDiscount
+}
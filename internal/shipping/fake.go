@@ -0,0 +1,332 @@
+// internal/shipping/fake.go
+package shipping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 假承运商的轨迹状态码, 模拟真实承运商使用自己的一套编码
+const (
+	FakeCodeCreated        = "CREATED"
+	FakeCodePickedUp       = "PICKUP"
+	FakeCodeInTransit      = "TRANSIT"
+	FakeCodeOutForDelivery = "OUT_FOR_DELIVERY"
+	FakeCodeDelivered      = "DELIVERED"
+	FakeCodeException      = "EXCEPTION"
+	FakeCodeCancelled      = "CANCELLED"
+)
+
+// fakeStatuses 把假承运商的状态码映射为运单状态, CANCELLED 没有对应的运单状态
+var fakeStatuses = map[string]string{
+	FakeCodeCreated:        StatusLabelCreated,
+	FakeCodePickedUp:       StatusPickedUp,
+	FakeCodeInTransit:      StatusInTransit,
+	FakeCodeOutForDelivery: StatusOutForDelivery,
+	FakeCodeDelivered:      StatusDelivered,
+	FakeCodeException:      StatusException,
+}
+
+// fakeProgress 是假承运商的正常轨迹, 每经过一个 StepInterval 前进一步
+var fakeProgress = []struct{ code, description, location string }{
+	{FakeCodeCreated, "电子面单已生成", "发货仓"},
+	{FakeCodePickedUp, "快递员已揽收", "发货仓"},
+	{FakeCodeInTransit, "运输中", "转运中心"},
+	{FakeCodeOutForDelivery, "派送中", "目的地网点"},
+	{FakeCodeDelivered, "已签收", "收件地址"},
+}
+
+// 假承运商的接口格式, httpCarrier 按这个格式调用
+type (
+	fakeQuoteRequest struct {
+		Origin       string `json:"origin"`
+		Destination  string `json:"destination"`
+		ServiceLevel string `json:"serviceLevel,omitempty"`
+		WeightGrams  int64  `json:"weightGrams"`
+		VolumeCm3    int64  `json:"volumeCm3"`
+		Currency     string `json:"currency"`
+	}
+	fakeQuoteOption struct {
+		ServiceLevel          string `json:"serviceLevel"`
+		Cost                  string `json:"cost"`
+		ChargeableWeightGrams int64  `json:"chargeableWeightGrams"`
+		MinDays               int    `json:"minDays"`
+		MaxDays               int    `json:"maxDays"`
+	}
+	fakeQuoteResponse struct {
+		Options []fakeQuoteOption `json:"options"`
+	}
+	fakeShipmentRequest struct {
+		Reference    string  `json:"reference"`
+		ServiceLevel string  `json:"serviceLevel"`
+		WeightGrams  int64   `json:"weightGrams"`
+		VolumeCm3    int64   `json:"volumeCm3"`
+		From         Address `json:"from"`
+		To           Address `json:"to"`
+		Currency     string  `json:"currency"`
+	}
+	fakeShipmentResponse struct {
+		TrackingNumber string    `json:"trackingNumber"`
+		ServiceLevel   string    `json:"serviceLevel"`
+		Cost           string    `json:"cost"`
+		CreatedAt      time.Time `json:"createdAt"`
+	}
+	fakeWebhookEvent struct {
+		EventID        string    `json:"eventId"`
+		TrackingNumber string    `json:"trackingNumber"`
+		Code           string    `json:"code"`
+		Description    string    `json:"description,omitempty"`
+		Location       string    `json:"location,omitempty"`
+		OccurredAt     time.Time `json:"occurredAt"`
+	}
+	fakeWebhookPayload struct {
+		Events []fakeWebhookEvent `json:"events"`
+	}
+)
+
+// fakeServices 是假承运商的服务等级: 首重 1kg 的价格 (分) 和每续重 1kg 的价格 (分)
+var fakeServices = []struct {
+	level            string
+	first, perKg     int64
+	minDays, maxDays int
+}{
+	{ServiceStandard, 800, 200, 2, 4},
+	{ServiceExpress, 1500, 400, 1, 2},
+}
+
+type fakeShipment struct {
+	fakeShipmentResponse
+	reference   string
+	cancelled   bool
+	cancelledAt time.Time
+	// manual 是通过 /events 手动推进的轨迹, 有值时不再按时间自动推进
+	manual []TrackingEvent
+}
+
+// FakeCarrierServer 是一个内存中的假承运商, 提供询价、下单、取消和查询轨迹的 HTTP 接口,
+// 用于在没有真实承运商的环境下联调和做端到端测试。
+// 运单每经过 StepInterval 自动前进一步; 单号 (reference) 中包含 "exception" 的运单在运输中转为异常。
+// 也可以用 POST /shipments/{trackingNumber}/events 手动追加轨迹, 配置了 WebhookURL 时手动追加的轨迹会推送给 shipping-service。
+type FakeCarrierServer struct {
+	StepInterval time.Duration
+	// Latency 是每个请求额外等待的时间, 用来验证调用方的超时设置
+	Latency time.Duration
+	// WebhookURL 和 WebhookSecret 是推送轨迹的地址和签名密钥, WebhookURL 为空时不推送
+	WebhookURL    string
+	WebhookSecret string
+
+	mu        sync.Mutex
+	seq       int64
+	shipments map[string]*fakeShipment
+	mux       *http.ServeMux
+}
+
+// NewFakeCarrierServer 创建假承运商
+func NewFakeCarrierServer(stepInterval, latency time.Duration) *FakeCarrierServer {
+	s := &FakeCarrierServer{StepInterval: stepInterval, Latency: latency, shipments: make(map[string]*fakeShipment)}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /quote", s.handleQuote)
+	s.mux.HandleFunc("POST /shipments", s.handleCreate)
+	s.mux.HandleFunc("POST /shipments/{tn}/cancel", s.handleCancel)
+	s.mux.HandleFunc("GET /shipments/{tn}/tracking", s.handleTrack)
+	s.mux.HandleFunc("POST /shipments/{tn}/events", s.handleAddEvent)
+	return s
+}
+
+func (s *FakeCarrierServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// fakeCost 按首重加续重计算运费, 返回两位小数的金额字符串
+func fakeCost(first, perKg, weightGrams int64) string {
+	cents := first
+	if extra := weightGrams - 1000; extra > 0 {
+		cents += (extra + 999) / 1000 * perKg
+	}
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+func (s *FakeCarrierServer) handleQuote(w http.ResponseWriter, r *http.Request) {
+	var req fakeQuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WeightGrams <= 0 {
+		http.Error(w, "invalid quote request", http.StatusBadRequest)
+		return
+	}
+	// 体积重系数 6000
+	chargeable := max(req.WeightGrams, (req.VolumeCm3*1000+5999)/6000)
+	resp := fakeQuoteResponse{Options: []fakeQuoteOption{}}
+	for _, svc := range fakeServices {
+		if req.ServiceLevel != "" && req.ServiceLevel != svc.level {
+			continue
+		}
+		resp.Options = append(resp.Options, fakeQuoteOption{
+			ServiceLevel:          svc.level,
+			Cost:                  fakeCost(svc.first, svc.perKg, chargeable),
+			ChargeableWeightGrams: chargeable,
+			MinDays:               svc.minDays,
+			MaxDays:               svc.maxDays,
+		})
+	}
+	fakeWriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *FakeCarrierServer) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req fakeShipmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WeightGrams <= 0 {
+		http.Error(w, "invalid shipment request", http.StatusBadRequest)
+		return
+	}
+	if req.ServiceLevel == "" {
+		req.ServiceLevel = ServiceStandard
+	}
+	var cost string
+	for _, svc := range fakeServices {
+		if svc.level == req.ServiceLevel {
+			cost = fakeCost(svc.first, svc.perKg, max(req.WeightGrams, (req.VolumeCm3*1000+5999)/6000))
+		}
+	}
+	if cost == "" {
+		http.Error(w, "unsupported service level "+req.ServiceLevel, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.seq++
+	shipment := &fakeShipment{
+		fakeShipmentResponse: fakeShipmentResponse{
+			TrackingNumber: fmt.Sprintf("FK%s%06d", time.Now().Format("060102"), s.seq),
+			ServiceLevel:   req.ServiceLevel,
+			Cost:           cost,
+			CreatedAt:      time.Now(),
+		},
+		reference: req.Reference,
+	}
+	s.shipments[shipment.TrackingNumber] = shipment
+	s.mu.Unlock()
+	fakeWriteJSON(w, http.StatusCreated, shipment.fakeShipmentResponse)
+}
+
+func (s *FakeCarrierServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shipment, ok := s.shipments[r.PathValue("tn")]
+	if !ok {
+		http.Error(w, "tracking number not found", http.StatusNotFound)
+		return
+	}
+	if events := s.events(shipment, time.Now()); len(events) > 1 && !shipment.cancelled {
+		http.Error(w, "shipment already picked up", http.StatusConflict)
+		return
+	}
+	if !shipment.cancelled {
+		shipment.cancelled, shipment.cancelledAt = true, time.Now()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeCarrierServer) handleTrack(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shipment, ok := s.shipments[r.PathValue("tn")]
+	if !ok {
+		http.Error(w, "tracking number not found", http.StatusNotFound)
+		return
+	}
+	fakeWriteJSON(w, http.StatusOK, TrackingInfo{TrackingNumber: shipment.TrackingNumber, Events: s.events(shipment, time.Now())})
+}
+
+// handleAddEvent 手动追加一条轨迹, 之后该运单不再按时间自动推进
+func (s *FakeCarrierServer) handleAddEvent(w http.ResponseWriter, r *http.Request) {
+	var event TrackingEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.Code == "" {
+		http.Error(w, "event needs a code", http.StatusBadRequest)
+		return
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shipment, ok := s.shipments[r.PathValue("tn")]
+	if !ok {
+		http.Error(w, "tracking number not found", http.StatusNotFound)
+		return
+	}
+	if shipment.manual == nil {
+		shipment.manual = s.events(shipment, time.Now())
+	}
+	shipment.manual = append(shipment.manual, event)
+	if s.WebhookURL != "" {
+		go s.push(fakeWebhookEvent{
+			EventID:        fmt.Sprintf("%s-%d", shipment.TrackingNumber, len(shipment.manual)),
+			TrackingNumber: shipment.TrackingNumber,
+			Code:           event.Code,
+			Description:    event.Description,
+			Location:       event.Location,
+			OccurredAt:     event.OccurredAt,
+		})
+	}
+	fakeWriteJSON(w, http.StatusOK, TrackingInfo{TrackingNumber: shipment.TrackingNumber, Events: shipment.manual})
+}
+
+// push 把一条轨迹签名后推送到 WebhookURL, 失败时只记录日志
+func (s *FakeCarrierServer) push(event fakeWebhookEvent) {
+	body, _ := json.Marshal(fakeWebhookPayload{Events: []fakeWebhookEvent{event}})
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), uuid.NewString()
+	req, err := http.NewRequest(http.MethodPost, s.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("fake carrier: invalid webhook url: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookNonceHeader, nonce)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(s.WebhookSecret, timestamp, nonce, body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("fake carrier: push event %s failed: %v", event.EventID, err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("fake carrier: pushed event %s (%s), status %d", event.EventID, event.Code, resp.StatusCode)
+}
+
+// events 返回运单到 now 为止的轨迹, 调用方需持有锁
+func (s *FakeCarrierServer) events(shipment *fakeShipment, now time.Time) []TrackingEvent {
+	if shipment.manual != nil {
+		return shipment.manual
+	}
+	var events []TrackingEvent
+	for i, step := range fakeProgress {
+		at := shipment.CreatedAt.Add(time.Duration(i) * s.StepInterval)
+		if i > 0 && (s.StepInterval <= 0 || at.After(now) || shipment.cancelled) {
+			break
+		}
+		if step.code == FakeCodeOutForDelivery && strings.Contains(strings.ToLower(shipment.reference), "exception") {
+			events = append(events, TrackingEvent{Code: FakeCodeException, Description: "地址无法派送", Location: step.location, OccurredAt: at})
+			break
+		}
+		events = append(events, TrackingEvent{Code: step.code, Description: step.description, Location: step.location, OccurredAt: at})
+	}
+	if shipment.cancelled {
+		events = append(events, TrackingEvent{Code: FakeCodeCancelled, Description: "运单已取消", OccurredAt: shipment.cancelledAt})
+	}
+	return events
+}
+
+func fakeWriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,224 @@
+// internal/shipping/httpcarrier.go
+package shipping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"nexus/internal/money"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// httpCarrier 通过 HTTP 调用承运商, 使用假承运商 (FakeCarrierServer) 的接口格式。
+// 每次调用都有独立的超时和一个 client span。
+type httpCarrier struct {
+	code     string
+	name     string
+	endpoint string
+	timeout  time.Duration
+	client   *http.Client
+	tracer   trace.Tracer
+}
+
+func newHTTPCarrier(cfg CarrierConfig) (Carrier, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Code
+	}
+	return &httpCarrier{
+		code:     cfg.Code,
+		name:     name,
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		timeout:  cfg.Timeout,
+		client:   &http.Client{},
+		tracer:   otel.Tracer("shipping-carrier"),
+	}, nil
+}
+
+func (c *httpCarrier) Code() string { return c.code }
+
+func (c *httpCarrier) Quote(ctx context.Context, req QuoteRequest) ([]Option, error) {
+	body := fakeQuoteRequest{
+		Origin:       req.Origin,
+		Destination:  req.Destination,
+		ServiceLevel: req.ServiceLevel,
+		WeightGrams:  req.Parcel.WeightGrams,
+		VolumeCm3:    req.Parcel.VolumeCm3,
+		Currency:     req.Currency.Code,
+	}
+	var resp fakeQuoteResponse
+	if err := c.do(ctx, "Quote", http.MethodPost, "/quote", body, &resp); err != nil {
+		return nil, err
+	}
+	options := make([]Option, 0, len(resp.Options))
+	for _, o := range resp.Options {
+		cost, err := money.Parse(o.Cost, req.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s returned invalid cost %q: %v", ErrCarrierUnavailable, c.code, o.Cost, err)
+		}
+		options = append(options, Option{
+			Carrier:               c.code,
+			CarrierName:           c.name,
+			ServiceLevel:          o.ServiceLevel,
+			Cost:                  cost,
+			ChargeableWeightGrams: o.ChargeableWeightGrams,
+			Delivery: DeliveryWindow{
+				MinDays:  o.MinDays,
+				MaxDays:  o.MaxDays,
+				Earliest: req.Now.AddDate(0, 0, o.MinDays),
+				Latest:   req.Now.AddDate(0, 0, o.MaxDays),
+			},
+		})
+	}
+	return options, nil
+}
+
+func (c *httpCarrier) CreateShipment(ctx context.Context, req ShipmentRequest) (*CarrierShipment, error) {
+	body := fakeShipmentRequest{
+		Reference:    req.Reference,
+		ServiceLevel: req.ServiceLevel,
+		WeightGrams:  req.Parcel.WeightGrams,
+		VolumeCm3:    req.Parcel.VolumeCm3,
+		From:         req.From,
+		To:           req.To,
+		Currency:     req.Currency.Code,
+	}
+	var resp fakeShipmentResponse
+	if err := c.do(ctx, "CreateShipment", http.MethodPost, "/shipments", body, &resp); err != nil {
+		return nil, err
+	}
+	cost, err := money.Parse(resp.Cost, req.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s returned invalid cost %q: %v", ErrCarrierUnavailable, c.code, resp.Cost, err)
+	}
+	return &CarrierShipment{
+		Carrier:        c.code,
+		TrackingNumber: resp.TrackingNumber,
+		ServiceLevel:   resp.ServiceLevel,
+		Cost:           cost,
+		CreatedAt:      resp.CreatedAt,
+	}, nil
+}
+
+func (c *httpCarrier) Cancel(ctx context.Context, trackingNumber string) error {
+	return c.do(ctx, "Cancel", http.MethodPost, "/shipments/"+url.PathEscape(trackingNumber)+"/cancel", nil, nil)
+}
+
+func (c *httpCarrier) Track(ctx context.Context, trackingNumber string) (*TrackingInfo, error) {
+	var resp TrackingInfo
+	if err := c.do(ctx, "Track", http.MethodGet, "/shipments/"+url.PathEscape(trackingNumber)+"/tracking", nil, &resp); err != nil {
+		return nil, err
+	}
+	resp.Carrier = c.code
+	return &resp, nil
+}
+
+// ShipmentStatus 按假承运商的状态码映射运单状态
+func (c *httpCarrier) ShipmentStatus(code string) (string, bool) {
+	status, ok := fakeStatuses[code]
+	return status, ok
+}
+
+// ParseWebhook 解析假承运商格式的推送: {"events": [{"eventId", "trackingNumber", "code", ...}]}
+func (c *httpCarrier) ParseWebhook(body []byte) ([]CarrierEvent, error) {
+	var payload fakeWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	events := make([]CarrierEvent, 0, len(payload.Events))
+	for _, e := range payload.Events {
+		events = append(events, CarrierEvent{
+			EventID:        e.EventID,
+			TrackingNumber: e.TrackingNumber,
+			TrackingEvent:  TrackingEvent{Code: e.Code, Description: e.Description, Location: e.Location, OccurredAt: e.OccurredAt},
+		})
+	}
+	return events, nil
+}
+
+// do 发送一次请求并解析 JSON 响应。状态码映射为错误:
+// 404 -> ErrTrackingNotFound, 409 -> ErrCannotCancel, 其他 4xx -> ErrCarrierRejected, 5xx 和网络错误 -> ErrCarrierUnavailable
+func (c *httpCarrier) do(ctx context.Context, op, method, path string, body, out any) (err error) {
+	ctx, span := c.tracer.Start(ctx, "carrier."+c.code+"."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("carrier.code", c.code),
+			attribute.String("carrier.operation", op),
+			attribute.String("http.method", method),
+			attribute.String("http.url", c.endpoint+path),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			span.SetAttributes(attribute.Bool("carrier.timeout", true))
+		}
+		return fmt.Errorf("%w: %s %s: %v", ErrCarrierUnavailable, c.code, op, err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		detail := fmt.Sprintf("%s %s: %d %s", c.code, op, resp.StatusCode, strings.TrimSpace(string(msg)))
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrTrackingNotFound, detail)
+		case resp.StatusCode == http.StatusConflict:
+			return fmt.Errorf("%w: %s", ErrCannotCancel, detail)
+		case resp.StatusCode < 500:
+			return fmt.Errorf("%w: %s", ErrCarrierRejected, detail)
+		default:
+			return fmt.Errorf("%w: %s", ErrCarrierUnavailable, detail)
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %s %s: invalid response: %v", ErrCarrierUnavailable, c.code, op, err)
+	}
+	return nil
+}
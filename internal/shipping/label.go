@@ -0,0 +1,177 @@
+// internal/shipping/label.go
+package shipping
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 面单格式
+const (
+	LabelPDF = "pdf" // 办公打印机
+	LabelZPL = "zpl" // 热敏打印机
+)
+
+// ErrUnknownLabelFormat 不支持的面单格式
+var ErrUnknownLabelFormat = errors.New("unknown label format")
+
+// labelFormats 是每种面单格式的 Content-Type 和生成函数
+var labelFormats = map[string]struct {
+	contentType string
+	render      func(*Shipment) ([]byte, error)
+}{
+	LabelPDF: {"application/pdf", renderPDF},
+	LabelZPL: {"application/x-zpl", renderZPL},
+}
+
+// Label 是一张保存下来的面单
+type Label struct {
+	ShipmentID  string    `json:"shipmentId"`
+	Format      string    `json:"format"`
+	ContentType string    `json:"contentType"`
+	Content     []byte    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Label 返回运单指定格式的面单。面单第一次生成后保存在 shipment_label 表中,
+// 之后总是返回保存的版本, 重新打印的面单与第一次完全一致。
+func (s *Shipments) Label(ctx context.Context, id, format string) (*Label, error) {
+	if _, ok := labelFormats[format]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLabelFormat, format)
+	}
+	label, err := s.storedLabel(ctx, id, format)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return label, err
+	}
+	shipment, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveLabel(ctx, shipment, format); err != nil {
+		return nil, err
+	}
+	// 并发生成同一张面单时以先保存的为准
+	return s.storedLabel(ctx, id, format)
+}
+
+// generateLabels 在创建运单后生成全部格式的面单, 失败只记录日志, 取面单时会再次生成
+func (s *Shipments) generateLabels(ctx context.Context, shipment *Shipment) {
+	for format := range labelFormats {
+		if err := s.saveLabel(ctx, shipment, format); err != nil {
+			logger.Ctx(ctx).Error().Err(err).Str("shipment_id", shipment.ID).Str("format", format).Msg("Failed to generate shipping label")
+		}
+	}
+}
+
+func (s *Shipments) saveLabel(ctx context.Context, shipment *Shipment, format string) error {
+	content, err := labelFormats[format].render(shipment)
+	if err != nil {
+		return fmt.Errorf("render %s label: %w", format, err)
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT IGNORE INTO shipment_label (shipment_id, format, content) VALUES (?, ?, ?)`,
+		shipment.ID, format, content); err != nil {
+		return fmt.Errorf("save %s label: %w", format, err)
+	}
+	return nil
+}
+
+func (s *Shipments) storedLabel(ctx context.Context, id, format string) (*Label, error) {
+	label := &Label{ShipmentID: id, Format: format, ContentType: labelFormats[format].contentType}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT content, created_at FROM shipment_label WHERE shipment_id = ? AND format = ?`, id, format,
+	).Scan(&label.Content, &label.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("query shipment label: %w", err)
+	}
+	return label, nil
+}
+
+// 面单上每个地址块最多占用的行数 (折行之后), 超出的部分截断, 保证条码和底部信息不会被挤出页面
+const (
+	labelFromMaxLines = 5
+	labelToMaxLines   = 7
+)
+
+// labelEllipsis 标记被截断的行, 只用 ASCII 字符, 热敏打印机的任何字体都能打印
+const labelEllipsis = "..."
+
+// textWidth 估算文本的宽度, 以字号 (em) 为单位: 中日韩等全角字符占 1 em, 其它字符按 0.65 em 估算,
+// 不小于面单所用字体中常见字符的平均宽度, 折行后一般不会超出版面
+func textWidth(s string) float64 {
+	width := 0.0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+func runeWidth(r rune) float64 {
+	// 0x3000-0x303F 是中文标点, 0xFF00-0xFFEF 是全角字符
+	if unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF) {
+		return 1
+	}
+	return 0.65
+}
+
+// wrapLines 把每一行按 maxEm (以 em 计的可用宽度) 折行, 总行数不超过 maxLines, 超出时截断最后一行并加上省略号。
+// 优先在空格处折行; 中文等没有空格的文本在任意字符处折行。
+func wrapLines(lines []string, maxEm float64, maxLines int) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, wrapLine(line, maxEm)...)
+	}
+	if len(out) <= maxLines {
+		return out
+	}
+	out = out[:maxLines]
+	last := out[maxLines-1]
+	for last != "" && textWidth(last+labelEllipsis) > maxEm {
+		_, size := utf8.DecodeLastRuneInString(last)
+		last = last[:len(last)-size]
+	}
+	out[maxLines-1] = strings.TrimRight(last, " ") + labelEllipsis
+	return out
+}
+
+func wrapLine(line string, maxEm float64) []string {
+	var (
+		out   []string
+		start = 0  // 当前行在 line 中的起始位置
+		space = -1 // 当前行中最后一个空格的位置
+		width = 0.0
+	)
+	for i, r := range line {
+		if r == ' ' {
+			space = i
+		}
+		width += runeWidth(r)
+		if width <= maxEm || i == start {
+			continue
+		}
+		end, next := i, i
+		if space > start {
+			end, next = space, space+1 // 在最后一个空格处折行, 空格不带到下一行
+		}
+		if segment := strings.TrimSpace(line[start:end]); segment != "" {
+			out = append(out, segment)
+		}
+		start, space = next, -1
+		width = textWidth(line[start : i+utf8.RuneLen(r)])
+	}
+	if rest := strings.TrimSpace(line[start:]); rest != "" || len(out) == 0 {
+		out = append(out, rest)
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+// internal/shipping/label_pdf.go
+package shipping
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF 面单为 4x6 英寸 (288x432 pt), 适合办公打印机打印后裁切或直接用热敏打印机的 PDF 驱动打印。
+// 中文使用 PDF 阅读器内置的 STSong-Light (不嵌入字体), 运单号等 ASCII 文本使用 Helvetica。
+// 地址按页面宽度折行, 每个地址块的行数有上限, 过长的地址会被截断。
+const (
+	pdfPageWidth  = 288
+	pdfPageHeight = 432
+	pdfMargin     = 14
+)
+
+// pdfPage 累积一页的绘图指令
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+// text 在 (x, y) 处输出一行文本, y 是基线到页面底部的距离
+func (p *pdfPage) text(x, y, size float64, s string) {
+	if isASCII(s) {
+		escaped := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+		fmt.Fprintf(&p.content, "BT /F2 %.1f Tf %.2f %.2f Td (%s) Tj ET\n", size, x, y, escaped)
+		return
+	}
+	// STSong-Light 配合 UniGB-UCS2-H 编码, 文本按 UTF-16BE 写成十六进制; 超出基本平面的字符不支持, 以空格代替
+	var hex strings.Builder
+	for _, r := range s {
+		if r > 0xFFFF {
+			r = ' '
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&hex, "%04X", u)
+		}
+	}
+	fmt.Fprintf(&p.content, "BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, y, hex.String())
+}
+
+// line 画一条水平线
+func (p *pdfPage) line(x1, x2, y, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y, x2, y)
+}
+
+// barcode 在 (x, y) 处画条码, 条码宽度为 width, 高度为 height
+func (p *pdfPage) barcode(modules []int, x, y, width, height float64) {
+	total := 0
+	for _, m := range modules {
+		total += m
+	}
+	unit := width / float64(total)
+	for i, m := range modules {
+		if i%2 == 0 {
+			fmt.Fprintf(&p.content, "%.3f %.2f %.3f %.2f re\n", x, y, float64(m)*unit, height)
+		}
+		x += float64(m) * unit
+	}
+	p.content.WriteString("f\n")
+}
+
+// renderPDF 生成 PDF 面单
+func renderPDF(shipment *Shipment) ([]byte, error) {
+	modules, err := code128Modules(shipment.TrackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	var page pdfPage
+	left, right := float64(pdfMargin), float64(pdfPageWidth-pdfMargin)
+	y := float64(pdfPageHeight - pdfMargin - 18)
+
+	page.text(left, y, 18, strings.ToUpper(shipment.Carrier))
+	page.text(right-90, y, 12, strings.ToUpper(shipment.ServiceLevel))
+	y -= 10
+	page.line(left, right, y, 1.5)
+
+	y -= 14
+	page.text(left, y, 8, "FROM 寄件人")
+	for _, l := range wrapLines(addressLines(shipment.From), (right-left)/9, labelFromMaxLines) {
+		y -= 11
+		page.text(left, y, 9, l)
+	}
+	y -= 8
+	page.line(left, right, y, 0.5)
+
+	y -= 16
+	page.text(left, y, 10, "TO 收件人")
+	for _, l := range wrapLines(addressLines(shipment.To), (right-left)/14, labelToMaxLines) {
+		y -= 17
+		page.text(left, y, 14, l)
+	}
+	y -= 10
+	page.line(left, right, y, 1.5)
+
+	y -= 90
+	page.barcode(modules, left+10, y, right-left-20, 80)
+	y -= 16
+	page.text(left+10, y, 14, shipment.TrackingNumber)
+	y -= 8
+	page.line(left, right, y, 0.5)
+
+	y -= 14
+	page.text(left, y, 8, "Order 订单: "+shipment.OrderID)
+	y -= 11
+	page.text(left, y, 8, fmt.Sprintf("Weight 重量: %.2f kg", float64(shipment.Parcel.WeightGrams)/1000))
+	y -= 11
+	page.text(left, y, 8, "Shipment: "+shipment.ID)
+	y -= 11
+	page.text(left, y, 8, "Created: "+shipment.CreatedAt.Format("2006-01-02 15:04"))
+
+	return writePDF(page.content.Bytes()), nil
+}
+
+// writePDF 把一页内容写成一个完整的 PDF 文件
+func writePDF(content []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 5 0 R /F2 8 0 R >> >> /Contents 4 0 R >>",
+			pdfPageWidth, pdfPageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [6 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 7 0 R /DW 1000 /W [1 95 500] >>",
+		"<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+			"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// addressLines 把地址排成面单上的几行, 空的字段不占行
+func addressLines(a Address) []string {
+	var lines []string
+	if person := strings.TrimSpace(a.Name + " " + a.Phone); person != "" {
+		lines = append(lines, person)
+	}
+	for _, l := range []string{a.Line1, a.Line2} {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	var place []string
+	for _, part := range []string{a.City, a.State, a.PostalCode, a.Country} {
+		if part != "" {
+			place = append(place, part)
+		}
+	}
+	if len(place) > 0 {
+		lines = append(lines, strings.Join(place, " "))
+	}
+	return lines
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// internal/shipping/label_zpl.go
+package shipping
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ZPL 面单为 4x6 英寸, 按 203 dpi 的热敏打印机排版 (812x1218 点)。
+// 文本以 UTF-8 输出 (^CI28)。打印机内置的 ^A0 等字体没有中文字形, 面单用 ^CW 把 zplFontFile 映射为字体 J,
+// 所有文本都用这个字体打印。打印机上需要先安装这个 TrueType 字体: 从 Zebra 官网下载
+// "Andale Mono Simplified Chinese" 字体包 (ANMDS.TTF), 用 Zebra Setup Utilities 或 ZebraNet Bridge 下载到打印机的 E: 盘;
+// 换用其它中文字体时修改 zplFontFile 即可。
+const (
+	zplWidth    = 812
+	zplHeight   = 1218
+	zplMargin   = 40
+	zplFontFile = "E:ANMDS.TTF"
+	// zplQuietZone 是条码两侧留白的模块数, Code 128 要求至少 10 个
+	zplQuietZone = 10
+	// zplMaxModuleWidth 是条码窄条的最大宽度 (点), 运单号较短时条码不会过宽
+	zplMaxModuleWidth = 3
+)
+
+// renderZPL 生成 ZPL 面单, 条码使用打印机内置的 Code 128 (^BC)
+func renderZPL(shipment *Shipment) ([]byte, error) {
+	modules, err := code128Modules(shipment.TrackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	// 按条码的模块数选择窄条宽度 (^BY), 保证条码连同两侧留白放得进面单
+	total := 2 * zplQuietZone
+	for _, m := range modules {
+		total += m
+	}
+	moduleWidth := min(zplMaxModuleWidth, (zplWidth-2*zplMargin)/total)
+	if moduleWidth < 1 {
+		return nil, fmt.Errorf("tracking number %q is too long for a %d dot wide barcode", shipment.TrackingNumber, zplWidth-2*zplMargin)
+	}
+	barcodeX := (zplWidth - (total-2*zplQuietZone)*moduleWidth) / 2
+
+	var b strings.Builder
+	y := zplMargin
+	field := func(x, size int, s string) {
+		fmt.Fprintf(&b, "^FO%d,%d^AJN,%d,%d^FD%s^FS\n", x, y, size, size, zplEscape(s))
+	}
+	// maxEm 是字号为 size 的文本一行能放下的宽度 (以 em 计)
+	maxEm := func(size int) float64 {
+		return float64(zplWidth-2*zplMargin) / float64(size)
+	}
+	rule := func(thickness int) {
+		fmt.Fprintf(&b, "^FO%d,%d^GB%d,%d,%d^FS\n", zplMargin, y, zplWidth-2*zplMargin, thickness, thickness)
+	}
+
+	b.WriteString("^XA\n^CI28\n")
+	fmt.Fprintf(&b, "^CWJ,%s\n", zplFontFile)
+	fmt.Fprintf(&b, "^PW%d\n^LL%d\n", zplWidth, zplHeight)
+	field(zplMargin, 60, strings.ToUpper(shipment.Carrier))
+	field(zplWidth-zplMargin-260, 40, strings.ToUpper(shipment.ServiceLevel))
+	y += 80
+	rule(4)
+
+	y += 20
+	field(zplMargin, 26, "FROM")
+	for _, l := range wrapLines(addressLines(shipment.From), maxEm(28), labelFromMaxLines) {
+		y += 32
+		field(zplMargin, 28, l)
+	}
+	y += 44
+	rule(2)
+
+	y += 20
+	field(zplMargin, 30, "TO")
+	for _, l := range wrapLines(addressLines(shipment.To), maxEm(44), labelToMaxLines) {
+		y += 48
+		field(zplMargin, 44, l)
+	}
+	y += 64
+	rule(4)
+
+	y += 40
+	fmt.Fprintf(&b, "^FO%d,%d^BY%d^BCN,200,Y,N,N^FD%s^FS\n", barcodeX, y, moduleWidth, zplEscape(shipment.TrackingNumber))
+	y += 270
+	rule(2)
+
+	y += 20
+	field(zplMargin, 24, "Order: "+shipment.OrderID)
+	y += 32
+	field(zplMargin, 24, fmt.Sprintf("Weight: %.2f kg", float64(shipment.Parcel.WeightGrams)/1000))
+	y += 32
+	field(zplMargin, 24, "Shipment: "+shipment.ID)
+	y += 32
+	field(zplMargin, 24, "Created: "+shipment.CreatedAt.Format("2006-01-02 15:04"))
+	b.WriteString("^XZ\n")
+	return []byte(b.String()), nil
+}
+
+// zplEscape 去掉字段数据中的 ^ 和 ~, 它们在 ZPL 中是指令前缀
+func zplEscape(s string) string {
+	return strings.NewReplacer("^", " ", "~", " ").Replace(s)
+}
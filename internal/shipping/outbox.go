@@ -0,0 +1,125 @@
+// internal/shipping/outbox.go
+package shipping
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// outboxErrorLimit 是发件箱中记录的最近一次发布错误的最大长度, 与 last_error 列一致
+const outboxErrorLimit = 255
+
+// 运单事件经发件箱发布: insertEvent 在改变运单状态的同一个事务中把事件写入 shipment_outbox,
+// RelayOutbox 再按写入顺序把它们发布到 shipping-events 主题, 发布成功后删除。
+// 事务提交后进程退出或 Kafka 不可用时事件不会丢失, 只会延后发布; 极端情况下同一事件可能发布两次。
+
+type outboxMessage struct {
+	id    int64
+	event ShipmentEvent
+	trace propagation.MapCarrier
+}
+
+// insertOutbox 在事务中登记一条待发布的事件, 同时保存当前的追踪上下文, 发布时接回原来的链路
+func insertOutbox(ctx context.Context, tx *sql.Tx, e ShipmentEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal shipment event: %w", err)
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	traceContext, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("marshal trace context: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO shipment_outbox (shipment_id, payload, trace_context) VALUES (?, ?, ?)`,
+		e.ShipmentID, payload, traceContext); err != nil {
+		return fmt.Errorf("insert shipment outbox: %w", err)
+	}
+	return nil
+}
+
+// OutboxReady 在有新事件写入发件箱后收到通知, 转发方据此立即调用 RelayOutbox, 不必等下一个周期
+func (s *Shipments) OutboxReady() <-chan struct{} {
+	return s.outboxReady
+}
+
+// notifyOutbox 在事务提交后唤醒转发方, 已经有未处理的通知时直接返回
+func (s *Shipments) notifyOutbox() {
+	select {
+	case s.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// RelayOutbox 按写入顺序发布发件箱中最早的至多 limit 条事件, 返回发布的数量。
+// 遇到发布失败时停止, 记录错误并把剩下的事件留给下一轮, 保证同一运单的事件按顺序到达;
+// 行锁让多个实例的转发串行执行, 不会同时发布同一批事件。没有配置 publisher 时不做任何事。
+func (s *Shipments) RelayOutbox(ctx context.Context, limit int) (int, error) {
+	if s.publisher == nil {
+		return 0, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, payload, trace_context FROM shipment_outbox ORDER BY id LIMIT ? FOR UPDATE`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("query shipment outbox: %w", err)
+	}
+	var messages []outboxMessage
+	for rows.Next() {
+		var (
+			m                     outboxMessage
+			payload, traceContext []byte
+		)
+		if err := rows.Scan(&m.id, &payload, &traceContext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan shipment outbox: %w", err)
+		}
+		if err := json.Unmarshal(payload, &m.event); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("decode shipment outbox %d: %w", m.id, err)
+		}
+		// 追踪上下文只用于串联链路, 解析失败时不影响发布
+		_ = json.Unmarshal(traceContext, &m.trace)
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("query shipment outbox: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, m := range messages {
+		pubCtx := otel.GetTextMapPropagator().Extract(ctx, m.trace)
+		if publishErr = s.publisher.PublishShipmentEvent(pubCtx, m.event); publishErr != nil {
+			msg := publishErr.Error()
+			if len(msg) > outboxErrorLimit {
+				msg = msg[:outboxErrorLimit]
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE shipment_outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?`, msg, m.id); err != nil {
+				return published, fmt.Errorf("record shipment outbox failure: %w", err)
+			}
+			publishErr = fmt.Errorf("publish shipment event %d of %s: %w", m.id, m.event.ShipmentID, publishErr)
+			break
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM shipment_outbox WHERE id = ?`, m.id); err != nil {
+			return published, fmt.Errorf("delete shipment outbox: %w", err)
+		}
+		published++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
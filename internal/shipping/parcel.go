@@ -0,0 +1,204 @@
+// internal/shipping/parcel.go
+package shipping
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownItem 商品没有维护重量和尺寸, 无法计算运费
+var ErrUnknownItem = errors.New("unknown shipping item")
+
+// ErrInvalidItem 请求中的商品行不合法
+var ErrInvalidItem = errors.New("invalid shipping item")
+
+// Item 是需要寄送的一行商品
+type Item struct {
+	ItemID   string `json:"itemId"`
+	Quantity int64  `json:"quantity"`
+}
+
+// ItemProfile 是一个 SKU 的单件重量和包装尺寸
+type ItemProfile struct {
+	SKU         string `json:"sku"`
+	WeightGrams int64  `json:"weightGrams"`
+	LengthMm    int64  `json:"lengthMm"`
+	WidthMm     int64  `json:"widthMm"`
+	HeightMm    int64  `json:"heightMm"`
+}
+
+// volumeMm3 返回单件的包装体积
+func (p ItemProfile) volumeMm3() int64 {
+	return p.LengthMm * p.WidthMm * p.HeightMm
+}
+
+// longestSide 返回单件包装最长的一边
+func (p ItemProfile) longestSide() int64 {
+	return max(p.LengthMm, p.WidthMm, p.HeightMm)
+}
+
+// Parcel 是一个包裹: 由若干商品合并而成, 重量和体积是各件之和
+type Parcel struct {
+	Items       []Item `json:"items"`
+	WeightGrams int64  `json:"weightGrams"`
+	VolumeCm3   int64  `json:"volumeCm3"`
+	// LongestSideMm 是包裹中最长的单件边长, 用于判断承运商的尺寸限制
+	LongestSideMm int64 `json:"longestSideMm"`
+}
+
+// ItemStore 从 MySQL 读取商品的重量和尺寸
+type ItemStore struct {
+	db *sql.DB
+}
+
+// NewItemStore 创建商品尺寸查询
+func NewItemStore(db *sql.DB) *ItemStore {
+	return &ItemStore{db: db}
+}
+
+// Profiles 批量查询 SKU 的重量和尺寸, 任意一个 SKU 不存在都会返回 ErrUnknownItem
+func (s *ItemStore) Profiles(ctx context.Context, skus []string) (map[string]ItemProfile, error) {
+	if len(skus) == 0 {
+		return map[string]ItemProfile{}, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(skus)), ",")
+	args := make([]any, len(skus))
+	for i, sku := range skus {
+		args[i] = sku
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT sku, weight_grams, length_mm, width_mm, height_mm FROM shipping_item WHERE sku IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query shipping items: %w", err)
+	}
+	defer rows.Close()
+	profiles := make(map[string]ItemProfile, len(skus))
+	for rows.Next() {
+		var p ItemProfile
+		if err := rows.Scan(&p.SKU, &p.WeightGrams, &p.LengthMm, &p.WidthMm, &p.HeightMm); err != nil {
+			return nil, fmt.Errorf("scan shipping item: %w", err)
+		}
+		profiles[p.SKU] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, sku := range skus {
+		if _, ok := profiles[sku]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownItem, sku)
+		}
+	}
+	return profiles, nil
+}
+
+// BuildParcel 把商品合并成一个包裹
+func (s *ItemStore) BuildParcel(ctx context.Context, items []Item) (Parcel, error) {
+	if len(items) == 0 {
+		return Parcel{}, fmt.Errorf("%w: no items to ship", ErrInvalidItem)
+	}
+	skus := make([]string, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return Parcel{}, fmt.Errorf("%w: quantity %d for %s", ErrInvalidItem, item.Quantity, item.ItemID)
+		}
+		if !seen[item.ItemID] {
+			seen[item.ItemID] = true
+			skus = append(skus, item.ItemID)
+		}
+	}
+	profiles, err := s.Profiles(ctx, skus)
+	if err != nil {
+		return Parcel{}, err
+	}
+	return newParcel(items, profiles), nil
+}
+
+// newParcel 按商品尺寸汇总包裹的重量、体积和最长边
+func newParcel(items []Item, profiles map[string]ItemProfile) Parcel {
+	parcel := Parcel{Items: items}
+	var volumeMm3 int64
+	for _, item := range items {
+		p := profiles[item.ItemID]
+		parcel.WeightGrams += p.WeightGrams * item.Quantity
+		volumeMm3 += p.volumeMm3() * item.Quantity
+		parcel.LongestSideMm = max(parcel.LongestSideMm, p.longestSide())
+	}
+	// 体积向上取整到立方厘米
+	parcel.VolumeCm3 = (volumeMm3 + 999) / 1000
+	return parcel
+}
+
+// AllocatedItem 是分配到某个发货地区 (仓库) 的一行商品, Origin 是地区代码 (ISO 3166)
+type AllocatedItem struct {
+	Item
+	Origin string `json:"origin"`
+}
+
+// OriginGroup 是从同一个发货地区发出的商品, 合并成一个包裹
+type OriginGroup struct {
+	Origin string
+	Items  []Item
+}
+
+// 发货方案的规模上限: 每个发货地区都要向全部承运商询价, 允许集中发货时询价次数还要翻倍,
+// 超出上限的请求直接拒绝, 避免一次请求放大成大量承运商调用
+const (
+	MaxPlanItems   = 200 // 商品行数
+	MaxPlanOrigins = 8   // 发货地区数
+)
+
+// GroupByOrigin 按发货地区把商品分组, 地区按首次出现的顺序排列。
+// 没有指定发货地区的商品归入 defaultOrigin, 同一地区内重复的商品合并数量。
+// 商品行数或发货地区数超出 MaxPlanItems / MaxPlanOrigins 时返回 ErrInvalidItem。
+func GroupByOrigin(items []AllocatedItem, defaultOrigin string) ([]OriginGroup, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no items to ship", ErrInvalidItem)
+	}
+	if len(items) > MaxPlanItems {
+		return nil, fmt.Errorf("%w: %d lines exceed the limit of %d", ErrInvalidItem, len(items), MaxPlanItems)
+	}
+	var groups []OriginGroup
+	index := make(map[string]int)
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity %d for %s", ErrInvalidItem, item.Quantity, item.ItemID)
+		}
+		origin := strings.ToUpper(strings.TrimSpace(item.Origin))
+		if origin == "" {
+			origin = defaultOrigin
+		}
+		i, ok := index[origin]
+		if !ok {
+			if len(groups) == MaxPlanOrigins {
+				return nil, fmt.Errorf("%w: more than %d origins", ErrInvalidItem, MaxPlanOrigins)
+			}
+			i = len(groups)
+			index[origin] = i
+			groups = append(groups, OriginGroup{Origin: origin})
+		}
+		groups[i].Items = append(groups[i].Items, item.Item)
+	}
+	for i := range groups {
+		groups[i].Items = MergeItems(groups[i].Items)
+	}
+	return groups, nil
+}
+
+// MergeItems 合并重复的商品行, 保持商品首次出现的顺序
+func MergeItems(items []Item) []Item {
+	merged := make([]Item, 0, len(items))
+	index := make(map[string]int, len(items))
+	for _, item := range items {
+		if i, ok := index[item.ItemID]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ItemID] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
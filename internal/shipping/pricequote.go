@@ -0,0 +1,111 @@
+// internal/shipping/pricequote.go
+package shipping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"nexus/internal/money"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 校验商品报价令牌失败的原因
+var (
+	// ErrInvalidPriceQuote 报价令牌无效、已过期或与运费报价的商品不符
+	ErrInvalidPriceQuote = errors.New("invalid price quote")
+	// ErrPricingUnavailable pricing-service 不可用, 无法校验报价令牌
+	ErrPricingUnavailable = errors.New("pricing service unavailable")
+)
+
+// PriceQuote 是 pricing-service 校验通过的商品报价, 运费优惠按其中的金额和用户分群匹配。
+// Segments 是 pricing-service 签发时按用户 ID 从 user_segment 表查出的分群, 不是计价请求中调用方填写的分群。
+type PriceQuote struct {
+	QuoteID  string      `json:"quoteId"`
+	Segments []string    `json:"segments"`
+	Total    money.Money `json:"total"`
+}
+
+// PriceQuoteVerifier 通过 pricing-service 的 POST /verify_quote 校验商品报价令牌。
+// 购物车金额和用户分群都取自 pricing-service 签名的报价, shipping-service 的调用方不能直接传入;
+// 令牌由 pricing-service 签名, shipping-service 不需要持有签名密钥。
+type PriceQuoteVerifier struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewPriceQuoteVerifier 创建一个调用 pricing-service 的报价校验器
+func NewPriceQuoteVerifier(baseURL string) *PriceQuoteVerifier {
+	return &PriceQuoteVerifier{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+// Verify 校验报价令牌, 并要求报价覆盖的商品和数量与 items 完全一致。
+// 令牌无效、过期或商品不符时返回 ErrInvalidPriceQuote, pricing-service 出错时返回 ErrPricingUnavailable。
+func (v *PriceQuoteVerifier) Verify(ctx context.Context, token string, items []Item) (*PriceQuote, error) {
+	ctx, span := otel.Tracer("shipping-pricing").Start(ctx, "call-pricing-service", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	body, err := json.Marshal(struct {
+		Token string `json:"token"`
+		Items []Item `json:"items"`
+	}{Token: token, Items: items})
+	if err != nil {
+		return nil, err
+	}
+	reqURL := v.baseURL + "/verify_quote"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	span.SetAttributes(attribute.String("http.url", reqURL), attribute.String("http.method", http.MethodPost))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrPricingUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Valid  bool        `json:"valid"`
+		Reason string      `json:"reason"`
+		Quote  *PriceQuote `json:"quote"`
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusConflict, http.StatusGone, http.StatusUnprocessableEntity:
+		// 这些状态表示令牌本身不可用, 把 pricing-service 给出的原因带给调用方
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		err := fmt.Errorf("%w: %s", ErrInvalidPriceQuote, strings.TrimSpace(result.Reason))
+		span.RecordError(err)
+		return nil, err
+	default:
+		err := fmt.Errorf("%w: verify_quote returned status %s", ErrPricingUnavailable, resp.Status)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: decode verify_quote response: %v", ErrPricingUnavailable, err)
+	}
+	if !result.Valid || result.Quote == nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPriceQuote, result.Reason)
+	}
+	span.SetAttributes(attribute.String("quote.id", result.Quote.QuoteID))
+	return result.Quote, nil
+}
@@ -0,0 +1,281 @@
+// internal/shipping/rates.go
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RatesDataID 是运费费率表在 Nacos 中的 Data ID
+const RatesDataID = "nexus-shipping-rates.yaml"
+
+// 服务等级
+const (
+	ServiceStandard = "standard"
+	ServiceExpress  = "express"
+	ServiceSameDay  = "same_day"
+)
+
+// AnyZone 在线路中匹配任意区域
+const AnyZone = "*"
+
+var (
+	// ErrUnknownZone 地区没有配置对应的运费区域
+	ErrUnknownZone = errors.New("unknown shipping zone")
+	// ErrNoOption 没有任何承运商和服务等级可以寄送这个包裹
+	ErrNoOption = errors.New("no shipping option available")
+)
+
+// RatesConfig 是 nexus-shipping-rates.yaml 的结构
+type RatesConfig struct {
+	// Zones 把地区代码 (ISO 3166, 例如 CN-SH、US-CA、DE) 映射到运费区域
+	Zones    map[string]string `yaml:"zones"`
+	Carriers []CarrierRates    `yaml:"carriers"`
+}
+
+// CarrierRates 是一个承运商各服务等级的费率
+type CarrierRates struct {
+	Code string `yaml:"code"`
+	Name string `yaml:"name"`
+	// DimDivisor 是体积重系数 (立方厘米/千克), 计费重量取实重和体积重中较大的一个; 为 0 时不计体积重
+	DimDivisor int64         `yaml:"dimDivisor"`
+	Services   []ServiceRate `yaml:"services"`
+}
+
+// ServiceRate 是一个服务等级的限制和各条线路的价格
+type ServiceRate struct {
+	Level          string `yaml:"level"`
+	MaxWeightGrams int64  `yaml:"maxWeightGrams"` // 计费重量上限, 0 表示不限
+	MaxSideMm      int64  `yaml:"maxSideMm"`      // 单边长度上限, 0 表示不限
+	Lanes          []Lane `yaml:"lanes"`
+}
+
+// Lane 是从一个区域到另一个区域的价格: 首重价格加上每个续重单位的价格。
+// 线路按配置顺序匹配, 第一条匹配的生效, 具体的线路应当写在 "*" 之前。
+type Lane struct {
+	From             string `yaml:"from"`
+	To               string `yaml:"to"`
+	FirstWeightGrams int64  `yaml:"firstWeightGrams"` // 默认 1000
+	FirstPrice       string `yaml:"firstPrice"`
+	StepGrams        int64  `yaml:"stepGrams"` // 默认 1000
+	StepPrice        string `yaml:"stepPrice"`
+	MinDays          int    `yaml:"minDays"` // 在途天数, 由 DeliveryCalendar 按工作日换算成送达日期
+	MaxDays          int    `yaml:"maxDays"`
+
+	firstPrice money.Money
+	stepPrice  money.Money
+}
+
+// DeliveryWindow 是预计送达的时间范围。承运商报价时按自然日从询价时间起算,
+// 经过 DeliveryCalendar 后按截单时间和工作日历给出具体的发货日和送达日期。
+type DeliveryWindow struct {
+	MinDays  int       `json:"minDays"`
+	MaxDays  int       `json:"maxDays"`
+	Earliest time.Time `json:"earliest"`
+	Latest   time.Time `json:"latest"`
+	// ShipDate、EarliestDate 和 LatestDate 是发货日和最早、最晚送达日 (2006-01-02), 送达日按目的地的日历计
+	ShipDate     string `json:"shipDate,omitempty"`
+	EarliestDate string `json:"earliestDate,omitempty"`
+	LatestDate   string `json:"latestDate,omitempty"`
+	// OrderBy 是赶上 ShipDate 发货的截单时间, 发货地区没有截单时间时为空
+	OrderBy  *time.Time `json:"orderBy,omitempty"`
+	TimeZone string     `json:"timeZone,omitempty"` // 目的地的时区
+}
+
+// Option 是一个可选的寄送方式
+type Option struct {
+	Carrier               string         `json:"carrier"`
+	CarrierName           string         `json:"carrierName"`
+	ServiceLevel          string         `json:"serviceLevel"`
+	Cost                  money.Money    `json:"cost"`
+	ChargeableWeightGrams int64          `json:"chargeableWeightGrams"`
+	Delivery              DeliveryWindow `json:"delivery"`
+	// OriginalCost 和 Discount 只在运费优惠规则生效时出现, Cost 是优惠后的运费
+	OriginalCost *money.Money     `json:"originalCost,omitempty"`
+	Discount     *AppliedDiscount `json:"discount,omitempty"`
+}
+
+// compile 校验线路并把价格解析为 currency 的精确金额
+func (l *Lane) compile(currency money.Currency) error {
+	if l.From == "" || l.To == "" {
+		return fmt.Errorf("lane needs from and to zones")
+	}
+	if l.FirstWeightGrams == 0 {
+		l.FirstWeightGrams = 1000
+	}
+	if l.StepGrams == 0 {
+		l.StepGrams = 1000
+	}
+	if l.FirstWeightGrams < 0 || l.StepGrams < 0 {
+		return fmt.Errorf("lane %s->%s: weights must be positive", l.From, l.To)
+	}
+	if l.MinDays < 0 || l.MaxDays < l.MinDays {
+		return fmt.Errorf("lane %s->%s: need 0 <= minDays <= maxDays", l.From, l.To)
+	}
+	var err error
+	if l.firstPrice, err = money.Parse(l.FirstPrice, currency); err != nil {
+		return fmt.Errorf("lane %s->%s: firstPrice: %w", l.From, l.To, err)
+	}
+	l.stepPrice = money.Zero(currency)
+	if l.StepPrice != "" {
+		if l.stepPrice, err = money.Parse(l.StepPrice, currency); err != nil {
+			return fmt.Errorf("lane %s->%s: stepPrice: %w", l.From, l.To, err)
+		}
+	}
+	if l.firstPrice.IsNegative() || l.stepPrice.IsNegative() {
+		return fmt.Errorf("lane %s->%s: prices must not be negative", l.From, l.To)
+	}
+	return nil
+}
+
+// matches 判断线路是否覆盖 from -> to
+func (l *Lane) matches(from, to string) bool {
+	return (l.From == AnyZone || l.From == from) && (l.To == AnyZone || l.To == to)
+}
+
+// cost 按首重和续重计算运费, 续重不足一个单位按一个单位计
+func (l *Lane) cost(chargeableGrams int64) money.Money {
+	cost := l.firstPrice
+	if extra := chargeableGrams - l.FirstWeightGrams; extra > 0 {
+		steps := (extra + l.StepGrams - 1) / l.StepGrams
+		cost = cost.Add(l.stepPrice.Mul(steps))
+	}
+	return cost
+}
+
+// chargeableWeight 返回计费重量: 实重和体积重中较大的一个
+func (c *CarrierRates) chargeableWeight(p Parcel) int64 {
+	if c.DimDivisor <= 0 {
+		return p.WeightGrams
+	}
+	// 体积重 (克) = 体积 (立方厘米) / 系数 * 1000, 向上取整
+	volumetric := (p.VolumeCm3*1000 + c.DimDivisor - 1) / c.DimDivisor
+	return max(p.WeightGrams, volumetric)
+}
+
+// RateTable 持有当前生效的费率表, 支持热更新
+type RateTable struct {
+	currency money.Currency
+	cfg      atomic.Pointer[RatesConfig]
+}
+
+// NewRateTable 创建一个空的费率表, 费率中的金额按 currency 解析
+func NewRateTable(currency money.Currency) *RateTable {
+	t := &RateTable{currency: currency}
+	t.cfg.Store(&RatesConfig{})
+	return t
+}
+
+// Currency 返回费率的货币
+func (t *RateTable) Currency() money.Currency {
+	return t.currency
+}
+
+// Update 用新的配置替换当前费率表。有任何一条线路不合法时整份配置都不生效。
+func (t *RateTable) Update(cfg RatesConfig) {
+	zones := make(map[string]string, len(cfg.Zones))
+	for region, zone := range cfg.Zones {
+		zones[strings.ToUpper(region)] = zone
+	}
+	cfg.Zones = zones
+	carriers := slices.Clone(cfg.Carriers)
+	for i := range carriers {
+		c := &carriers[i]
+		if c.Code == "" {
+			logger.Logger.Printf("❌ ERROR: Invalid shipping rates, keeping previous rates: carrier without code")
+			return
+		}
+		c.Services = slices.Clone(c.Services)
+		for j := range c.Services {
+			s := &c.Services[j]
+			switch s.Level {
+			case ServiceStandard, ServiceExpress, ServiceSameDay:
+			default:
+				logger.Logger.Printf("❌ ERROR: Invalid shipping rates, keeping previous rates: carrier %s: unknown service level %q", c.Code, s.Level)
+				return
+			}
+			s.Lanes = slices.Clone(s.Lanes)
+			for k := range s.Lanes {
+				if err := s.Lanes[k].compile(t.currency); err != nil {
+					logger.Logger.Printf("❌ ERROR: Invalid shipping rates, keeping previous rates: carrier %s %s: %v", c.Code, s.Level, err)
+					return
+				}
+			}
+		}
+	}
+	cfg.Carriers = carriers
+	t.cfg.Store(&cfg)
+	logger.Logger.Printf("✅ Shipping rates applied: %d zone mapping(s), %d carrier(s)", len(cfg.Zones), len(cfg.Carriers))
+}
+
+// Zone 返回地区所属的运费区域: 先精确匹配, 再退回国家代码 (CN-SH 退回 CN)
+func (t *RateTable) Zone(region string) (string, error) {
+	zones := t.cfg.Load().Zones
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if zone, ok := zones[region]; ok {
+		return zone, nil
+	}
+	if country, _, ok := strings.Cut(region, "-"); ok {
+		if zone, ok := zones[country]; ok {
+			return zone, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownZone, region)
+}
+
+// quote 返回 carrier 把包裹从 origin 寄到 destination 的可选方式, 不覆盖这条线路时返回空列表。
+// level 不为空时只返回该服务等级。送达时间按自然日从 now 起算。
+func (t *RateTable) quote(parcel Parcel, origin, destination, level string, now time.Time, carrier string) ([]Option, error) {
+	from, err := t.Zone(origin)
+	if err != nil {
+		return nil, err
+	}
+	to, err := t.Zone(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []Option{}
+	for _, c := range t.cfg.Load().Carriers {
+		if c.Code != carrier {
+			continue
+		}
+		chargeable := c.chargeableWeight(parcel)
+		for _, service := range c.Services {
+			if level != "" && service.Level != level {
+				continue
+			}
+			if service.MaxWeightGrams > 0 && chargeable > service.MaxWeightGrams {
+				continue
+			}
+			if service.MaxSideMm > 0 && parcel.LongestSideMm > service.MaxSideMm {
+				continue
+			}
+			idx := slices.IndexFunc(service.Lanes, func(l Lane) bool { return l.matches(from, to) })
+			if idx < 0 {
+				continue
+			}
+			lane := &service.Lanes[idx]
+			options = append(options, Option{
+				Carrier:               c.Code,
+				CarrierName:           c.Name,
+				ServiceLevel:          service.Level,
+				Cost:                  lane.cost(chargeable),
+				ChargeableWeightGrams: chargeable,
+				Delivery: DeliveryWindow{
+					MinDays:  lane.MinDays,
+					MaxDays:  lane.MaxDays,
+					Earliest: now.AddDate(0, 0, lane.MinDays),
+					Latest:   now.AddDate(0, 0, lane.MaxDays),
+				},
+			})
+		}
+	}
+	return options, nil
+}
@@ -0,0 +1,501 @@
+// internal/shipping/shipment.go
+package shipping
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/wangyingjie930/nexus-pkg/logger"
+	"nexus/internal/money"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 运单状态
+const (
+	StatusLabelCreated   = "label_created"
+	StatusPickedUp       = "picked_up"
+	StatusInTransit      = "in_transit"
+	StatusOutForDelivery = "out_for_delivery"
+	StatusDelivered      = "delivered"
+	StatusException      = "exception"
+)
+
+// 状态变化的来源
+const (
+	SourceCreate   = "create"
+	SourceTracking = "tracking"
+	SourceWebhook  = "webhook"
+	SourceManual   = "manual"
+)
+
+var (
+	// ErrShipmentNotFound 运单不存在
+	ErrShipmentNotFound = errors.New("shipment not found")
+	// ErrInvalidTransition 运单不能从当前状态变为目标状态
+	ErrInvalidTransition = errors.New("invalid shipment status transition")
+	// ErrInvalidShipment 创建运单的请求不合法
+	ErrInvalidShipment = errors.New("invalid shipment")
+)
+
+// idempotencyKeyMaxLen 是创建运单的幂等键的最大长度, 与 shipment.idempotency_key 列一致
+const idempotencyKeyMaxLen = 128
+
+// transitions 是运单的状态机: 正常情况下只能向前推进 (承运商可能漏扫, 允许跳过中间状态),
+// 任何未签收的状态都可以转为异常, 异常处理后可以恢复运输或直接签收; 签收是终态。
+var transitions = map[string][]string{
+	StatusLabelCreated:   {StatusPickedUp, StatusInTransit, StatusOutForDelivery, StatusDelivered, StatusException},
+	StatusPickedUp:       {StatusInTransit, StatusOutForDelivery, StatusDelivered, StatusException},
+	StatusInTransit:      {StatusOutForDelivery, StatusDelivered, StatusException},
+	StatusOutForDelivery: {StatusInTransit, StatusDelivered, StatusException},
+	StatusException:      {StatusInTransit, StatusOutForDelivery, StatusDelivered},
+	StatusDelivered:      {},
+}
+
+// CanTransition 判断运单能否从 from 变为 to
+func CanTransition(from, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// IsTerminal 判断状态是否为终态
+func IsTerminal(status string) bool {
+	next, ok := transitions[status]
+	return ok && len(next) == 0
+}
+
+// Shipment 是一个订单的一个包裹的运单
+type Shipment struct {
+	ID             string      `json:"id"`
+	OrderID        string      `json:"orderId"`
+	Carrier        string      `json:"carrier"`
+	ServiceLevel   string      `json:"serviceLevel"`
+	TrackingNumber string      `json:"trackingNumber"`
+	Status         string      `json:"status"`
+	Cost           money.Money `json:"cost"`
+	Parcel         Parcel      `json:"parcel"`
+	From           Address     `json:"from"`
+	To             Address     `json:"to"`
+	// LastEventAt 是最近一次应用的承运商轨迹时间, 同步轨迹时只处理在它之后的轨迹
+	LastEventAt *time.Time      `json:"lastEventAt,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	History     []ShipmentEvent `json:"history,omitempty"`
+}
+
+// ShipmentEvent 是运单的一次状态变化, 既写入 shipment_event 表, 也发布到 shipping-events 主题
+type ShipmentEvent struct {
+	ShipmentID     string    `json:"shipmentId"`
+	OrderID        string    `json:"orderId"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"trackingNumber"`
+	FromStatus     string    `json:"fromStatus,omitempty"`
+	ToStatus       string    `json:"toStatus"`
+	Source         string    `json:"source"`
+	Description    string    `json:"description,omitempty"`
+	Location       string    `json:"location,omitempty"`
+	OccurredAt     time.Time `json:"occurredAt"`
+}
+
+// CreateShipmentRequest 是为订单创建运单的请求。
+// IdempotencyKey 在同一个订单内唯一标识一个包裹, 重试时使用同一个值, 只会创建一个运单。
+type CreateShipmentRequest struct {
+	OrderID        string  `json:"orderId"`
+	IdempotencyKey string  `json:"idempotencyKey"`
+	Carrier        string  `json:"carrier"`
+	ServiceLevel   string  `json:"serviceLevel"`
+	Items          []Item  `json:"items"`
+	From           Address `json:"from"`
+	To             Address `json:"to"`
+}
+
+// Transition 描述一次状态变化的细节
+type Transition struct {
+	Status      string
+	Source      string
+	Description string
+	Location    string
+	OccurredAt  time.Time
+}
+
+// Shipments 管理运单: 向承运商下单, 持久化到 MySQL, 驱动状态机并发布每一次状态变化
+type Shipments struct {
+	db          *sql.DB
+	items       *ItemStore
+	carriers    *Registry
+	currency    money.Currency
+	publisher   EventPublisher
+	outboxReady chan struct{}
+}
+
+// NewShipments 创建运单服务, publisher 为 nil 时事件只保存在发件箱中, 不发布
+func NewShipments(db *sql.DB, items *ItemStore, carriers *Registry, currency money.Currency, publisher EventPublisher) *Shipments {
+	return &Shipments{
+		db: db, items: items, carriers: carriers, currency: currency, publisher: publisher,
+		outboxReady: make(chan struct{}, 1),
+	}
+}
+
+// Create 向承运商下单并保存运单, 初始状态为 label_created。
+// 同一订单用同一个幂等键重复请求时直接返回已创建的运单, replayed 为 true。
+func (s *Shipments) Create(ctx context.Context, req CreateShipmentRequest) (shipment *Shipment, replayed bool, err error) {
+	if req.OrderID == "" || req.Carrier == "" || req.IdempotencyKey == "" {
+		return nil, false, fmt.Errorf("%w: orderId, carrier and idempotencyKey are required", ErrInvalidShipment)
+	}
+	if len(req.IdempotencyKey) > idempotencyKeyMaxLen {
+		return nil, false, fmt.Errorf("%w: idempotencyKey is longer than %d", ErrInvalidShipment, idempotencyKeyMaxLen)
+	}
+	if existing, err := s.byIdempotencyKey(ctx, req.OrderID, req.IdempotencyKey); err != nil || existing != nil {
+		return existing, existing != nil, err
+	}
+	if req.ServiceLevel == "" {
+		req.ServiceLevel = ServiceStandard
+	}
+	// 收件地址不合法时拒绝创建运单; 发件地址是仓库地址, 只做规范化
+	to, _, errs := NormalizeAddress(req.To)
+	if len(errs) > 0 {
+		return nil, false, &AddressError{Field: "to", Errors: errs}
+	}
+	req.From, _, _ = NormalizeAddress(req.From)
+	req.To = to
+	carrier, err := s.carriers.Get(req.Carrier)
+	if err != nil {
+		return nil, false, err
+	}
+	parcel, err := s.items.BuildParcel(ctx, req.Items)
+	if err != nil {
+		return nil, false, err
+	}
+	created, err := carrier.CreateShipment(ctx, ShipmentRequest{
+		Reference: req.OrderID, ServiceLevel: req.ServiceLevel, Parcel: parcel,
+		From: req.From, To: req.To, Currency: s.currency,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	// 承运商那边的运单已经生成, 本地没有保存成功 (写入失败、提交失败或幂等键冲突) 时撤销, 避免留下无人管理的运单
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if cancelErr := carrier.Cancel(context.WithoutCancel(ctx), created.TrackingNumber); cancelErr != nil {
+			logger.Ctx(ctx).Error().Err(cancelErr).Str("tracking_number", created.TrackingNumber).Msg("Failed to cancel orphaned carrier shipment")
+		}
+	}()
+
+	now := time.Now()
+	shipment = &Shipment{
+		ID:             uuid.NewString(),
+		OrderID:        req.OrderID,
+		Carrier:        carrier.Code(),
+		ServiceLevel:   created.ServiceLevel,
+		TrackingNumber: created.TrackingNumber,
+		Status:         StatusLabelCreated,
+		Cost:           created.Cost,
+		Parcel:         parcel,
+		From:           req.From,
+		To:             req.To,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	parcelJSON, _ := json.Marshal(shipment.Parcel)
+	fromJSON, _ := json.Marshal(shipment.From)
+	toJSON, _ := json.Marshal(shipment.To)
+	event := shipment.event("", StatusLabelCreated, Transition{Source: SourceCreate, Description: "shipment created", OccurredAt: now})
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO shipment (id, order_id, idempotency_key, carrier, service_level, tracking_number, status, cost, currency, parcel, from_address, to_address)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		shipment.ID, shipment.OrderID, req.IdempotencyKey, shipment.Carrier, shipment.ServiceLevel, shipment.TrackingNumber, shipment.Status,
+		shipment.Cost.Decimal(), shipment.Cost.Currency().Code, parcelJSON, fromJSON, toJSON); err != nil {
+		// 并发的重试可能先一步用同一个幂等键保存了运单, 此时返回那一个
+		if existing, lookupErr := s.byIdempotencyKey(ctx, req.OrderID, req.IdempotencyKey); lookupErr == nil && existing != nil {
+			return existing, true, nil
+		}
+		return nil, false, fmt.Errorf("insert shipment: %w", err)
+	}
+	if err := insertEvent(ctx, tx, event); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	committed = true
+	s.notifyOutbox()
+	s.generateLabels(ctx, shipment)
+	shipment.History = []ShipmentEvent{event}
+	return shipment, false, nil
+}
+
+// byIdempotencyKey 返回订单中用该幂等键创建的运单及其状态变化, 不存在时返回 nil
+func (s *Shipments) byIdempotencyKey(ctx context.Context, orderID, key string) (*Shipment, error) {
+	shipments, err := s.query(ctx, `WHERE order_id = ? AND idempotency_key = ?`, orderID, key)
+	if err != nil || len(shipments) == 0 {
+		return nil, err
+	}
+	return s.Get(ctx, shipments[0].ID)
+}
+
+// Get 返回运单及其全部状态变化
+func (s *Shipments) Get(ctx context.Context, id string) (*Shipment, error) {
+	shipments, err := s.query(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(shipments) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrShipmentNotFound, id)
+	}
+	shipment := shipments[0]
+	if shipment.History, err = s.history(ctx, id); err != nil {
+		return nil, err
+	}
+	return shipment, nil
+}
+
+// ListByOrder 返回订单的全部运单, 按创建时间排列
+func (s *Shipments) ListByOrder(ctx context.Context, orderID string) ([]*Shipment, error) {
+	return s.query(ctx, `WHERE order_id = ? ORDER BY created_at, id`, orderID)
+}
+
+// Transition 把运单变为 t.Status 并记录和发布这次变化。
+// 状态与当前相同时不做任何事, 返回 changed=false; 状态机不允许的变化返回 ErrInvalidTransition。
+func (s *Shipments) Transition(ctx context.Context, id string, t Transition) (shipment *Shipment, changed bool, err error) {
+	if _, ok := transitions[t.Status]; !ok {
+		return nil, false, fmt.Errorf("%w: unknown status %q", ErrInvalidTransition, t.Status)
+	}
+	if t.OccurredAt.IsZero() {
+		t.OccurredAt = time.Now()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	shipments, err := scanShipments(tx.QueryContext(ctx, shipmentColumns+` WHERE id = ? FOR UPDATE`, id))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(shipments) == 0 {
+		return nil, false, fmt.Errorf("%w: %s", ErrShipmentNotFound, id)
+	}
+	shipment = shipments[0]
+	if shipment.Status == t.Status {
+		return shipment, false, nil
+	}
+	if !CanTransition(shipment.Status, t.Status) {
+		return shipment, false, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, shipment.Status, t.Status)
+	}
+
+	if _, err := apply(ctx, tx, shipment, t); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	s.notifyOutbox()
+	return shipment, true, nil
+}
+
+// apply 在事务中把已加锁的运单变为 t.Status 并记录这次变化, 调用方负责校验状态机、提交事务和发布事件
+func apply(ctx context.Context, tx *sql.Tx, shipment *Shipment, t Transition) (ShipmentEvent, error) {
+	event := shipment.event(shipment.Status, t.Status, t)
+	lastEventAt := shipment.LastEventAt
+	if t.Source != SourceManual && (lastEventAt == nil || t.OccurredAt.After(*lastEventAt)) {
+		lastEventAt = &t.OccurredAt
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE shipment SET status = ?, last_event_at = ? WHERE id = ?`, t.Status, lastEventAt, shipment.ID); err != nil {
+		return event, fmt.Errorf("update shipment status: %w", err)
+	}
+	if err := insertEvent(ctx, tx, event); err != nil {
+		return event, err
+	}
+	shipment.Status, shipment.LastEventAt, shipment.UpdatedAt = t.Status, lastEventAt, time.Now()
+	return event, nil
+}
+
+// Sync 从承运商拉取轨迹, 把上次同步之后的新轨迹依次应用到状态机上。
+// 承运商无法映射的状态码, 以及状态机不允许的变化 (例如乱序到达的旧轨迹) 会被跳过。
+func (s *Shipments) Sync(ctx context.Context, id string) (*Shipment, error) {
+	shipment, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	carrier, err := s.carriers.Get(shipment.Carrier)
+	if err != nil {
+		return nil, err
+	}
+	mapper, ok := carrier.(StatusMapper)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not report tracking statuses", ErrUnsupported, shipment.Carrier)
+	}
+	info, err := carrier.Track(ctx, shipment.TrackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range info.Events {
+		if shipment.LastEventAt != nil && !ev.OccurredAt.After(*shipment.LastEventAt) {
+			continue
+		}
+		status, ok := mapper.ShipmentStatus(ev.Code)
+		if !ok || status == shipment.Status || !CanTransition(shipment.Status, status) {
+			continue
+		}
+		updated, _, err := s.Transition(ctx, id, Transition{
+			Status: status, Source: SourceTracking, Description: ev.Description, Location: ev.Location, OccurredAt: ev.OccurredAt,
+		})
+		if err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				continue
+			}
+			return nil, err
+		}
+		shipment.Status, shipment.LastEventAt = updated.Status, updated.LastEventAt
+	}
+	return s.Get(ctx, id)
+}
+
+// SyncActive 同步支持轨迹查询的承运商名下、未签收且超过 staleAfter 没有轮询过的运单, 返回同步成功的数量。
+// 每次轮询 (无论成功与否) 都会更新 last_polled_at, 最久没有轮询的运单排在最前, 失败的运单不会一直占住名额。
+// 单个运单同步失败只记录日志, 不影响其他运单。
+func (s *Shipments) SyncActive(ctx context.Context, staleAfter time.Duration, limit int) (int, error) {
+	codes := s.carriers.TrackingCarriers()
+	if len(codes) == 0 {
+		return 0, nil
+	}
+	args := []any{StatusDelivered}
+	for _, code := range codes {
+		args = append(args, code)
+	}
+	args = append(args, time.Now().Add(-staleAfter), limit)
+	shipments, err := s.query(ctx, `WHERE status <> ? AND carrier IN (`+strings.Repeat("?,", len(codes)-1)+`?)
+		AND (last_polled_at IS NULL OR last_polled_at < ?) ORDER BY last_polled_at, id LIMIT ?`, args...)
+	if err != nil {
+		return 0, err
+	}
+	synced := 0
+	for _, shipment := range shipments {
+		// 不改变 updated_at, 它只反映运单本身的变化
+		if _, err := s.db.ExecContext(ctx,
+			`UPDATE shipment SET last_polled_at = ?, updated_at = updated_at WHERE id = ?`, time.Now(), shipment.ID); err != nil {
+			return synced, fmt.Errorf("mark shipment polled: %w", err)
+		}
+		if _, err := s.Sync(ctx, shipment.ID); err != nil {
+			logger.Ctx(ctx).Error().Err(err).Str("shipment_id", shipment.ID).Msg("Failed to sync shipment tracking")
+			continue
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+func (s *Shipment) event(from, to string, t Transition) ShipmentEvent {
+	return ShipmentEvent{
+		ShipmentID:     s.ID,
+		OrderID:        s.OrderID,
+		Carrier:        s.Carrier,
+		TrackingNumber: s.TrackingNumber,
+		FromStatus:     from,
+		ToStatus:       to,
+		Source:         t.Source,
+		Description:    t.Description,
+		Location:       t.Location,
+		OccurredAt:     t.OccurredAt,
+	}
+}
+
+// insertEvent 在事务中记录一次状态变化, 并把它放进发件箱等待发布
+func insertEvent(ctx context.Context, tx *sql.Tx, e ShipmentEvent) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO shipment_event (shipment_id, from_status, to_status, source, description, location, occurred_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.ShipmentID, e.FromStatus, e.ToStatus, e.Source, e.Description, e.Location, e.OccurredAt)
+	if err != nil {
+		return fmt.Errorf("insert shipment event: %w", err)
+	}
+	return insertOutbox(ctx, tx, e)
+}
+
+func (s *Shipments) history(ctx context.Context, id string) ([]ShipmentEvent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT e.shipment_id, s.order_id, s.carrier, s.tracking_number, e.from_status, e.to_status, e.source, e.description, e.location, e.occurred_at
+		 FROM shipment_event e JOIN shipment s ON s.id = e.shipment_id WHERE e.shipment_id = ? ORDER BY e.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query shipment events: %w", err)
+	}
+	defer rows.Close()
+	events := []ShipmentEvent{}
+	for rows.Next() {
+		var e ShipmentEvent
+		if err := rows.Scan(&e.ShipmentID, &e.OrderID, &e.Carrier, &e.TrackingNumber, &e.FromStatus, &e.ToStatus,
+			&e.Source, &e.Description, &e.Location, &e.OccurredAt); err != nil {
+			return nil, fmt.Errorf("scan shipment event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+const shipmentColumns = `SELECT id, order_id, carrier, service_level, tracking_number, status, cost, currency,
+	parcel, from_address, to_address, last_event_at, created_at, updated_at FROM shipment`
+
+func (s *Shipments) query(ctx context.Context, where string, args ...any) ([]*Shipment, error) {
+	return scanShipments(s.db.QueryContext(ctx, shipmentColumns+" "+where, args...))
+}
+
+func scanShipments(rows *sql.Rows, err error) ([]*Shipment, error) {
+	if err != nil {
+		return nil, fmt.Errorf("query shipments: %w", err)
+	}
+	defer rows.Close()
+	shipments := []*Shipment{}
+	for rows.Next() {
+		var (
+			sh                          Shipment
+			cost, code                  string
+			parcel, fromAddr, toAddress []byte
+			lastEventAt                 sql.NullTime
+		)
+		if err := rows.Scan(&sh.ID, &sh.OrderID, &sh.Carrier, &sh.ServiceLevel, &sh.TrackingNumber, &sh.Status, &cost, &code,
+			&parcel, &fromAddr, &toAddress, &lastEventAt, &sh.CreatedAt, &sh.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan shipment: %w", err)
+		}
+		currency, err := money.LookupCurrency(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("shipment %s: %w", sh.ID, err)
+		}
+		if sh.Cost, err = money.Parse(cost, currency); err != nil {
+			return nil, fmt.Errorf("shipment %s cost: %w", sh.ID, err)
+		}
+		if err := unmarshalColumns(&sh, parcel, fromAddr, toAddress); err != nil {
+			return nil, err
+		}
+		if lastEventAt.Valid {
+			sh.LastEventAt = &lastEventAt.Time
+		}
+		shipments = append(shipments, &sh)
+	}
+	return shipments, rows.Err()
+}
+
+func unmarshalColumns(sh *Shipment, parcel, from, to []byte) error {
+	for _, c := range []struct {
+		raw []byte
+		out any
+	}{{parcel, &sh.Parcel}, {from, &sh.From}, {to, &sh.To}} {
+		if err := json.Unmarshal(c.raw, c.out); err != nil {
+			return fmt.Errorf("shipment %s: %w", sh.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// internal/shipping/shipment_events.go
+package shipping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/wangyingjie930/nexus-pkg/mq"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ShippingEventsTopic 是运单状态变化的主题, 以运单 ID 作为消息 key, 同一运单的事件有序
+const ShippingEventsTopic = "shipping-events"
+
+// EventPublisher 发布运单状态变化
+type EventPublisher interface {
+	PublishShipmentEvent(ctx context.Context, event ShipmentEvent) error
+}
+
+// KafkaEventPublisher 把运单状态变化写入 Kafka 的 shipping-events 主题
+type KafkaEventPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaEventPublisher 创建一个写入 shipping-events 主题的 EventPublisher
+func NewKafkaEventPublisher(brokers []string) *KafkaEventPublisher {
+	return &KafkaEventPublisher{writer: mq.NewKafkaWriter(brokers, ShippingEventsTopic)}
+}
+
+// PublishShipmentEvent 序列化事件并注入追踪上下文后发送
+func (p *KafkaEventPublisher) PublishShipmentEvent(ctx context.Context, event ShipmentEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal shipment event: %w", err)
+	}
+	return mq.ProduceMessage(ctx, p.writer, []byte(event.ShipmentID), value)
+}
+
+// Close 关闭底层的 Kafka writer
+func (p *KafkaEventPublisher) Close() error {
+	return p.writer.Close()
+}
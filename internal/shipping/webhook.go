@@ -0,0 +1,271 @@
+// internal/shipping/webhook.go
+package shipping
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 承运商推送的签名请求头。签名是 hex(HMAC-SHA256(密钥, 时间戳 + "." + nonce + "." + 请求体))
+const (
+	WebhookTimestampHeader = "X-Carrier-Timestamp" // Unix 秒
+	WebhookNonceHeader     = "X-Carrier-Nonce"
+	WebhookSignatureHeader = "X-Carrier-Signature"
+)
+
+// webhookNonceKeyPrefix 是已使用的 nonce 在 Redis 中的 key 前缀, 后面是承运商编码和 nonce
+const webhookNonceKeyPrefix = "shipping:webhook:nonce:"
+
+var (
+	// ErrInvalidSignature 推送缺少签名头、签名不正确, 或承运商没有配置推送密钥
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrReplayedWebhook 推送的时间戳超出允许的时间窗, 或 nonce 已经用过
+	ErrReplayedWebhook = errors.New("replayed webhook")
+	// ErrInvalidWebhook 推送的内容无法解析
+	ErrInvalidWebhook = errors.New("invalid webhook payload")
+)
+
+// 推送轨迹的处理结果
+const (
+	WebhookApplied         = "applied"          // 运单状态已更新
+	WebhookDuplicate       = "duplicate"        // 同一个事件 ID 已经处理过
+	WebhookIgnored         = "ignored"          // 无法映射的状态码、过期的轨迹或状态机不允许的变化
+	WebhookUnknownShipment = "unknown_shipment" // 找不到运单 (例如推送早于运单入库), 不记录事件 ID, 推送按可重试失败应答
+)
+
+var webhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "shipping_webhook_events_total",
+	Help: "Number of carrier webhook tracking events by carrier and outcome.",
+}, []string{"carrier", "outcome"})
+
+// CarrierEvent 是承运商推送的一条轨迹, EventID 由承运商生成, 重复推送时保持不变
+type CarrierEvent struct {
+	EventID        string
+	TrackingNumber string
+	TrackingEvent
+}
+
+// WebhookParser 由支持推送的承运商实现, 把推送的请求体解析为轨迹
+type WebhookParser interface {
+	ParseWebhook(body []byte) ([]CarrierEvent, error)
+}
+
+// WebhookResult 是一条推送轨迹的处理结果
+type WebhookResult struct {
+	EventID        string `json:"eventId"`
+	TrackingNumber string `json:"trackingNumber"`
+	ShipmentID     string `json:"shipmentId,omitempty"`
+	Outcome        string `json:"outcome"`
+	Status         string `json:"status,omitempty"` // 处理后的运单状态
+	Reason         string `json:"reason,omitempty"`
+}
+
+// SignWebhook 计算推送的签名, 承运商 (以及假承运商) 按同样的方式签名
+func SignWebhook(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NonceStore 记录用过的 nonce, 必须在所有实例间共享
+type NonceStore interface {
+	// Used 判断 nonce 是否已经登记过
+	Used(ctx context.Context, key string) (bool, error)
+	// Claim 登记 nonce, 在 ttl 内第一次出现时返回 true
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// RedisNonceStore 用 Redis 的 SET NX 登记 nonce
+type RedisNonceStore struct {
+	rdb redis.UniversalClient
+}
+
+// NewRedisNonceStore 创建基于 Redis 的 NonceStore
+func NewRedisNonceStore(rdb redis.UniversalClient) *RedisNonceStore {
+	return &RedisNonceStore{rdb: rdb}
+}
+
+func (s *RedisNonceStore) Used(ctx context.Context, key string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, webhookNonceKeyPrefix+key).Result()
+	return n > 0, err
+}
+
+func (s *RedisNonceStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.rdb.SetNX(ctx, webhookNonceKeyPrefix+key, 1, ttl).Result()
+}
+
+// WebhookVerifier 校验承运商推送的签名, 并按时间戳和 nonce 拒绝重放
+type WebhookVerifier struct {
+	carriers  *Registry
+	nonces    NonceStore
+	tolerance time.Duration
+}
+
+// NewWebhookVerifier 创建推送校验器, tolerance 是时间戳与本机时间允许的最大偏差
+func NewWebhookVerifier(carriers *Registry, nonces NonceStore, tolerance time.Duration) *WebhookVerifier {
+	return &WebhookVerifier{carriers: carriers, nonces: nonces, tolerance: tolerance}
+}
+
+// Verify 校验一次推送的签名、时间戳, 以及 nonce 是否已被成功处理过的推送用过。
+// 签名和时间戳错误分别返回 ErrInvalidSignature 和 ErrReplayedWebhook;
+// 无法查询 nonce 时返回其他错误, 调用方应当让承运商稍后重试。
+// Verify 只检查不登记: 推送处理成功后再调用 Consume 登记 nonce, 处理失败 (5xx) 后承运商原样重试不会被当作重放。
+func (v *WebhookVerifier) Verify(ctx context.Context, carrier string, header http.Header, body []byte) error {
+	secret, ok := v.carriers.webhookSecret(carrier)
+	if !ok {
+		return fmt.Errorf("%w: no webhook secret configured for %s", ErrInvalidSignature, carrier)
+	}
+	timestamp, nonce := header.Get(WebhookTimestampHeader), header.Get(WebhookNonceHeader)
+	signature := strings.ToLower(strings.TrimSpace(header.Get(WebhookSignatureHeader)))
+	if timestamp == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("%w: missing %s, %s or %s header", ErrInvalidSignature,
+			WebhookTimestampHeader, WebhookNonceHeader, WebhookSignatureHeader)
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, nonce, body))) {
+		return fmt.Errorf("%w: signature mismatch for %s", ErrInvalidSignature, carrier)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidSignature, timestamp)
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > v.tolerance || skew < -v.tolerance {
+		return fmt.Errorf("%w: timestamp is %v away from now, tolerance is %v", ErrReplayedWebhook, skew.Round(time.Second), v.tolerance)
+	}
+	if v.nonces == nil {
+		return fmt.Errorf("nonce store unavailable")
+	}
+	used, err := v.nonces.Used(ctx, carrier+":"+nonce)
+	if err != nil {
+		return fmt.Errorf("check webhook nonce: %w", err)
+	}
+	if used {
+		return fmt.Errorf("%w: nonce %q already used", ErrReplayedWebhook, nonce)
+	}
+	return nil
+}
+
+// Consume 在推送处理成功后登记它的 nonce, 之后同一个 nonce 的请求会被 Verify 拒绝。
+// 两个相同的推送并发通过 Verify 时都会被处理, 事件 ID 去重保证轨迹只应用一次。
+// 保留两倍的时间窗就够了, 更早的重放已经被时间戳拒绝。
+func (v *WebhookVerifier) Consume(ctx context.Context, carrier string, header http.Header) error {
+	if v.nonces == nil {
+		return fmt.Errorf("nonce store unavailable")
+	}
+	if _, err := v.nonces.Claim(ctx, carrier+":"+header.Get(WebhookNonceHeader), 2*v.tolerance); err != nil {
+		return fmt.Errorf("claim webhook nonce: %w", err)
+	}
+	return nil
+}
+
+// HandleWebhook 解析一次已校验过的推送, 把每条轨迹依次应用到运单的状态机上。
+// 已处理的轨迹按承运商的事件 ID 去重, 推送部分失败后承运商整体重试是安全的。
+func (s *Shipments) HandleWebhook(ctx context.Context, carrierCode string, body []byte) ([]WebhookResult, error) {
+	carrier, err := s.carriers.Get(carrierCode)
+	if err != nil {
+		return nil, err
+	}
+	parser, ok := carrier.(WebhookParser)
+	mapper, hasMapper := carrier.(StatusMapper)
+	if !ok || !hasMapper {
+		return nil, fmt.Errorf("%w: %s does not push tracking events", ErrUnsupported, carrierCode)
+	}
+	events, err := parser.ParseWebhook(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	results := make([]WebhookResult, 0, len(events))
+	for _, ev := range events {
+		result, err := s.applyCarrierEvent(ctx, carrierCode, mapper, ev)
+		if err != nil {
+			return results, err
+		}
+		webhookEvents.WithLabelValues(carrierCode, result.Outcome).Inc()
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// applyCarrierEvent 在一个事务中登记事件 ID 并更新运单状态, 事件 ID 已登记过时不做任何变更
+func (s *Shipments) applyCarrierEvent(ctx context.Context, carrier string, mapper StatusMapper, ev CarrierEvent) (WebhookResult, error) {
+	result := WebhookResult{EventID: ev.EventID, TrackingNumber: ev.TrackingNumber}
+	if ev.EventID == "" || ev.TrackingNumber == "" {
+		return result, fmt.Errorf("%w: event needs an eventId and a trackingNumber", ErrInvalidWebhook)
+	}
+	if ev.OccurredAt.IsZero() {
+		ev.OccurredAt = time.Now()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	shipments, err := scanShipments(tx.QueryContext(ctx,
+		shipmentColumns+` WHERE carrier = ? AND tracking_number = ? FOR UPDATE`, carrier, ev.TrackingNumber))
+	if err != nil {
+		return result, err
+	}
+	if len(shipments) == 0 {
+		result.Outcome = WebhookUnknownShipment
+		return result, nil
+	}
+	shipment := shipments[0]
+	result.ShipmentID, result.Status = shipment.ID, shipment.Status
+
+	status, mapped := mapper.ShipmentStatus(ev.Code)
+	result.Outcome = WebhookIgnored
+	switch {
+	case !mapped:
+		result.Reason = fmt.Sprintf("code %q does not map to a shipment status", ev.Code)
+	case shipment.LastEventAt != nil && !ev.OccurredAt.After(*shipment.LastEventAt):
+		result.Reason = "event is older than the last applied tracking event"
+	case status == shipment.Status:
+		result.Reason = "shipment is already " + status
+	case !CanTransition(shipment.Status, status):
+		result.Reason = fmt.Sprintf("transition %s -> %s is not allowed", shipment.Status, status)
+	default:
+		result.Outcome = WebhookApplied
+	}
+
+	// 运单行已加锁, 同一运单的推送串行处理; 唯一键 (carrier, event_id) 保证同一事件只处理一次
+	res, err := tx.ExecContext(ctx,
+		`INSERT IGNORE INTO shipment_webhook_event (carrier, event_id, shipment_id, code, outcome) VALUES (?, ?, ?, ?, ?)`,
+		carrier, ev.EventID, shipment.ID, ev.Code, result.Outcome)
+	if err != nil {
+		return result, fmt.Errorf("record webhook event: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return result, err
+	} else if n == 0 {
+		result.Outcome, result.Reason = WebhookDuplicate, ""
+		return result, nil
+	}
+
+	if result.Outcome == WebhookApplied {
+		if _, err = apply(ctx, tx, shipment, Transition{
+			Status: status, Source: SourceWebhook, Description: ev.Description, Location: ev.Location, OccurredAt: ev.OccurredAt,
+		}); err != nil {
+			return result, err
+		}
+		result.Status = shipment.Status
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	if result.Outcome == WebhookApplied {
+		s.notifyOutbox()
+	}
+	return result, nil
+}
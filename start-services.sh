@@ -27,6 +27,7 @@ export INVENTORY_RELEASE_URL="http://localhost:8082/release_stock"
 export INVENTORY_RESERVE_URL="http://localhost:8082/reserve_stock"
 export NOTIFICATION_SERVICE_URL="http://localhost:8083"
 export PRICING_SERVICE_URL="http://localhost:8084/calculate_price"
+export PRICING_SERVICE_BASE_URL="http://localhost:8084"
 export PROMOTION_SERVICE_URL="http://localhost:8087/get_promo_price"
 export SHIPPING_SERVICE_URL="http://localhost:8086/get_quote"
 export DB_SOURCE="root:root@tcp(mysql.infra:3306)/test"
@@ -36,6 +37,13 @@ export REDIS_ADDRS="redis-cluster-0.redis-cluster-headless.infra:6379,redis-clus
 export NACOS_NAMESPACE="d586122c-170f-40e9-9d17-5cede728cd7e" # 假设这是开发环境的Namespace ID
 export NACOS_GROUP="nexus-group"   # 为项目所有服务定义一个统一的分组
 
+# pricing-service 报价令牌的签名密钥不写在脚本里, 需要在启动前由调用方设置, 例如:
+#   export PRICING_QUOTE_SECRET="$(openssl rand -hex 32)"
+if [ -z "${PRICING_QUOTE_SECRET:-}" ]; then
+    echo -e "${RED}❌ 未设置 PRICING_QUOTE_SECRET, pricing-service 无法签发报价令牌${NC}"
+    exit 1
+fi
+
 # <<<<<<< 改造点: 增加新服务 >>>>>>>>>
 SERVICES=(
 #    "api-gateway:8080"
@@ -46,6 +54,7 @@ SERVICES=(
     "pricing:8084"
     "fraud-detection:8085"
     "shipping:8086"
+    "fake-carrier:8090" # 本地假承运商, 供 shipping-service 离线联调
     "delay-scheduler"
 )
 # <<<<<<< 改造点结束 >>>>>>>>>
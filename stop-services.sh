@@ -70,7 +70,7 @@ if [[ $REPLY =~ ^[Yy]$ ]]; then
 fi
 
 # 强制清理残留端口进程
-for port in 8080 8081 8082 8083 8084 8085 8086; do
+for port in 8080 8081 8082 8083 8084 8085 8086 8090; do
     pid=$(lsof -ti tcp:$port)
     if [ -n "$pid" ]; then
         echo -e "${YELLOW}⚠️  端口 $port 仍被进程 $pid 占用，强制杀死...${NC}"